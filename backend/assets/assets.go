@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/dkumor/revhttpfs"
@@ -82,8 +83,9 @@ type Assets struct {
 	LogFile *os.File
 }
 
-// Reload the assets from scratch
-func (a *Assets) Reload() error {
+// load reads and merges the configuration from the builtin assets, the active plugins, and the root
+// configuration file, and prepares the overlay filesystem, without modifying the assets.
+func (a *Assets) load() (*Configuration, afero.Fs, []afero.Fs, error) {
 
 	assetStack := make([]afero.Fs, 1)
 
@@ -93,11 +95,11 @@ func (a *Assets) Reload() error {
 	// First, we load the configuration from the builtin assets
 	baseConfigBytes, err := afero.ReadFile(builtinAssets, "/heedy.conf")
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	baseConfiguration, err := LoadConfigBytes(baseConfigBytes, "heedy.conf")
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	// Some plugins come built-in. Check for the built-in plugins
@@ -105,7 +107,7 @@ func (a *Assets) Reload() error {
 		for _, v := range *baseConfiguration.ActivePlugins {
 			_, ok := baseConfiguration.Plugins[v]
 			if !ok {
-				return fmt.Errorf("Builtin configuration does not define plugin '%s'", v)
+				return nil, nil, nil, fmt.Errorf("Builtin configuration does not define plugin '%s'", v)
 			}
 		}
 	}
@@ -126,7 +128,7 @@ func (a *Assets) Reload() error {
 		// Make sure the folder path is absolute
 		a.FolderPath, err = filepath.Abs(a.FolderPath)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 
 		// The os filesystem
@@ -136,7 +138,7 @@ func (a *Assets) Reload() error {
 		configPath := path.Join(a.FolderPath, "heedy.conf")
 		rootConfiguration, err := LoadConfigFile(configPath)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 
 		if a.ConfigOverride != nil {
@@ -156,16 +158,16 @@ func (a *Assets) Reload() error {
 					pluginFolder := path.Join(a.FolderPath, "plugins", pluginName)
 					pluginFolderStats, err := os.Stat(pluginFolder)
 					if err != nil {
-						return err
+						return nil, nil, nil, err
 					}
 					if !pluginFolderStats.IsDir() {
-						return fmt.Errorf("Could not find plugin %s at %s: not a directory", pluginName, pluginFolder)
+						return nil, nil, nil, fmt.Errorf("Could not find plugin %s at %s: not a directory", pluginName, pluginFolder)
 					}
 
 					configPath := path.Join(pluginFolder, "heedy.conf")
 					pluginConfiguration, err := LoadConfigFile(configPath)
 					if err != nil {
-						return err
+						return nil, nil, nil, err
 					}
 					mergedConfiguration = MergeConfig(mergedConfiguration, pluginConfiguration)
 
@@ -193,12 +195,12 @@ func (a *Assets) Reload() error {
 
 	addr, err := ParseAddress(a.DataDir(), c.GetAddr())
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	c.Addr = &addr
 	api, err := ParseAddress(a.DataDir(), c.GetAPI())
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	c.API = &api
 
@@ -209,7 +211,7 @@ func (a *Assets) Reload() error {
 		} else {
 			host, port, err := net.SplitHostPort(*c.Addr)
 			if err != nil {
-				return err
+				return nil, nil, nil, err
 			}
 			if host == "" {
 				host = GetOutboundIP()
@@ -223,6 +225,26 @@ func (a *Assets) Reload() error {
 		c.URL = &noslash
 	}
 
+	// Plugins are started in order, so make sure that each plugin comes after the plugins it requires.
+	// If a required plugin is not active, the order is left unchanged, and Validate reports the missing plugin.
+	ap, err := ResolvePlugins(c.Plugins, c.GetActivePlugins())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(ap) == len(c.GetActivePlugins()) {
+		c.ActivePlugins = &ap
+	}
+
+	return c, FS, assetStack, nil
+}
+
+// Reload the assets from scratch
+func (a *Assets) Reload() error {
+	c, FS, assetStack, err := a.load()
+	if err != nil {
+		return err
+	}
+
 	// Set the new config and assets
 	a.Config = c
 	a.FS = FS
@@ -276,6 +298,67 @@ func (a *Assets) Reload() error {
 	return nil
 }
 
+// LoadPluginConfig re-reads the configuration from disk, and returns the given plugin's new configuration,
+// without modifying the active configuration. Only the plugin's own block of the configuration can be reloaded:
+// if the configuration on disk also differs elsewhere, for example in the runtypes or object types that the
+// plugin defines, heedy needs to be restarted for the changes to take effect, and an error is returned.
+func (a *Assets) LoadPluginConfig(pname string) (*Plugin, error) {
+	c, _, _, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	if err = Validate(c); err != nil {
+		return nil, err
+	}
+	active := false
+	for _, p := range c.GetActivePlugins() {
+		if p == pname {
+			active = true
+		}
+	}
+	if !active {
+		return nil, fmt.Errorf("Plugin '%s' is not active", pname)
+	}
+
+	a.Config.RLock()
+	current, err := configWithoutPlugin(a.Config, pname)
+	a.Config.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	reloaded, err := configWithoutPlugin(c, pname)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(current, reloaded) {
+		return nil, fmt.Errorf("The configuration changed outside of plugin '%s', so heedy needs to be restarted to apply it", pname)
+	}
+	return c.Plugins[pname], nil
+}
+
+// configWithoutPlugin returns the json representation of the configuration, excluding the given plugin's block
+func configWithoutPlugin(c *Configuration, pname string) (map[string]interface{}, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if plugins, ok := m["plugin"].(map[string]interface{}); ok {
+		delete(plugins, pname)
+	}
+	return m, nil
+}
+
+// SetPluginConfig replaces the given plugin's configuration in the active configuration
+func (a *Assets) SetPluginConfig(pname string, p *Plugin) {
+	a.Config.Lock()
+	a.Config.Plugins[pname] = p
+	a.Config.Unlock()
+}
+
 // Abs returns config-relative absolute paths
 func (a *Assets) Abs(p string) string {
 	if filepath.IsAbs(p) {
@@ -66,6 +66,23 @@ type Run struct {
 	Enabled *bool                  `hcl:"enabled" json:"enabled,omitempty"`
 	Cron    *string                `hcl:"cron" json:"cron,omitempty"`
 	Config  map[string]interface{} `json:"config,omitempty"`
+
+	// The restart policy of the runner if its process exits: "no" (default), "on-failure" or "always".
+	// Restarts are delayed by restart_delay, which doubles with each consecutive restart.
+	Restart      *string `hcl:"restart" json:"restart,omitempty"`
+	MaxRestarts  *int    `hcl:"max_restarts" json:"max_restarts,omitempty"`
+	RestartDelay *string `hcl:"restart_delay" json:"restart_delay,omitempty"`
+
+	// Limits on the runner's process. The memory limit is a size such as "512MB", and the cpu limit
+	// is the total CPU time the process may use, such as "1h". If writable_dirs is set, the process can
+	// only write within the given directories. clean_env removes all but basic environment variables
+	// (and those in env_allowlist), and user runs the process as the given user.
+	MemoryLimit  *string   `hcl:"memory_limit" json:"memory_limit,omitempty"`
+	CPULimit     *string   `hcl:"cpu_limit" json:"cpu_limit,omitempty"`
+	WritableDirs *[]string `hcl:"writable_dirs" json:"writable_dirs,omitempty"`
+	CleanEnv     *bool     `hcl:"clean_env" json:"clean_env,omitempty"`
+	EnvAllowlist *[]string `hcl:"env_allowlist" json:"env_allowlist,omitempty"`
+	User         *string   `hcl:"user" json:"user,omitempty"`
 }
 
 type Plugin struct {
@@ -76,6 +93,10 @@ type Plugin struct {
 	License      *string `hcl:"license" json:"license,omitempty"`
 	HeedyVersion *string `hcl:"heedy_version" json:"heedy_version,omitempty"`
 
+	// Requires maps the names of plugins that this plugin depends on to the semver range
+	// of their versions that it supports. An empty range or "*" accepts any version.
+	Requires *map[string]string `hcl:"requires" json:"requires,omitempty"`
+
 	Frontend *string   `json:"frontend,omitempty" hcl:"frontend,block" cty:"frontend"`
 	Preload  *[]string `json:"preload,omitempty" hcl:"preload"`
 
@@ -290,6 +311,15 @@ type Configuration struct {
 	VerboseLogBuffer *int `json:"verbose_log_buffer,omitempty"`
 	MaxBackupCount   *int `json:"max_backup_count,omitempty"`
 
+	// The size in bytes at which plugin log files are rotated, and the number of rotated files to keep
+	PluginLogSize  *int64 `json:"plugin_log_size,omitempty"`
+	PluginLogFiles *int   `json:"plugin_log_files,omitempty"`
+
+	// The base64-encoded ed25519 public keys of publishers whose plugin signatures are trusted,
+	// and whether plugins must be signed by one of them to be installed
+	TrustedPublishers       *[]string `json:"trusted_publishers,omitempty"`
+	RequirePluginSignatures *bool     `json:"require_plugin_signatures,omitempty"`
+
 	userSettingsSchema *JSONSchema
 }
 
@@ -0,0 +1,107 @@
+package assets
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blang/semver/v4"
+	"github.com/heedy/heedy/backend/buildinfo"
+)
+
+// CheckHeedyVersion returns an error if the plugin does not support the running version of heedy
+func (p *Plugin) CheckHeedyVersion(name string) error {
+	if p.HeedyVersion == nil {
+		return nil
+	}
+	vrange, err := semver.ParseRange(*p.HeedyVersion)
+	if err != nil {
+		return fmt.Errorf("Plugin '%s' heedy_version invalid: %s", name, err.Error())
+	}
+	if !vrange(heedy_semver) {
+		return fmt.Errorf("Plugin '%s' is not compatible with Heedy version %s, only %s accepted", name, buildinfo.Version, *p.HeedyVersion)
+	}
+	return nil
+}
+
+// checkRequirement makes sure that the required plugin's version is within the given range
+func checkRequirement(name, reqName, reqRange string, req *Plugin) error {
+	if reqRange == "" || reqRange == "*" {
+		return nil
+	}
+	vrange, err := semver.ParseRange(reqRange)
+	if err != nil {
+		return fmt.Errorf("Plugin '%s' has invalid version range for required plugin '%s': %s", name, reqName, err.Error())
+	}
+	if req.Version == nil {
+		return fmt.Errorf("Plugin '%s' requires '%s' %s, but the version of '%s' is unknown", name, reqName, reqRange, reqName)
+	}
+	v, err := semver.ParseTolerant(*req.Version)
+	if err != nil {
+		return fmt.Errorf("Plugin '%s' has invalid version '%s'", reqName, *req.Version)
+	}
+	if !vrange(v) {
+		return fmt.Errorf("Plugin '%s' requires '%s' %s, but version %s is installed", name, reqName, reqRange, *req.Version)
+	}
+	return nil
+}
+
+// ResolvePlugins returns the given plugins together with all of the plugins they require,
+// ordered so that each plugin comes after its dependencies. The plugins map must hold the
+// configuration of all available plugins. An error is returned if a required plugin is not available,
+// if a plugin is not compatible with the current heedy version or with the version of a plugin it requires,
+// or if the plugins require each other in a cycle.
+func ResolvePlugins(plugins map[string]*Plugin, names []string) ([]string, error) {
+	result := make([]string, 0, len(names))
+	// visiting is true while a plugin's dependencies are being resolved, and false once it is in result
+	visiting := make(map[string]bool)
+
+	var visit func(name, requiredBy string) error
+	visit = func(name, requiredBy string) error {
+		if v, ok := visiting[name]; ok {
+			if v {
+				return fmt.Errorf("Plugin '%s' has a circular dependency on '%s'", requiredBy, name)
+			}
+			return nil
+		}
+		p, ok := plugins[name]
+		if !ok {
+			if requiredBy != "" {
+				return fmt.Errorf("Plugin '%s' requires '%s', which is not installed", requiredBy, name)
+			}
+			return fmt.Errorf("Plugin '%s' is not installed", name)
+		}
+		if err := p.CheckHeedyVersion(name); err != nil {
+			return err
+		}
+		visiting[name] = true
+		if p.Requires != nil {
+			// Sort the requirements so that the resulting order is deterministic
+			reqs := make([]string, 0, len(*p.Requires))
+			for r := range *p.Requires {
+				reqs = append(reqs, r)
+			}
+			sort.Strings(reqs)
+			for _, r := range reqs {
+				if r == name {
+					return fmt.Errorf("Plugin '%s' can't require itself", name)
+				}
+				if err := visit(r, name); err != nil {
+					return err
+				}
+				if err := checkRequirement(name, r, (*p.Requires)[r], plugins[r]); err != nil {
+					return err
+				}
+			}
+		}
+		visiting[name] = false
+		result = append(result, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
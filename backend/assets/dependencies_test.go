@@ -0,0 +1,43 @@
+package assets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePlugins(t *testing.T) {
+	v1 := "1.2.0"
+	v2 := "2.0.0"
+	tooNew := ">=1000.0.0"
+	plugins := map[string]*Plugin{
+		"base":   {Version: &v1},
+		"middle": {Version: &v2, Requires: &map[string]string{"base": ">=1.0.0 <2.0.0"}},
+		"top":    {Requires: &map[string]string{"middle": ">=2.0.0", "base": "*"}},
+		"old":    {Requires: &map[string]string{"middle": "<2.0.0"}},
+		"future": {HeedyVersion: &tooNew},
+		"broken": {Requires: &map[string]string{"missing": ""}},
+		"cycle1": {Requires: &map[string]string{"cycle2": ""}},
+		"cycle2": {Requires: &map[string]string{"cycle1": ""}},
+	}
+
+	// Dependencies are added, and come before the plugins that require them
+	r, err := ResolvePlugins(plugins, []string{"top"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"base", "middle", "top"}, r)
+
+	r, err = ResolvePlugins(plugins, []string{"base", "top", "middle"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"base", "middle", "top"}, r)
+
+	_, err = ResolvePlugins(plugins, []string{"old"})
+	require.Error(t, err)
+	_, err = ResolvePlugins(plugins, []string{"future"})
+	require.Error(t, err)
+	_, err = ResolvePlugins(plugins, []string{"broken"})
+	require.Error(t, err)
+	_, err = ResolvePlugins(plugins, []string{"notaplugin"})
+	require.Error(t, err)
+	_, err = ResolvePlugins(plugins, []string{"cycle1"})
+	require.Error(t, err)
+}
@@ -1,17 +1,24 @@
 package assets
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// defaultRequestBodyByteLimit is used if the configuration doesn't set request_body_byte_limit
+const defaultRequestBodyByteLimit = 4e6
+
 func (c *Configuration) GetRequestBodyByteLimit() int64 {
 	c.RLock()
 	defer c.RUnlock()
 	if c.RequestBodyByteLimit != nil {
 		return *c.RequestBodyByteLimit
 	}
-	return 0
+	return defaultRequestBodyByteLimit
 }
 
 func (c *Configuration) GetAddr() string {
@@ -127,3 +134,43 @@ func (c *Configuration) GetRunTimeout() time.Duration {
 	d, _ := time.ParseDuration(("5s"))
 	return d
 }
+
+var byteSizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseByteSize parses a size such as "512MB" or "1GB" into bytes. The units are powers of 1024.
+func ParseByteSize(s string) (int64, error) {
+	us := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(us, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(us)
+	}
+	mult, ok := byteSizeUnits[strings.TrimSpace(us[i:])]
+	v, err := strconv.ParseFloat(us[:i], 64)
+	if !ok || err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// ParsePublicKey decodes a base64-encoded ed25519 public key, such as those in trusted_publishers
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public keys must be %d bytes long", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -27,6 +27,17 @@ type hclRun struct {
 	Enabled *bool   `hcl:"enabled" json:"enabled,omitempty"`
 	Cron    *string `hcl:"cron" json:"cron,omitempty"`
 
+	Restart      *string `hcl:"restart" json:"restart,omitempty"`
+	MaxRestarts  *int    `hcl:"max_restarts" json:"max_restarts,omitempty"`
+	RestartDelay *string `hcl:"restart_delay" json:"restart_delay,omitempty"`
+
+	MemoryLimit  *string   `hcl:"memory_limit" json:"memory_limit,omitempty"`
+	CPULimit     *string   `hcl:"cpu_limit" json:"cpu_limit,omitempty"`
+	WritableDirs *[]string `hcl:"writable_dirs" json:"writable_dirs,omitempty"`
+	CleanEnv     *bool     `hcl:"clean_env" json:"clean_env,omitempty"`
+	EnvAllowlist *[]string `hcl:"env_allowlist" json:"env_allowlist,omitempty"`
+	User         *string   `hcl:"user" json:"user,omitempty"`
+
 	// Everything that remains is config specific to the runner
 	Config hcl.Body `hcl:",remain"`
 }
@@ -78,6 +89,8 @@ type hclPlugin struct {
 	License      *string `hcl:"license" json:"license"`
 	HeedyVersion *string `hcl:"heedy_version" json:"heedy_version"`
 
+	Requires *map[string]string `hcl:"requires" json:"requires,omitempty"`
+
 	Frontend *string   `hcl:"frontend" json:"frontend"`
 	Preload  *[]string `json:"preload,omitempty" hcl:"preload"`
 
@@ -152,6 +165,12 @@ type hclConfiguration struct {
 
 	VerboseLogBuffer *int `hcl:"verbose_log_buffer" json:"verbose_log_buffer,omitempty"`
 	MaxBackupCount   *int `hcl:"max_backup_count" json:"max_backup_count,omitempty"`
+
+	PluginLogSize  *int64 `hcl:"plugin_log_size" json:"plugin_log_size,omitempty"`
+	PluginLogFiles *int   `hcl:"plugin_log_files" json:"plugin_log_files,omitempty"`
+
+	TrustedPublishers       *[]string `hcl:"trusted_publishers" json:"trusted_publishers,omitempty"`
+	RequirePluginSignatures *bool     `hcl:"require_plugin_signatures" json:"require_plugin_signatures,omitempty"`
 }
 
 func loadJSONObject(v *cty.Value) (*map[string]interface{}, error) {
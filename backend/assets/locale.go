@@ -0,0 +1,78 @@
+package assets
+
+import (
+	"time"
+)
+
+// UserLocale holds the timezone, locale and unit system of a user, taken from the core heedy
+// user settings, with the server defaults filled in for unset values.
+type UserLocale struct {
+	Timezone   string `json:"timezone"`
+	Locale     string `json:"locale"`
+	UnitSystem string `json:"unit_system"`
+
+	location *time.Location
+}
+
+// GetUserLocale returns the locale given the user's core heedy settings. A nil settings map
+// gives the server's default locale.
+func (c *Configuration) GetUserLocale(settings map[string]interface{}) *UserLocale {
+	l := &UserLocale{
+		UnitSystem: "metric",
+	}
+	if v, ok := settings["timezone"].(string); ok {
+		l.Timezone = v
+	}
+	if v, ok := settings["locale"].(string); ok {
+		l.Locale = v
+	}
+	if v, ok := settings["unit_system"].(string); ok && v != "" {
+		l.UnitSystem = v
+	}
+
+	if l.Locale == "" {
+		c.RLock()
+		if c.Language != nil && *c.Language != "" {
+			l.Locale = *c.Language
+		} else if c.FallbackLanguage != nil && *c.FallbackLanguage != "" {
+			l.Locale = *c.FallbackLanguage
+		} else {
+			l.Locale = "en"
+		}
+		c.RUnlock()
+	}
+
+	loc, err := time.LoadLocation(l.Timezone)
+	if err != nil || l.Timezone == "" {
+		// An invalid or empty timezone uses the server's timezone
+		loc = time.Local
+		l.Timezone = loc.String()
+	}
+	l.location = loc
+
+	return l
+}
+
+// Location returns the user's timezone
+func (l *UserLocale) Location() *time.Location {
+	if l == nil || l.location == nil {
+		return time.Local
+	}
+	return l.location
+}
+
+// FormatTime returns the given unix timestamp as an RFC3339 string in the user's timezone
+func (l *UserLocale) FormatTime(t float64) string {
+	sec := int64(t)
+	return time.Unix(sec, int64((t-float64(sec))*1e9)).In(l.Location()).Format(time.RFC3339)
+}
+
+// ValidTimezone checks whether the given timezone name is recognized. The empty string
+// is valid, and represents the server's timezone.
+func ValidTimezone(tz string) bool {
+	if tz == "" {
+		return true
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
@@ -111,19 +111,25 @@ func Validate(c *Configuration) error {
 	}
 
 	// Make sure all the active plugins have valid configurations
+	activePlugins := make(map[string]bool)
+	for _, p := range c.GetActivePlugins() {
+		activePlugins[p] = true
+	}
 	for _, p := range c.GetActivePlugins() {
 		v, ok := c.Plugins[p]
 		if !ok {
 			return fmt.Errorf("Plugin '%s' config not found", p)
 		}
 		// Make sure the plugin will run with the current heedy version
-		if v.HeedyVersion != nil {
-			vrange, err := semver.ParseRange(*v.HeedyVersion)
-			if err != nil {
-				return fmt.Errorf("Plugin '%s' heedy_version invalid: %s", p, err.Error())
-			}
-			if !vrange(heedy_semver) {
-				return fmt.Errorf("Plugin '%s' is not compatible with Heedy version %s, only %s accepted", p, buildinfo.Version, *v.HeedyVersion)
+		if err := v.CheckHeedyVersion(p); err != nil {
+			return err
+		}
+		// ...and that all the plugins it requires are active
+		if v.Requires != nil {
+			for r := range *v.Requires {
+				if !activePlugins[r] {
+					return fmt.Errorf("Plugin '%s' requires '%s', which is not active", p, r)
+				}
 			}
 		}
 
@@ -142,6 +148,10 @@ func Validate(c *Configuration) error {
 			return err
 		}
 	}
+	// Check the versions of required plugins, and make sure that there are no dependency cycles
+	if _, err := ResolvePlugins(c.Plugins, c.GetActivePlugins()); err != nil {
+		return err
+	}
 
 	if c.RunTimeout != nil {
 		_, err := time.ParseDuration(*c.RunTimeout)
@@ -161,11 +171,18 @@ func Validate(c *Configuration) error {
 			return errors.New("Invalid websocket_write_timeout")
 		}
 	}
+	if c.TrustedPublishers != nil {
+		for _, k := range *c.TrustedPublishers {
+			if _, err := ParsePublicKey(k); err != nil {
+				return fmt.Errorf("Invalid trusted_publishers key '%s': %w", k, err)
+			}
+		}
+	}
 
 	// Now make sure all runners are set up correctly
 	runners := make(map[string]*JSONSchema)
 	for k, v := range c.RunTypes {
-		if v.API == nil && k != "exec" && k != "builtin" {
+		if v.API == nil && k != "exec" && k != "builtin" && k != "wasm" {
 			return fmt.Errorf("RunType '%s' doesn't specify an API target", k)
 		} else if v.API != nil {
 			if err := isValidTarget(c, "", *v.API); err != nil {
@@ -196,6 +213,27 @@ func Validate(c *Configuration) error {
 			if err := s.ValidateAndInsertDefaults(r.Config); err != nil {
 				return err
 			}
+			if r.Restart != nil && *r.Restart != "no" && *r.Restart != "on-failure" && *r.Restart != "always" {
+				return fmt.Errorf("Plugin '%s' has invalid restart policy '%s'", pname, *r.Restart)
+			}
+			if r.MaxRestarts != nil && *r.MaxRestarts < 0 {
+				return fmt.Errorf("Plugin '%s' max_restarts must be non-negative", pname)
+			}
+			if r.RestartDelay != nil {
+				if _, err := time.ParseDuration(*r.RestartDelay); err != nil {
+					return fmt.Errorf("Plugin '%s' has invalid restart_delay: %s", pname, err.Error())
+				}
+			}
+			if r.MemoryLimit != nil {
+				if v, err := ParseByteSize(*r.MemoryLimit); err != nil || v == 0 {
+					return fmt.Errorf("Plugin '%s' has invalid memory_limit '%s'", pname, *r.MemoryLimit)
+				}
+			}
+			if r.CPULimit != nil {
+				if d, err := time.ParseDuration(*r.CPULimit); err != nil || d < time.Second {
+					return fmt.Errorf("Plugin '%s' has invalid cpu_limit '%s' (must be at least 1s)", pname, *r.CPULimit)
+				}
+			}
 		}
 	}
 
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/heedy/heedy/backend/plugins/run"
+)
+
+// SandboxCmd is used internally to start plugin processes that have resource limits.
+// It applies the limits to itself, and then replaces itself with the plugin's command.
+var SandboxCmd = &cobra.Command{
+	Use:                "sandbox [command] [args...]",
+	Short:              "Runs a plugin command with resource limits (used internally)",
+	Hidden:             true,
+	DisableFlagParsing: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := run.SandboxExec(args)
+		// SandboxExec only returns if the command could not be run
+		fmt.Fprintf(os.Stderr, "heedy sandbox: %s\n", err)
+		os.Exit(126)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(SandboxCmd)
+}
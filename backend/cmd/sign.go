@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/heedy/heedy/backend/updater"
+)
+
+var (
+	signKeyFile string
+	signOutput  string
+	signGenKey  bool
+)
+
+// SignCmd signs plugin zip files, so that heedy servers that trust the publisher's key can verify them
+var SignCmd = &cobra.Command{
+	Use:   "sign [plugin.zip]",
+	Short: "Signs a plugin zip file",
+	Long: `Adds a manifest of file hashes to a plugin zip file, signed with the given private key.
+Servers that include the corresponding public key in trusted_publishers will accept the plugin even if
+require_plugin_signatures is set.
+
+To create a new signing key, run:
+
+  heedy sign --genkey --key ./mykey
+
+which writes the private key to ./mykey, and prints the public key to add to trusted_publishers. Then sign a plugin with:
+
+  heedy sign ./myplugin.zip --key ./mykey
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if signKeyFile == "" {
+			return errors.New("A private key file must be given with --key")
+		}
+		if signGenKey {
+			if _, err := os.Stat(signKeyFile); err == nil {
+				return fmt.Errorf("The key file %s already exists", signKeyFile)
+			}
+			pub, priv, err := updater.GenerateSigningKey()
+			if err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(signKeyFile, []byte(priv), 0600); err != nil {
+				return err
+			}
+			fmt.Printf("Public key: %s\n", pub)
+			return nil
+		}
+		if len(args) != 1 {
+			return errors.New("Must specify the plugin zip file to sign")
+		}
+		b, err := ioutil.ReadFile(signKeyFile)
+		if err != nil {
+			return err
+		}
+		key, err := updater.ParsePrivateKey(string(b))
+		if err != nil {
+			return err
+		}
+		out := signOutput
+		if out == "" {
+			out = args[0]
+		}
+
+		// Sign into a temporary file, since the output can overwrite the input
+		tmpFile, err := ioutil.TempFile(filepath.Dir(out), "heedy-sign-*.zip")
+		if err != nil {
+			return err
+		}
+		tmpFile.Close()
+		defer os.Remove(tmpFile.Name())
+		if err = updater.SignPlugin(args[0], tmpFile.Name(), key); err != nil {
+			return err
+		}
+		return os.Rename(tmpFile.Name(), out)
+	},
+}
+
+func init() {
+	SignCmd.Flags().StringVarP(&signKeyFile, "key", "k", "", "File holding the base64-encoded ed25519 private key")
+	SignCmd.Flags().StringVarP(&signOutput, "output", "o", "", "Write the signed plugin to this file instead of replacing the original")
+	SignCmd.Flags().BoolVar(&signGenKey, "genkey", false, "Generate a new signing key, and write it to the key file")
+	RootCmd.AddCommand(SignCmd)
+}
@@ -583,6 +583,11 @@ func (db *AdminDB) UpdateUserPluginSettings(username string, plugin string, pref
 	if err != nil {
 		return err
 	}
+	if plugin == "heedy" {
+		if tz, ok := preferences["timezone"].(string); ok && !assets.ValidTimezone(tz) {
+			return fmt.Errorf("bad_request: unrecognized timezone '%s'", tz)
+		}
+	}
 
 	// Now set the keys
 	tx, err := db.Beginx()
@@ -629,3 +634,12 @@ func (db *AdminDB) UpdateUserPluginSettings(username string, plugin string, pref
 
 	return tx.Commit()
 }
+
+// ReadUserLocale returns the timezone, locale and unit system from the user's core heedy settings
+func (db *AdminDB) ReadUserLocale(username string) (*assets.UserLocale, error) {
+	s, err := db.ReadUserPluginSettings(username, "heedy")
+	if err != nil {
+		return nil, err
+	}
+	return db.a.Config.GetUserLocale(s), nil
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/heedy/heedy/backend/assets"
 	"github.com/heedy/heedy/backend/database/dbutil"
@@ -738,3 +739,28 @@ func TestAdminListApps(t *testing.T) {
 	require.Equal(t, a[0].ID, appid)
 
 }
+
+func TestUserLocale(t *testing.T) {
+	db, cleanup := newDBWithUser(t)
+	defer cleanup()
+
+	l, err := db.ReadUserLocale("testy")
+	require.NoError(t, err)
+	require.Equal(t, "metric", l.UnitSystem)
+	require.Equal(t, time.Local, l.Location())
+
+	require.Error(t, db.UpdateUserPluginSettings("testy", "heedy", map[string]interface{}{"timezone": "Not/AZone"}))
+	require.NoError(t, db.UpdateUserPluginSettings("testy", "heedy", map[string]interface{}{
+		"timezone":    "America/New_York",
+		"locale":      "en-US",
+		"unit_system": "imperial",
+	}))
+
+	l, err = db.ReadUserLocale("testy")
+	require.NoError(t, err)
+	require.Equal(t, "America/New_York", l.Timezone)
+	require.Equal(t, "America/New_York", l.Location().String())
+	require.Equal(t, "en-US", l.Locale)
+	require.Equal(t, "imperial", l.UnitSystem)
+	require.Equal(t, "2021-01-01T00:00:00-05:00", l.FormatTime(1609477200))
+}
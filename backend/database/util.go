@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/heedy/heedy/backend/assets"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -78,3 +79,22 @@ func GetExecError(result sql.Result, err error) error {
 
 	return nil
 }
+
+// CallerLocale returns the locale of the user on whose behalf the given database acts.
+// The public and admin databases, as well as users whose settings can't be read, get the server's default locale.
+func CallerLocale(db DB) *assets.UserLocale {
+	adb := db.AdminDB()
+	username := db.ID()
+	switch db.Type() {
+	case UserType:
+	case AppType:
+		username = username[:strings.Index(username, "/")]
+	default:
+		return adb.Assets().Config.GetUserLocale(nil)
+	}
+	l, err := adb.ReadUserLocale(username)
+	if err != nil {
+		return adb.Assets().Config.GetUserLocale(nil)
+	}
+	return l
+}
@@ -6,9 +6,10 @@ import (
 	"github.com/sirupsen/logrus"
 
 	// Add the plugins, which will register their own routes
-	// _ "github.com/heedy/heedy/plugins/registry/backend/registry"
+	_ "github.com/heedy/heedy/plugins/registry/backend/registry"
 	// _ "github.com/heedy/heedy/plugins/dashboard/backend/dashboard"
 	_ "github.com/heedy/heedy/plugins/kv/backend/kv"
+	_ "github.com/heedy/heedy/plugins/node/backend/node"
 	_ "github.com/heedy/heedy/plugins/notifications/backend/notifications"
 	_ "github.com/heedy/heedy/plugins/python/backend/python"
 	_ "github.com/heedy/heedy/plugins/timeseries/backend/timeseries"
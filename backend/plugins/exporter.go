@@ -79,6 +79,20 @@ func ExportApp(c *rest.Context, app *database.App, opath string, zipWriter *zip.
 
 type ExportUserOptions struct {
 	IncludeApps bool
+
+	// Plugins can hold sensitive data, such as access tokens for other services, which is only exported
+	// if explicitly requested
+	IncludeSecrets bool `schema:"include_secrets"`
+}
+
+// UserExporter adds a builtin plugin's data to a user's export
+type UserExporter func(c *rest.Context, u *database.User, opath string, zipWriter *zip.Writer, opt *ExportUserOptions) error
+
+var userExporters []UserExporter
+
+// AddUserExporter registers a function that is called to add data to each user export
+func AddUserExporter(e UserExporter) {
+	userExporters = append(userExporters, e)
 }
 
 func ExportUser(c *rest.Context, u *database.User, opath string, zipWriter *zip.Writer, opt *ExportUserOptions) error {
@@ -142,5 +156,11 @@ func ExportUser(c *rest.Context, u *database.User, opath string, zipWriter *zip.
 		}
 	}
 
+	for _, e := range userExporters {
+		if err = e(c, u, opath, zipWriter, opt); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
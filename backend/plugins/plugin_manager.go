@@ -10,6 +10,7 @@ import (
 	"github.com/heedy/heedy/backend/database"
 	"github.com/heedy/heedy/backend/events"
 	"github.com/heedy/heedy/backend/plugins/run"
+	"github.com/heedy/heedy/backend/updater"
 	"github.com/sirupsen/logrus"
 )
 
@@ -47,6 +48,11 @@ type PluginManager struct {
 	start string
 	order []string
 
+	// The heedy server passed to Start, which is used when reloading plugins
+	server http.Handler
+	// Only one plugin can be reloaded at a time
+	reloading sync.Mutex
+
 	// the plugin manager status
 	status int
 }
@@ -145,6 +151,8 @@ func (pm *PluginManager) Fire(e *events.Event) {
 }
 
 func (pm *PluginManager) Start(heedyServer http.Handler) error {
+	pm.server = heedyServer
+
 	// First prepare all elements that don't require a plugin
 	err := pm.ObjectManager.PreparePlugin("")
 	if err != nil {
@@ -190,13 +198,7 @@ func (pm *PluginManager) Start(heedyServer http.Handler) error {
 		}
 		if p.Mux != nil {
 			// The plugin has a router component
-			if pm.start == "none" {
-				p.Mux.NotFound(pm.ObjectManager.ServeHTTP)
-				p.Mux.MethodNotAllowed(pm.ObjectManager.ServeHTTP)
-			} else {
-				p.Mux.NotFound(pm.Plugins[pm.start].Plugin.Mux.ServeHTTP)
-				p.Mux.MethodNotAllowed(pm.Plugins[pm.start].Plugin.Mux.ServeHTTP)
-			}
+			pm.link(p)
 			pm.start = pname
 		}
 		pm.initializingPlugin = nil
@@ -225,6 +227,141 @@ func (pm *PluginManager) Start(heedyServer http.Handler) error {
 	return nil
 }
 
+// link sets the plugin's router to pass requests it doesn't handle to the next plugin in the overlay.
+// The next plugin is looked up on each request, so that the overlay can be changed without modifying
+// routers that might be serving requests. It must be called before the plugin is added to the overlay.
+func (pm *PluginManager) link(p *Plugin) {
+	next := func(w http.ResponseWriter, r *http.Request) {
+		pm.nextHandler(p.Name).ServeHTTP(w, r)
+	}
+	p.Mux.NotFound(next)
+	p.Mux.MethodNotAllowed(next)
+}
+
+// nextHandler returns the handler of the plugin after the given one in the overlay
+func (pm *PluginManager) nextHandler(pname string) http.Handler {
+	pm.RLock()
+	defer pm.RUnlock()
+	if elem, ok := pm.Plugins[pname]; ok && elem.Next != "none" {
+		if next, ok := pm.Plugins[elem.Next]; ok {
+			return next.Plugin.Mux
+		}
+	}
+	return pm.ObjectManager
+}
+
+// relink rebuilds the overlay from the plugins in order of creation. It must be called with the lock held.
+func (pm *PluginManager) relink() {
+	start := "none"
+	for _, pname := range pm.order {
+		elem, ok := pm.Plugins[pname]
+		if !ok {
+			continue
+		}
+		elem.Next = start
+		if elem.Plugin.Mux != nil {
+			start = pname
+		}
+	}
+	pm.start = start
+}
+
+// startPlugin creates and starts the given plugin with its current configuration
+func (pm *PluginManager) startPlugin(pname string) (*Plugin, error) {
+	p, err := NewPlugin(pm.ADB, pm.RunManager, pm.server, pname)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Start(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if p.Mux != nil {
+		pm.link(p)
+	}
+	return p, nil
+}
+
+// ReloadPlugin stops the given plugin, re-reads its configuration (applying any pending update to its files),
+// and starts it again, while the rest of heedy keeps running. The plugin keeps its position in the overlay.
+// If the new configuration is invalid, the plugin keeps running unchanged, and if the new version fails to start,
+// the plugin is started again with its previous configuration.
+func (pm *PluginManager) ReloadPlugin(pname string) error {
+	pm.reloading.Lock()
+	defer pm.reloading.Unlock()
+
+	pm.RLock()
+	if pm.status != statusReady {
+		pm.RUnlock()
+		return errors.New("loading: heedy is currently loading plugins")
+	}
+	elem, ok := pm.Plugins[pname]
+	pm.RUnlock()
+	if !ok {
+		return fmt.Errorf("not_found: plugin '%s' is not running", pname)
+	}
+
+	a := pm.ADB.Assets()
+	if a.FolderPath != "" {
+		if _, err := updater.ApplyPluginUpdate(a.FolderPath, pname); err != nil {
+			return err
+		}
+	}
+	newConfig, err := a.LoadPluginConfig(pname)
+	if err != nil {
+		return err
+	}
+
+	// Remove the plugin from the overlay while it is reloading
+	pm.Lock()
+	delete(pm.Plugins, pname)
+	pm.relink()
+	pm.Unlock()
+
+	logrus.Infof("Reloading plugin '%s'", pname)
+	elem.Plugin.Close()
+
+	a.Config.RLock()
+	oldConfig := a.Config.Plugins[pname]
+	a.Config.RUnlock()
+	a.SetPluginConfig(pname, newConfig)
+
+	p, err := pm.startPlugin(pname)
+	if err != nil {
+		logrus.Errorf("Failed to start reloaded plugin '%s', restarting the previous version: %v", pname, err)
+		a.SetPluginConfig(pname, oldConfig)
+		p, err2 := pm.startPlugin(pname)
+		if err2 != nil {
+			logrus.Errorf("Failed to restart plugin '%s': %v", pname, err2)
+			return err
+		}
+		if err2 = pm.addReloaded(p); err2 != nil {
+			return err2
+		}
+		return fmt.Errorf("Failed to reload plugin '%s', so its previous version was restarted: %w", pname, err)
+	}
+	return pm.addReloaded(p)
+}
+
+// addReloaded puts a reloaded plugin back into its place in the overlay
+func (pm *PluginManager) addReloaded(p *Plugin) error {
+	pm.Lock()
+	if pm.status != statusReady {
+		pm.Unlock()
+		p.Close()
+		return errors.New("PluginManager was closed during reload")
+	}
+	pm.Plugins[p.Name] = &pluginElement{Plugin: p}
+	pm.relink()
+	pm.Unlock()
+
+	// Object routes that use the plugin's runners need to point to the new runners
+	if err := pm.ObjectManager.PreparePlugin(p.Name); err != nil {
+		return err
+	}
+	return p.AfterStart()
+}
+
 func (pm *PluginManager) Close() error {
 	pm.Lock()
 	if pm.status == statusClosing {
@@ -321,7 +458,9 @@ func (pm *PluginManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		if overlay[0] == "next" {
 			// The overlay is next, so find which plugin we're coming from
-			serveKey = pm.Plugins[ctx.Plugin].Next
+			if elem, ok := pm.Plugins[ctx.Plugin]; ok {
+				serveKey = elem.Next
+			}
 
 		}
 	}
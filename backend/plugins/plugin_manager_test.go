@@ -0,0 +1,121 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins/run"
+)
+
+func writeReloadPlugin(t *testing.T, dir, version, route string) {
+	pdir := path.Join(dir, "plugins", "reloader")
+	require.NoError(t, os.MkdirAll(pdir, 0775))
+	conf := fmt.Sprintf(`
+plugin "reloader" {
+	version = "%s"
+	run "server" {
+		type = "builtin"
+		key = "reloadtest"
+	}
+	routes = {
+		"%s": "run:server"
+	}
+}
+`, version, route)
+	require.NoError(t, ioutil.WriteFile(path.Join(pdir, "heedy.conf"), []byte(conf), 0664))
+}
+
+func TestReloadPlugin(t *testing.T) {
+	starts := 0
+	// The number of times that the runner fails to start before succeeding
+	failStarts := 0
+	run.Builtin.Add(&run.BuiltinRunner{
+		Key: "reloadtest",
+		Start: func(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+			starts++
+			if failStarts > 0 {
+				failStarts--
+				return errors.New("failed to start")
+			}
+			return nil
+		},
+		Handler: http.NotFoundHandler(),
+	})
+
+	dir, err := ioutil.TempDir("", "heedy-plugins-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Only the test plugin is active
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "heedy.conf"), []byte(`
+active_plugins = ["-notifications", "-timeseries", "-python", "-node", "-kv", "-registry", "reloader"]
+sql = "sqlite3://heedy.db?_journal=WAL&_fk=1"
+`), 0664))
+	writeReloadPlugin(t, dir, "1.0.0", "/api/reloader/a")
+
+	a, err := assets.Open(dir, nil)
+	require.NoError(t, err)
+	require.NoError(t, database.Create(a))
+	db, err := database.Open(a)
+	require.NoError(t, err)
+	defer db.Close()
+
+	pm, err := NewPluginManager(db, http.NotFoundHandler())
+	require.NoError(t, err)
+	require.NoError(t, pm.Start(http.NotFoundHandler()))
+	defer pm.Close()
+
+	match := func(route string) bool {
+		return pm.Plugins["reloader"].Plugin.Mux.Match(chi.NewRouteContext(), "GET", route)
+	}
+	require.Equal(t, 1, starts)
+	require.True(t, match("/api/reloader/a"))
+	require.False(t, match("/api/reloader/b"))
+
+	require.Error(t, pm.ReloadPlugin("notaplugin"))
+
+	// Stage an update to the plugin, which is applied on reload
+	os.MkdirAll(path.Join(dir, "updates"), 0775)
+	writeReloadPlugin(t, path.Join(dir, "updates"), "1.1.0", "/api/reloader/b")
+	require.NoError(t, pm.ReloadPlugin("reloader"))
+
+	require.Equal(t, 2, starts)
+	require.Equal(t, "1.1.0", *a.Config.Plugins["reloader"].Version)
+	require.False(t, match("/api/reloader/a"))
+	require.True(t, match("/api/reloader/b"))
+	require.Equal(t, "reloader", pm.start)
+
+	// Changes outside of the plugin's block can't be reloaded, so the plugin keeps running unchanged
+	writeReloadPlugin(t, dir, "1.2.0", "/api/reloader/c")
+	f, err := os.OpenFile(path.Join(dir, "plugins", "reloader", "heedy.conf"), os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	_, err = f.WriteString(`runtype "reloadtype" { api = "run:reloader.server" }`)
+	require.NoError(t, err)
+	f.Close()
+	err = pm.ReloadPlugin("reloader")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "restarted")
+	require.Equal(t, 2, starts)
+	require.Equal(t, "1.1.0", *a.Config.Plugins["reloader"].Version)
+	require.True(t, match("/api/reloader/b"))
+
+	// If the new version fails to start, the previous version is restarted
+	writeReloadPlugin(t, dir, "1.3.0", "/api/reloader/d")
+	failStarts = 1
+	require.Error(t, pm.ReloadPlugin("reloader"))
+	require.Equal(t, 4, starts)
+	require.Equal(t, "1.1.0", *a.Config.Plugins["reloader"].Version)
+	require.True(t, match("/api/reloader/b"))
+	require.False(t, match("/api/reloader/d"))
+	require.Equal(t, "reloader", pm.start)
+}
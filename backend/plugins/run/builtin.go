@@ -8,10 +8,15 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/heedy/heedy/backend/database"
+	"github.com/robfig/cron/v3"
 )
 
 type BuiltinHelper interface {
 	GetHandler(uri string) (http.Handler, error)
+
+	// AddCron and RemoveCron schedule functions using the run manager's cron
+	AddCron(spec string, job func()) (cron.EntryID, error)
+	RemoveCron(id cron.EntryID)
 }
 
 type builtinHelper struct {
@@ -23,6 +28,14 @@ func (bh *builtinHelper) GetHandler(uri string) (http.Handler, error) {
 	return bh.m.GetHandler(bh.plugin, uri)
 }
 
+func (bh *builtinHelper) AddCron(spec string, job func()) (cron.EntryID, error) {
+	return bh.m.AddCron(spec, job)
+}
+
+func (bh *builtinHelper) RemoveCron(id cron.EntryID) {
+	bh.m.RemoveCron(id)
+}
+
 type BuiltinStartFunc func(db *database.AdminDB, i *Info, h BuiltinHelper) error
 
 // Builtin is passed in to the BuiltinHandler with
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,6 +24,13 @@ type Cmd struct {
 	iswaiting bool
 	sync.Mutex
 	waiter chan error
+
+	// The API key of the runner whose process this is. If set, the run manager is notified
+	// when the process exits.
+	apikey string
+
+	limits   *Limits
+	limitErr error
 }
 
 func (c *Cmd) Wait() error {
@@ -36,13 +45,50 @@ func (c *Cmd) Wait() error {
 	}
 
 	err := c.Cmd.Wait()
+	FlushOutput(c.Cmd.Stdout, c.Cmd.Stderr)
 	c.Lock()
 	c.done = true
+	if c.limitErr != nil {
+		err = c.limitErr
+	} else {
+		err = c.limits.exitError(c.Cmd.ProcessState, err)
+	}
 	c.Unlock()
+	if c.limits != nil && err != nil && strings.HasPrefix(err.Error(), "killed:") {
+		logMessage(c.limits.i, err.Error())
+	}
 	c.waiter <- err
+	if c.apikey != "" {
+		notifyExit(c.apikey, err)
+	}
 	return err
 }
 
+// SetLimits enforces the limits returned by Sandbox on the started process
+func (c *Cmd) SetLimits(l *Limits) {
+	c.Lock()
+	c.limits = l
+	c.Unlock()
+	if l != nil && l.Memory > 0 {
+		go c.watchMemory()
+	}
+}
+
+// watchMemory kills the process group if it uses more memory than allowed
+func (c *Cmd) watchMemory() {
+	pid := c.Cmd.Process.Pid
+	for !c.Done() {
+		if m := processGroupMemory(pid); m > c.limits.Memory {
+			c.Lock()
+			c.limitErr = fmt.Errorf("killed: process used %dMB, exceeding its memory_limit of %dMB", m>>20, c.limits.Memory>>20)
+			c.Unlock()
+			syscall.Kill(-pid, syscall.SIGKILL)
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 func (c *Cmd) Done() bool {
 	c.Lock()
 	defer c.Unlock()
@@ -56,6 +102,14 @@ func NewCmd(c *exec.Cmd) *Cmd {
 	}
 }
 
+// NewRunnerCmd is like NewCmd, but the process is supervised by the run manager,
+// which restarts it according to the runner's restart policy if it exits.
+func NewRunnerCmd(c *exec.Cmd, apikey string) *Cmd {
+	cmd := NewCmd(c)
+	cmd.apikey = apikey
+	return cmd
+}
+
 type ExecHandler struct {
 	sync.Mutex
 	DB  *database.AdminDB
@@ -92,7 +146,7 @@ func (e *ExecHandler) Start(i *Info) (http.Handler, error) {
 		cmds[i] = s
 	}
 	var h http.Handler
-	var method, host string
+	api := ""
 
 	// Next check the API
 	apiv, ok := i.Run.Config["api"]
@@ -107,32 +161,55 @@ func (e *ExecHandler) Start(i *Info) (http.Handler, error) {
 			return nil, err
 		}
 		h = hp
-		method, host, err = GetEndpoint(e.DB.Assets().DataDir(), apis)
+		api = apis
+	}
+
+	if err := e.StartCommand(i, cmds, api); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// StartCommand starts the given command as the runner's process, passing it the runner's info on stdin.
+// If api is given, it waits until the process answers requests at the api endpoint. Runtypes that are
+// implemented by plugins, like python and node, use it to run the processes of their runners.
+func (e *ExecHandler) StartCommand(i *Info, cmds []string, api string) error {
+	var method, host string
+	if api != "" {
+		var err error
+		method, host, err = GetEndpoint(e.DB.Assets().DataDir(), api)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
+	if e.DB.Verbose {
+		logrus.Debugf("%s: %s", i.Plugin, strings.Join(cmds, " "))
+	}
 
 	// Now set up the process
 	cmd := exec.Command(cmds[0], cmds[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = Output(i, "stdout")
+	cmd.Stderr = Output(i, "stderr")
 	cmd.Dir = i.PluginDir
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
+	limits, err := Sandbox(cmd, i)
+	if err != nil {
+		return err
+	}
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Prepare the input
 	infobytes, err := json.Marshal(i)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if err = cmd.Start(); err != nil {
-		return nil, err
+		return err
 	}
 	_, err = stdin.Write(infobytes)
 	if err == nil {
@@ -141,29 +218,29 @@ func (e *ExecHandler) Start(i *Info) (http.Handler, error) {
 	if err != nil {
 		// Kill the process if can't write to stdin
 		cmd.Process.Kill()
-		return nil, err
+		return err
 	}
 
-	c := NewCmd(cmd)
+	c := NewRunnerCmd(cmd, i.APIKey)
+	c.SetLimits(limits)
 	go c.Wait()
 	e.Lock()
 	e.Cmd[i.APIKey] = c
 	e.Unlock()
 
-	if h != nil {
-		// There is a handler - wait until the given port is opened
-
+	if api != "" {
+		// There is an API - wait until the given port is opened
 		err = WaitForEndpoint(method, host, c)
 		if err != nil {
 			e.Lock()
 			delete(e.Cmd, i.APIKey)
 			e.Unlock()
 			cmd.Process.Kill()
-			return nil, err
+			return err
 		}
 	}
 
-	return h, nil
+	return nil
 }
 
 func (e *ExecHandler) Run(i *Info) error {
@@ -211,3 +288,36 @@ func (e *ExecHandler) Kill(apikey string) error {
 	}
 	return cmd.Cmd.Process.Kill()
 }
+
+// RunCommand runs a one-off command, such as installing a plugin's dependencies, and waits for it to finish.
+// When logging at info level or above, the command's output is appended to the given log file in heedy's
+// log directory, or written to stdout if heedy logs there.
+func RunCommand(db *database.AdminDB, logname string, dir string, exepath string, args []string) error {
+	if db.Verbose {
+		logrus.Debugf("%s %s", exepath, strings.Join(args, " "))
+	}
+	cmd := exec.Command(exepath, args...)
+	cmd.Dir = dir
+	lvl := logrus.GetLevel()
+	if lvl == logrus.DebugLevel || lvl == logrus.InfoLevel {
+		logdir := db.Assets().LogDir()
+		if logdir == "stdout" {
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		} else {
+			logfile := path.Join(logdir, logname)
+			f, err := os.OpenFile(logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+			if err != nil {
+				return err
+			}
+			_, err = f.WriteString(fmt.Sprintf("\n\n%s >>> %s %s\n", time.Now().Format(time.RFC3339), exepath, strings.Join(args, " ")))
+			if err != nil {
+				return err
+			}
+			cmd.Stdout = f
+			cmd.Stderr = f
+			defer f.Close()
+		}
+	}
+	return cmd.Run()
+}
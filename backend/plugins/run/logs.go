@@ -0,0 +1,360 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+const (
+	defaultPluginLogSize  = 1024 * 1024
+	defaultPluginLogFiles = 3
+)
+
+// LogEntry is a single line of output from a plugin's runner
+type LogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Runner    string    `json:"runner"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+// String gives the entry as it is written to the log file
+func (e *LogEntry) String() string {
+	return fmt.Sprintf("%s %s %s %s\n", e.Timestamp.UTC().Format(time.RFC3339Nano), e.Runner, e.Stream, e.Message)
+}
+
+func parseLogEntry(line string) (*LogEntry, bool) {
+	s := strings.SplitN(line, " ", 4)
+	if len(s) < 3 {
+		return nil, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s[0])
+	if err != nil {
+		return nil, false
+	}
+	e := &LogEntry{Timestamp: ts, Runner: s[1], Stream: s[2]}
+	if len(s) == 4 {
+		e.Message = s[3]
+	}
+	return e, true
+}
+
+// PluginLog is a rotating log file holding the output of all of a plugin's runners
+type PluginLog struct {
+	sync.Mutex
+
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	f           *os.File
+	size        int64
+	subscribers map[chan *LogEntry]struct{}
+}
+
+func (l *PluginLog) open() error {
+	if l.f != nil {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(l.Path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate moves the current log file to path.1, shifting the older files, and removing the oldest
+func (l *PluginLog) rotate() error {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.Path, l.MaxFiles))
+	for i := l.MaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.Path, i), fmt.Sprintf("%s.%d", l.Path, i+1))
+	}
+	if l.MaxFiles > 0 {
+		return os.Rename(l.Path, l.Path+".1")
+	}
+	return os.Remove(l.Path)
+}
+
+// Write adds the entry to the log, and sends it to all followers
+func (l *PluginLog) Write(e *LogEntry) error {
+	line := e.String()
+	l.Lock()
+	defer l.Unlock()
+	for c := range l.subscribers {
+		select {
+		case c <- e:
+		default:
+			// Slow followers miss entries rather than blocking the plugin
+		}
+	}
+	if l.f != nil && l.MaxSize > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	n, err := l.f.WriteString(line)
+	l.size += int64(n)
+	return err
+}
+
+// Read returns the entries in the log since the given time, oldest first
+func (l *PluginLog) Read(since time.Time) ([]*LogEntry, error) {
+	l.Lock()
+	defer l.Unlock()
+	entries := []*LogEntry{}
+	files := []string{}
+	for i := l.MaxFiles; i > 0; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", l.Path, i))
+	}
+	files = append(files, l.Path)
+	for _, fname := range files {
+		f, err := os.Open(fname)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			e, ok := parseLogEntry(s.Text())
+			if ok && !e.Timestamp.Before(since) {
+				entries = append(entries, e)
+			}
+		}
+		f.Close()
+		if err = s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Subscribe returns a channel that receives all new entries written to the log.
+// The channel must be unsubscribed once done.
+func (l *PluginLog) Subscribe() chan *LogEntry {
+	c := make(chan *LogEntry, 100)
+	l.Lock()
+	if l.subscribers == nil {
+		l.subscribers = make(map[chan *LogEntry]struct{})
+	}
+	l.subscribers[c] = struct{}{}
+	l.Unlock()
+	return c
+}
+
+func (l *PluginLog) Unsubscribe(c chan *LogEntry) {
+	l.Lock()
+	delete(l.subscribers, c)
+	l.Unlock()
+}
+
+func (l *PluginLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// LogManager holds the log files of all plugins, which are stored in data/logs
+type LogManager struct {
+	sync.Mutex
+
+	Dir      string
+	MaxSize  int64
+	MaxFiles int
+
+	logs map[string]*PluginLog
+}
+
+func NewLogManager(a *assets.Assets) *LogManager {
+	lm := &LogManager{
+		Dir:      path.Join(a.DataDir(), "logs"),
+		MaxSize:  defaultPluginLogSize,
+		MaxFiles: defaultPluginLogFiles,
+		logs:     make(map[string]*PluginLog),
+	}
+	if a.Config.PluginLogSize != nil {
+		lm.MaxSize = *a.Config.PluginLogSize
+	}
+	if a.Config.PluginLogFiles != nil {
+		lm.MaxFiles = *a.Config.PluginLogFiles
+	}
+	return lm
+}
+
+func (lm *LogManager) newLog(plugin string) *PluginLog {
+	return &PluginLog{
+		Path:     path.Join(lm.Dir, plugin+".log"),
+		MaxSize:  lm.MaxSize,
+		MaxFiles: lm.MaxFiles,
+	}
+}
+
+// Get returns the log of the given plugin
+func (lm *LogManager) Get(plugin string) *PluginLog {
+	lm.Lock()
+	defer lm.Unlock()
+	l, ok := lm.logs[plugin]
+	if !ok {
+		l = lm.newLog(plugin)
+		lm.logs[plugin] = l
+	}
+	return l
+}
+
+// Lookup returns the log of the given plugin if the plugin has written output, either since heedy started,
+// or in an earlier run whose log files are still there. Unlike Get, it doesn't add logs for unknown plugins.
+func (lm *LogManager) Lookup(plugin string) (*PluginLog, bool) {
+	lm.Lock()
+	defer lm.Unlock()
+	if l, ok := lm.logs[plugin]; ok {
+		return l, true
+	}
+	l := lm.newLog(plugin)
+	files := []string{l.Path}
+	for i := 1; i <= l.MaxFiles; i++ {
+		files = append(files, fmt.Sprintf("%s.%d", l.Path, i))
+	}
+	for _, fname := range files {
+		if _, err := os.Stat(fname); err == nil {
+			lm.logs[plugin] = l
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+func (lm *LogManager) Close() error {
+	lm.Lock()
+	defer lm.Unlock()
+	for _, l := range lm.logs {
+		l.Close()
+	}
+	return nil
+}
+
+// logWriter splits the output of a runner into lines, and writes them to the plugin's log
+type logWriter struct {
+	sync.Mutex
+	log    *PluginLog
+	runner string
+	stream string
+	echo   io.Writer
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	if w.echo != nil {
+		w.echo.Write(p)
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		w.log.Write(&LogEntry{Timestamp: time.Now(), Runner: w.runner, Stream: w.stream, Message: line})
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete last line to the log
+func (w *logWriter) Flush() {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.buf) == 0 {
+		return
+	}
+	line := strings.TrimRight(string(w.buf), "\r")
+	w.buf = nil
+	w.log.Write(&LogEntry{Timestamp: time.Now(), Runner: w.runner, Stream: w.stream, Message: line})
+}
+
+// FlushOutput writes the incomplete last lines of writers returned by Output to their logs. It is called once
+// a runner's process exits, since a process that crashes often doesn't end its last message with a newline.
+func FlushOutput(writers ...io.Writer) {
+	for _, w := range writers {
+		if lw, ok := w.(*logWriter); ok {
+			lw.Flush()
+		}
+	}
+}
+
+var (
+	logLock    sync.RWMutex
+	logManager *LogManager
+)
+
+func setLogManager(lm *LogManager) {
+	logLock.Lock()
+	logManager = lm
+	logLock.Unlock()
+}
+
+// Output returns the writer to use as the given output stream ("stdout" or "stderr") of the runner's process.
+// The output is shown in heedy's own output, and is also written to the plugin's log file.
+func Output(i *Info, stream string) io.Writer {
+	echo := io.Writer(os.Stdout)
+	if stream == "stderr" {
+		echo = os.Stderr
+	}
+	logLock.RLock()
+	lm := logManager
+	logLock.RUnlock()
+	if lm == nil {
+		return echo
+	}
+	return &logWriter{
+		log:    lm.Get(i.Plugin),
+		runner: i.Plugin + ":" + i.Name,
+		stream: stream,
+		echo:   echo,
+	}
+}
+
+// logMessage writes a message from heedy about the runner to its plugin's log
+func logMessage(i *Info, msg string) {
+	logLock.RLock()
+	lm := logManager
+	logLock.RUnlock()
+	if lm == nil || i == nil {
+		return
+	}
+	lm.Get(i.Plugin).Write(&LogEntry{Timestamp: time.Now(), Runner: i.Plugin + ":" + i.Name, Stream: "heedy", Message: msg})
+}
@@ -0,0 +1,97 @@
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-logs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lm := &LogManager{
+		Dir:      dir,
+		MaxSize:  300,
+		MaxFiles: 2,
+		logs:     make(map[string]*PluginLog),
+	}
+	defer lm.Close()
+	l := lm.Get("myplugin")
+
+	c := l.Subscribe()
+	w := &logWriter{log: l, runner: "myplugin:server", stream: "stderr"}
+
+	// Output is split into lines, and partial lines are only written once complete
+	_, err = w.Write([]byte("hello\nwor"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("ld\n"))
+	require.NoError(t, err)
+
+	entries, err := l.Read(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "hello", entries[0].Message)
+	require.Equal(t, "world", entries[1].Message)
+	require.Equal(t, "myplugin:server", entries[1].Runner)
+	require.Equal(t, "stderr", entries[1].Stream)
+
+	e := <-c
+	require.Equal(t, "hello", e.Message)
+	l.Unsubscribe(c)
+
+	// A last line without a newline is written when the process exits
+	_, err = w.Write([]byte("panic: crashed"))
+	require.NoError(t, err)
+	FlushOutput(w)
+	entries, err = l.Read(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "panic: crashed", entries[2].Message)
+	FlushOutput(w)
+	entries, err = l.Read(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	// Entries can be filtered by time
+	entries, err = l.Read(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	// The log is rotated once it is too large, removing the oldest entries
+	for i := 0; i < 20; i++ {
+		_, err = w.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		require.NoError(t, err)
+	}
+	_, err = os.Stat(path.Join(dir, "myplugin.log.2"))
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(dir, "myplugin.log.3"))
+	require.True(t, os.IsNotExist(err))
+
+	entries, err = l.Read(time.Time{})
+	require.NoError(t, err)
+	require.True(t, len(entries) < 22)
+	require.Equal(t, "line 19", entries[len(entries)-1].Message)
+
+	// Only plugins with logs can be looked up, and looking up others doesn't add them
+	_, ok := lm.Lookup("notaplugin")
+	require.False(t, ok)
+	require.NotContains(t, lm.logs, "notaplugin")
+	l2, ok := lm.Lookup("myplugin")
+	require.True(t, ok)
+	require.Equal(t, l, l2)
+
+	// Logs from an earlier run are found on disk
+	lm2 := &LogManager{Dir: dir, MaxFiles: 2, logs: make(map[string]*PluginLog)}
+	l2, ok = lm2.Lookup("myplugin")
+	require.True(t, ok)
+	entries, err = l2.Read(time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, "line 19", entries[len(entries)-1].Message)
+}
@@ -43,6 +43,12 @@ type Runner struct {
 
 	m   *Manager
 	cid cron.EntryID
+
+	// The runner's health is protected by the manager's lock
+	health              RunnerHealth
+	consecutiveRestarts int
+	exitedWhileStarting bool
+	exitErr             error
 }
 
 func (r *Runner) Run() {
@@ -67,7 +73,13 @@ type Manager struct {
 	// The APIKey that represents the "core" heedy server
 	CoreKey string
 
+	// Logs holds the captured output of the plugins' runners
+	Logs *LogManager
+
 	cron *cron.Cron
+
+	// killed is set when heedy is shutting down, so that exiting runners are not restarted
+	killed bool
 }
 
 func NewManager(db *database.AdminDB) *Manager {
@@ -109,6 +121,7 @@ func NewManager(db *database.AdminDB) *Manager {
 		cron:     c,
 		CoreKey:  apikey,
 		DB:       db,
+		Logs:     NewLogManager(a),
 	}
 
 	for rt, v := range db.Assets().Config.RunTypes {
@@ -120,6 +133,8 @@ func NewManager(db *database.AdminDB) *Manager {
 				handler = NewBuiltinHandler(db, m)
 			case "exec":
 				handler = NewExecHandler(db)
+			case "wasm":
+				handler = NewWasmHandler(db)
 			default:
 				// This should be handled by config validation
 				panic(fmt.Sprintf("runtype %s has no API", rt))
@@ -131,9 +146,29 @@ func NewManager(db *database.AdminDB) *Manager {
 		runtypes[rt] = handler
 	}
 
+	setExitHandler(m.processExited)
+	setLogManager(m.Logs)
+
 	return m
 }
 
+// wrapHandler logs requests forwarded to the runner when heedy is verbose
+func (m *Manager) wrapHandler(i *Info, h http.Handler) http.Handler {
+	if h == nil || !m.DB.Assets().Config.Verbose {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := rest.CTX(req)
+		if ctx != nil {
+			ctx.Log.Debugf("Forwarding request to %s:%s%s", i.Plugin, i.Name, req.URL.Path)
+		} else {
+			logrus.Debugf("Forwarding request to %s:%s%s", i.Plugin, i.Name, req.URL.Path)
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
+
 func (m *Manager) Start(plugin, name string, run *assets.Run) error {
 	if run.Enabled != nil && !*run.Enabled {
 		return nil
@@ -171,6 +206,12 @@ func (m *Manager) Start(plugin, name string, run *assets.Run) error {
 	r := &Runner{
 		I: i,
 		m: m,
+		health: RunnerHealth{
+			Plugin: plugin,
+			Name:   name,
+			Type:   *run.Type,
+			Status: "starting",
+		},
 	}
 
 	m.Lock()
@@ -180,24 +221,24 @@ func (m *Manager) Start(plugin, name string, run *assets.Run) error {
 		m.Unlock()
 		logrus.Debugf("Starting %s:%s", i.Plugin, i.Name)
 		h, err := rt.Start(i)
+
+		m.Lock()
+		if err == nil {
+			r.Handler = m.wrapHandler(i, h)
+		}
+		exited, exitErr := r.started(err)
+		if exited {
+			// The process exited before the runner finished starting
+			m.supervise(r, exitErr)
+		} else {
+			m.Unlock()
+		}
 		if err != nil {
 			return err
 		}
-		r.Handler = h
-		if a.Config.Verbose {
-			r.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-				ctx := rest.CTX(req)
-				if ctx != nil {
-					ctx.Log.Debugf("Forwarding request to %s:%s%s", i.Plugin, i.Name, req.URL.Path)
-				} else {
-					logrus.Debugf("Forwarding request to %s:%s%s", i.Plugin, i.Name, req.URL.Path)
-				}
-
-				h.ServeHTTP(w, req)
-			})
-		}
 	} else {
 		logrus.Debugf("Adding cron job %s:%s (%s)", i.Plugin, i.Name, *run.Cron)
+		r.health.Status = "scheduled"
 		r.cid, err = m.cron.AddJob(*run.Cron, r)
 		m.Unlock()
 		if err != nil {
@@ -208,6 +249,17 @@ func (m *Manager) Start(plugin, name string, run *assets.Run) error {
 	return nil
 }
 
+// AddCron runs the given function on a cron schedule, which can also be a descriptor such as "@every 5m".
+// It is used by builtin plugins that need to do periodic work without a separate cron runner.
+func (m *Manager) AddCron(spec string, job func()) (cron.EntryID, error) {
+	return m.cron.AddFunc(spec, job)
+}
+
+// RemoveCron stops a function added with AddCron from running
+func (m *Manager) RemoveCron(id cron.EntryID) {
+	m.cron.Remove(id)
+}
+
 func (m *Manager) Find(plugin, name string) (*Runner, error) {
 	m.RLock()
 	defer m.RUnlock()
@@ -243,6 +295,7 @@ func (m *Manager) Stop(plugin, name string) error {
 func (m *Manager) Kill() error {
 	m.Lock()
 	defer m.Unlock()
+	m.killed = true
 	for apikey, r := range m.Runners {
 		if r.I.Run != nil {
 			err := m.RunTypes[*r.I.Run.Type].Kill(apikey)
@@ -0,0 +1,202 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+// The environment variable through which the sandbox helper receives the limits it should apply
+const sandboxEnv = "HEEDY_SANDBOX"
+
+// The environment variables that are kept when a runner's environment is cleaned
+var baseEnv = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+// Limits are the restrictions placed on a runner's process.
+// The cpu limit and writable directories are applied by the sandbox helper (heedy sandbox) before
+// it executes the runner's command, while the memory limit is enforced by heedy, which watches the
+// memory use of the process group.
+type Limits struct {
+	Memory int64 `json:"memory,omitempty"` // bytes of resident memory
+	CPU    int64 `json:"cpu,omitempty"`    // seconds of cpu time
+
+	// If RestrictWrites is true, the process can only write within the Writable directories
+	RestrictWrites bool     `json:"restrict_writes,omitempty"`
+	Writable       []string `json:"writable,omitempty"`
+
+	i *Info
+}
+
+// Sandbox prepares the not-yet-started command to run with the limits given in the runner's configuration.
+// The returned limits (nil if there are none) must be given to the Cmd with SetLimits once it is started.
+func Sandbox(cmd *exec.Cmd, i *Info) (*Limits, error) {
+	r := i.Run
+	if r == nil {
+		return nil, nil
+	}
+	if r.CleanEnv != nil && *r.CleanEnv {
+		allowed := baseEnv
+		if r.EnvAllowlist != nil {
+			allowed = append(append([]string{}, baseEnv...), *r.EnvAllowlist...)
+		}
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = cleanEnv(env, allowed)
+	}
+	if r.User != nil {
+		cred, err := lookupCredential(*r.User)
+		if err != nil {
+			return nil, err
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+
+	l := &Limits{i: i}
+	if r.MemoryLimit != nil {
+		m, err := assets.ParseByteSize(*r.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		if !memoryWatchSupported() {
+			return nil, errors.New("memory_limit is not supported on this system")
+		}
+		l.Memory = m
+	}
+	if r.CPULimit != nil {
+		d, err := time.ParseDuration(*r.CPULimit)
+		if err != nil {
+			return nil, err
+		}
+		l.CPU = int64(d.Seconds())
+	}
+	if r.WritableDirs != nil {
+		l.RestrictWrites = true
+		for _, d := range *r.WritableDirs {
+			if !filepath.IsAbs(d) {
+				d = filepath.Join(cmd.Dir, d)
+			}
+			l.Writable = append(l.Writable, filepath.Clean(d))
+		}
+	}
+	if l.Memory == 0 && l.CPU == 0 && !l.RestrictWrites {
+		return nil, nil
+	}
+
+	if l.CPU > 0 || l.RestrictWrites {
+		// Run the command through the sandbox helper
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, sandboxEnv+"="+string(b))
+		cmd.Args = append([]string{exe, "sandbox", cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = exe
+	}
+	return l, nil
+}
+
+// SandboxExec is run by the sandbox helper. It applies the limits passed by heedy to the current process,
+// and replaces it with the given command.
+func SandboxExec(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+	var l Limits
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxEnv)), &l); err != nil {
+		return fmt.Errorf("invalid sandbox limits: %w", err)
+	}
+	os.Unsetenv(sandboxEnv)
+
+	if l.CPU > 0 {
+		// The process gets SIGXCPU once it reaches the limit, and is killed a bit later if it ignores it
+		err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: uint64(l.CPU), Max: uint64(l.CPU) + 5})
+		if err != nil {
+			return fmt.Errorf("could not set cpu_limit: %w", err)
+		}
+	}
+	if l.RestrictWrites {
+		if err := restrictWrites(l.Writable); err != nil {
+			return fmt.Errorf("could not restrict writable_dirs: %w", err)
+		}
+	}
+
+	p := args[0]
+	if !strings.Contains(p, "/") {
+		lp, err := exec.LookPath(p)
+		if err != nil {
+			return err
+		}
+		p = lp
+	}
+	return syscall.Exec(p, args, os.Environ())
+}
+
+// exitError explains the exit of a process that had the given limits
+func (l *Limits) exitError(ps *os.ProcessState, err error) error {
+	if l == nil || ps == nil || err == nil || l.CPU == 0 {
+		return err
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		sig := ws.Signal()
+		used := ps.UserTime() + ps.SystemTime()
+		if sig == syscall.SIGXCPU || sig == syscall.SIGKILL && used >= time.Duration(l.CPU)*time.Second {
+			return fmt.Errorf("killed: process exceeded its cpu_limit of %s", time.Duration(l.CPU)*time.Second)
+		}
+	}
+	return err
+}
+
+func cleanEnv(env []string, allowed []string) []string {
+	newenv := []string{}
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		for _, a := range allowed {
+			if name == a {
+				newenv = append(newenv, e)
+				break
+			}
+		}
+	}
+	return newenv
+}
+
+// lookupCredential returns the credential to run a process as the given user name or uid
+func lookupCredential(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if u, err = user.LookupId(username); err != nil {
+			return nil, fmt.Errorf("could not find user '%s'", username)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
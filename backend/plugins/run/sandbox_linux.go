@@ -0,0 +1,120 @@
+package run
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock (Linux 5.13+) is used to restrict the directories a process can write to
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockRulePathBeneath = 1
+	prSetNoNewPrivs         = 38
+	oPath                   = 0x200000 // O_PATH, which the syscall package does not define
+
+	landlockAccessWriteFile  = 1 << 1
+	landlockAccessRemoveDir  = 1 << 4
+	landlockAccessRemoveFile = 1 << 5
+	landlockAccessMakeChar   = 1 << 6
+	landlockAccessMakeDir    = 1 << 7
+	landlockAccessMakeReg    = 1 << 8
+	landlockAccessMakeSock   = 1 << 9
+	landlockAccessMakeFifo   = 1 << 10
+	landlockAccessMakeBlock  = 1 << 11
+	landlockAccessMakeSym    = 1 << 12
+
+	landlockAccessWrite = landlockAccessWriteFile | landlockAccessRemoveDir | landlockAccessRemoveFile |
+		landlockAccessMakeChar | landlockAccessMakeDir | landlockAccessMakeReg | landlockAccessMakeSock |
+		landlockAccessMakeFifo | landlockAccessMakeBlock | landlockAccessMakeSym
+)
+
+type landlockRulesetAttr struct {
+	HandledAccessFS uint64
+}
+
+type landlockPathBeneathAttr struct {
+	AllowedAccess uint64
+	ParentFd      int32
+}
+
+// restrictWrites only allows the current process (and its children) to write within the given directories
+func restrictWrites(dirs []string) error {
+	attr := landlockRulesetAttr{HandledAccessFS: landlockAccessWrite}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		if errno == syscall.ENOSYS || errno == syscall.EOPNOTSUPP {
+			return errors.New("the kernel does not support Landlock (Linux 5.13+ is required)")
+		}
+		return errno
+	}
+	defer syscall.Close(int(fd))
+
+	for _, d := range dirs {
+		dfd, err := syscall.Open(d, oPath|syscall.O_CLOEXEC|syscall.O_DIRECTORY, 0)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: d, Err: err}
+		}
+		// The kernel expects the packed 12 byte struct, which matches the start of the Go struct
+		pb := landlockPathBeneathAttr{AllowedAccess: landlockAccessWrite, ParentFd: int32(dfd)}
+		_, _, errno = syscall.Syscall6(sysLandlockAddRule, fd, landlockRulePathBeneath, uintptr(unsafe.Pointer(&pb)), 0, 0, 0)
+		syscall.Close(dfd)
+		if errno != 0 {
+			return &os.PathError{Op: "landlock", Path: d, Err: errno}
+		}
+	}
+
+	if _, _, errno = syscall.Syscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	if _, _, errno = syscall.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func memoryWatchSupported() bool {
+	_, err := os.Stat("/proc/self/stat")
+	return err == nil
+}
+
+// processGroupMemory returns the total resident memory in bytes of the processes in the given process group
+func processGroupMemory(pgid int) int64 {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	pagesize := int64(os.Getpagesize())
+	var total int64
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue // The process exited
+		}
+		// The command name can contain spaces, so the fields are read after its closing parenthesis
+		s := string(b)
+		fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+		if len(fields) < 22 {
+			continue
+		}
+		pg, err := strconv.Atoi(fields[2])
+		if err != nil || pg != pgid {
+			continue
+		}
+		rss, err := strconv.ParseInt(fields[21], 10, 64)
+		if err == nil {
+			total += rss * pagesize
+		}
+	}
+	return total
+}
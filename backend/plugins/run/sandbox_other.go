@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package run
+
+import "errors"
+
+func restrictWrites(dirs []string) error {
+	return errors.New("writable_dirs is only supported on Linux")
+}
+
+func memoryWatchSupported() bool {
+	return false
+}
+
+func processGroupMemory(pgid int) int64 {
+	return 0
+}
@@ -0,0 +1,81 @@
+package run
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandbox(t *testing.T) {
+	// No limits leave the command unchanged
+	cmd := exec.Command("sh", "-c", "true")
+	l, err := Sandbox(cmd, &Info{Run: &assets.Run{}})
+	require.NoError(t, err)
+	require.Nil(t, l)
+	require.Equal(t, []string{"sh", "-c", "true"}, cmd.Args)
+	require.Nil(t, cmd.Env)
+
+	os.Setenv("HEEDY_TEST_SECRET", "secret")
+	os.Setenv("HEEDY_TEST_ALLOWED", "allowed")
+	defer os.Unsetenv("HEEDY_TEST_SECRET")
+	defer os.Unsetenv("HEEDY_TEST_ALLOWED")
+
+	clean := true
+	cpu := "10s"
+	allow := []string{"HEEDY_TEST_ALLOWED"}
+	writable := []string{"data", "/tmp"}
+	cmd = exec.Command("sh", "-c", "true")
+	cmd.Dir = "/plugins/myplugin"
+	l, err = Sandbox(cmd, &Info{Run: &assets.Run{
+		CleanEnv:     &clean,
+		EnvAllowlist: &allow,
+		CPULimit:     &cpu,
+		WritableDirs: &writable,
+	}})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), l.CPU)
+	require.True(t, l.RestrictWrites)
+	require.Equal(t, []string{"/plugins/myplugin/data", "/tmp"}, l.Writable)
+
+	// The command is run through the sandbox helper
+	require.Equal(t, []string{"sandbox", cmd.Args[2], "-c", "true"}, cmd.Args[1:])
+	env := strings.Join(cmd.Env, "\n")
+	require.NotContains(t, env, "HEEDY_TEST_SECRET")
+	require.Contains(t, env, "HEEDY_TEST_ALLOWED=allowed")
+
+	var sl Limits
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, sandboxEnv+"=") {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(e, sandboxEnv+"=")), &sl))
+		}
+	}
+	require.Equal(t, l.Writable, sl.Writable)
+	require.Equal(t, int64(10), sl.CPU)
+}
+
+func TestSandboxMemory(t *testing.T) {
+	if !memoryWatchSupported() {
+		t.Skip("memory limits are not supported")
+	}
+	mem := "1KB"
+	cmd := exec.Command("sleep", "10")
+	l, err := Sandbox(cmd, &Info{Run: &assets.Run{MemoryLimit: &mem}})
+	require.NoError(t, err)
+	require.Equal(t, int64(1024), l.Memory)
+	require.Equal(t, "sleep", cmd.Args[0])
+
+	// The process is killed once it uses more memory than allowed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, cmd.Start())
+	c := NewCmd(cmd)
+	c.SetLimits(l)
+	err = c.Wait()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "memory_limit")
+}
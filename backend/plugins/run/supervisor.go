@@ -0,0 +1,237 @@
+package run
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/heedy/heedy/backend/events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// The default number of consecutive restarts before a runner is considered failed
+	defaultMaxRestarts = 5
+	// The default delay before the first restart, which doubles for each consecutive restart
+	defaultRestartDelay = time.Second
+	// The maximum delay between restarts
+	maxRestartDelay = 5 * time.Minute
+	// If a runner stays up this long, its consecutive restart count is reset
+	restartResetTime = 5 * time.Minute
+)
+
+// RunnerHealth gives the status of a runner
+type RunnerHealth struct {
+	Plugin string `json:"plugin"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+
+	// Status is one of starting, running, restarting, exited, failed or scheduled (for cron jobs)
+	Status    string     `json:"status"`
+	Restarts  int        `json:"restarts"`
+	Started   *time.Time `json:"started,omitempty"`
+	LastExit  *time.Time `json:"last_exit,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var (
+	exitLock    sync.RWMutex
+	exitHandler func(apikey string, err error)
+)
+
+// setExitHandler sets the function that is notified when a runner's process exits
+func setExitHandler(f func(apikey string, err error)) {
+	exitLock.Lock()
+	exitHandler = f
+	exitLock.Unlock()
+}
+
+func notifyExit(apikey string, err error) {
+	exitLock.RLock()
+	f := exitHandler
+	exitLock.RUnlock()
+	if f != nil {
+		f(apikey, err)
+	}
+}
+
+func (r *Runner) restartPolicy() (policy string, maxRestarts int, delay time.Duration) {
+	policy, maxRestarts, delay = "no", defaultMaxRestarts, defaultRestartDelay
+	if r.I.Run.Restart != nil {
+		policy = *r.I.Run.Restart
+	}
+	if r.I.Run.MaxRestarts != nil {
+		maxRestarts = *r.I.Run.MaxRestarts
+	}
+	if r.I.Run.RestartDelay != nil {
+		if d, err := time.ParseDuration(*r.I.Run.RestartDelay); err == nil {
+			delay = d
+		}
+	}
+	return
+}
+
+// started updates the runner's health once its Start finishes. It must be called with the manager locked,
+// and returns the runner's exit if its process exited while it was starting.
+func (r *Runner) started(err error) (exited bool, exitErr error) {
+	if err != nil {
+		r.health.Status = "failed"
+		r.health.LastError = err.Error()
+		r.exitedWhileStarting = false
+		return false, nil
+	}
+	now := time.Now()
+	r.health.Status = "running"
+	r.health.Started = &now
+	exited, exitErr = r.exitedWhileStarting, r.exitErr
+	r.exitedWhileStarting = false
+	return exited, exitErr
+}
+
+// processExited is notified by runtypes when the process of a runner exits, and restarts it
+// if required by the runner's restart policy
+func (m *Manager) processExited(apikey string, err error) {
+	m.Lock()
+	r, ok := m.Runners[apikey]
+	if !ok || m.killed || r.I.Run == nil || r.I.Run.Cron != nil {
+		// The runner was stopped, or is a cron job, which is not supervised
+		m.Unlock()
+		return
+	}
+	if r.health.Status == "starting" || r.health.Status == "restarting" {
+		// The exit will be handled once the runner's start returns
+		r.exitedWhileStarting = true
+		r.exitErr = err
+		m.Unlock()
+		return
+	}
+	if r.health.Status != "running" {
+		m.Unlock()
+		return
+	}
+	if r.health.Started != nil && time.Since(*r.health.Started) > restartResetTime {
+		// The runner was stable for a while, so the exit is not part of a crash loop
+		r.consecutiveRestarts = 0
+	}
+	m.supervise(r, err)
+}
+
+// supervise handles the exit of a running runner's process. It must be called with the manager locked,
+// and unlocks it.
+func (m *Manager) supervise(r *Runner, err error) {
+	now := time.Now()
+	r.health.LastExit = &now
+	r.health.LastError = ""
+	if err != nil {
+		r.health.LastError = err.Error()
+	}
+	policy, maxRestarts, delay := r.restartPolicy()
+	wantRestart := policy == "always" || policy == "on-failure" && err != nil
+	restart := wantRestart && r.consecutiveRestarts < maxRestarts
+	if restart {
+		for i := 0; i < r.consecutiveRestarts && delay < maxRestartDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+		r.consecutiveRestarts++
+		r.health.Status = "restarting"
+	} else if err != nil || wantRestart {
+		r.health.Status = "failed"
+	} else {
+		r.health.Status = "exited"
+	}
+	i := r.I
+	health := r.health
+	m.Unlock()
+
+	if err != nil {
+		logrus.Errorf("%s:%s exited: %s", i.Plugin, i.Name, err.Error())
+	} else {
+		logrus.Warnf("%s:%s exited", i.Plugin, i.Name)
+	}
+	events.Fire(&events.Event{
+		Event:  "plugin_exit",
+		Plugin: &i.Plugin,
+		Data: map[string]interface{}{
+			"name":    i.Name,
+			"error":   health.LastError,
+			"restart": restart,
+		},
+	})
+
+	if restart {
+		logrus.Infof("%s:%s restarting in %s", i.Plugin, i.Name, delay)
+		time.AfterFunc(delay, func() { m.restart(r) })
+	}
+}
+
+// restart starts the runner's process again
+func (m *Manager) restart(r *Runner) {
+	m.RLock()
+	cur, ok := m.Runners[r.I.APIKey]
+	killed := m.killed
+	rt := m.RunTypes[*r.I.Run.Type]
+	m.RUnlock()
+	if !ok || cur != r || killed {
+		return
+	}
+
+	h, err := rt.Start(r.I)
+
+	m.Lock()
+	if _, ok = m.Runners[r.I.APIKey]; !ok || m.killed {
+		// The runner was stopped while restarting
+		m.Unlock()
+		if err == nil {
+			rt.Stop(r.I.APIKey)
+		}
+		return
+	}
+	if err != nil {
+		r.started(err)
+		m.supervise(r, fmt.Errorf("restart failed: %w", err))
+		return
+	}
+	r.Handler = m.wrapHandler(r.I, h)
+	r.health.Restarts++
+	exited, exitErr := r.started(nil)
+	health := r.health
+	if exited {
+		m.supervise(r, exitErr)
+	} else {
+		m.Unlock()
+	}
+
+	logrus.Infof("%s:%s restarted", r.I.Plugin, r.I.Name)
+	events.Fire(&events.Event{
+		Event:  "plugin_restart",
+		Plugin: &r.I.Plugin,
+		Data: map[string]interface{}{
+			"name":     r.I.Name,
+			"restarts": health.Restarts,
+		},
+	})
+}
+
+// Health returns the status of all plugin runners
+func (m *Manager) Health() []RunnerHealth {
+	m.RLock()
+	defer m.RUnlock()
+	h := make([]RunnerHealth, 0, len(m.Runners))
+	for _, r := range m.Runners {
+		if r.I.Run == nil {
+			continue // The heedy core
+		}
+		h = append(h, r.health)
+	}
+	sort.Slice(h, func(i, j int) bool {
+		if h[i].Plugin == h[j].Plugin {
+			return h[i].Name < h[j].Name
+		}
+		return h[i].Plugin < h[j].Plugin
+	})
+	return h
+}
@@ -0,0 +1,120 @@
+package run
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/stretchr/testify/require"
+)
+
+type testRunType struct {
+	sync.Mutex
+	starts int
+}
+
+func (t *testRunType) Start(i *Info) (http.Handler, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.starts++
+	return nil, nil
+}
+func (t *testRunType) Run(i *Info) error        { return nil }
+func (t *testRunType) Stop(apikey string) error { return nil }
+func (t *testRunType) Kill(apikey string) error { return nil }
+
+func (t *testRunType) Starts() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.starts
+}
+
+func testSupervisedRunner(policy string, maxRestarts int) (*Manager, *testRunType, *Runner) {
+	rt := &testRunType{}
+	tp := "test"
+	delay := "1ms"
+	m := &Manager{
+		RunTypes: map[string]TypeHandler{"test": rt},
+		Runners:  make(map[string]*Runner),
+	}
+	r := &Runner{
+		I: &Info{
+			Plugin: "myplugin",
+			Name:   "myrunner",
+			APIKey: "key",
+			Run: &assets.Run{
+				Type:         &tp,
+				Restart:      &policy,
+				MaxRestarts:  &maxRestarts,
+				RestartDelay: &delay,
+			},
+		},
+		m:      m,
+		health: RunnerHealth{Plugin: "myplugin", Name: "myrunner", Type: tp, Status: "starting"},
+	}
+	m.Runners["key"] = r
+	m.Lock()
+	r.started(nil)
+	m.Unlock()
+	return m, rt, r
+}
+
+func waitForStatus(t *testing.T, m *Manager, status string) RunnerHealth {
+	for i := 0; i < 200; i++ {
+		h := m.Health()
+		if h[0].Status == status {
+			return h[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("runner did not reach status %s", status)
+	return RunnerHealth{}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	m, rt, _ := testSupervisedRunner("on-failure", 2)
+
+	m.processExited("key", errors.New("crashed"))
+	h := waitForStatus(t, m, "running")
+	require.Equal(t, 1, h.Restarts)
+	require.Equal(t, "crashed", h.LastError)
+	require.Equal(t, 1, rt.Starts())
+
+	m.processExited("key", errors.New("crashed again"))
+	h = waitForStatus(t, m, "running")
+	require.Equal(t, 2, h.Restarts)
+
+	// The runner is not restarted after max_restarts consecutive restarts
+	m.processExited("key", errors.New("crashed once more"))
+	h = waitForStatus(t, m, "failed")
+	require.Equal(t, 2, h.Restarts)
+	require.Equal(t, 2, rt.Starts())
+}
+
+func TestSupervisorPolicy(t *testing.T) {
+	// on-failure does not restart runners that exit cleanly
+	m, rt, _ := testSupervisedRunner("on-failure", 5)
+	m.processExited("key", nil)
+	waitForStatus(t, m, "exited")
+	require.Equal(t, 0, rt.Starts())
+
+	m, rt, _ = testSupervisedRunner("always", 5)
+	m.processExited("key", nil)
+	waitForStatus(t, m, "running")
+	require.Equal(t, 1, rt.Starts())
+
+	m, rt, _ = testSupervisedRunner("no", 5)
+	m.processExited("key", errors.New("crashed"))
+	waitForStatus(t, m, "failed")
+	require.Equal(t, 0, rt.Starts())
+
+	// Exits of stopped runners and during shutdown are ignored
+	m, rt, _ = testSupervisedRunner("always", 5)
+	m.killed = true
+	m.processExited("key", nil)
+	require.Equal(t, "running", m.Health()[0].Status)
+	require.Equal(t, 0, rt.Starts())
+}
@@ -0,0 +1,125 @@
+//go:build wasip1
+
+// This is a test plugin for the wasm runtype. It is built with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o plugin.wasm
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"unsafe"
+)
+
+type request struct {
+	Method  string      `json:"method"`
+	URI     string      `json:"uri"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+type response struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// Memory allocated for heedy is kept here, so that it isn't garbage collected
+var allocs = map[uint32][]byte{}
+
+var plugin string
+
+//go:wasmimport heedy api
+func heedyAPI(ptr, length uint32) uint64
+
+//go:wasmimport heedy fire_event
+func heedyFireEvent(ptr, length uint32) uint32
+
+//go:wasmexport heedy_malloc
+func heedyMalloc(size uint32) uint32 {
+	b := make([]byte, size+1)
+	ptr := uint32(uintptr(unsafe.Pointer(&b[0])))
+	allocs[ptr] = b
+	return ptr
+}
+
+//go:wasmexport heedy_free
+func heedyFree(ptr uint32) {
+	delete(allocs, ptr)
+}
+
+func load(ptr uint32, length uint32) []byte {
+	b := allocs[ptr][:length]
+	delete(allocs, ptr)
+	return b
+}
+
+func store(b []byte) uint64 {
+	ptr := heedyMalloc(uint32(len(b)))
+	copy(allocs[ptr], b)
+	return uint64(ptr)<<32 | uint64(len(b))
+}
+
+func heedy(req *request) *response {
+	b, _ := json.Marshal(req)
+	ptr := heedyMalloc(uint32(len(b)))
+	copy(allocs[ptr], b)
+	res := heedyAPI(ptr, uint32(len(b)))
+	var r response
+	json.Unmarshal(load(uint32(res>>32), uint32(res)), &r)
+	delete(allocs, ptr)
+	return &r
+}
+
+//go:wasmexport heedy_request
+func heedyRequest(ptr, length uint32) uint64 {
+	var req request
+	json.Unmarshal(load(ptr, length), &req)
+
+	var res *response
+	switch req.URI {
+	case "/heedy":
+		res = heedy(&request{Method: "GET", URI: "/api/server/version"})
+	case "/event":
+		b := []byte(`{"event":"wasm_event","plugin":"` + plugin + `"}`)
+		ptr := heedyMalloc(uint32(len(b)))
+		copy(allocs[ptr], b)
+		status := 200
+		if heedyFireEvent(ptr, uint32(len(b))) != 0 {
+			status = 500
+		}
+		delete(allocs, ptr)
+		res = &response{Status: status}
+	case "/file":
+		b, err := os.ReadFile("/heedy.conf")
+		if err != nil {
+			res = &response{Status: 500, Body: []byte(err.Error())}
+		} else {
+			res = &response{Status: 200, Body: b}
+		}
+	case "/exit":
+		os.Exit(1)
+	default:
+		res = &response{
+			Status:  200,
+			Headers: http.Header{"Content-Type": []string{"text/plain"}},
+			Body:    []byte(plugin + " " + req.Method + " " + req.URI + " " + string(req.Body)),
+		}
+	}
+	b, _ := json.Marshal(res)
+	return store(b)
+}
+
+func init() {
+	// The runner's info is given on stdin
+	var info struct {
+		Plugin string `json:"plugin"`
+	}
+	line, _ := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	json.Unmarshal(line, &info)
+	plugin = info.Plugin
+}
+
+func main() {}
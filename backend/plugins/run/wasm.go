@@ -0,0 +1,468 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/heedy/heedy/backend/database"
+)
+
+// The wasm runtype runs a WASI module inside heedy's process. The module's memory is limited
+// by memory_limit, and it can only access the plugin's folder (read-only) and its writable_dirs.
+// Like the exec runtype, the module gets the runner's Info as JSON on stdin.
+//
+// A module that handles requests exports:
+//
+//	heedy_malloc(size i32) i32 - allocates size bytes, which heedy writes to
+//	heedy_request(ptr i32, len i32) i64 - handles the JSON-encoded WasmRequest at ptr, and returns the
+//		location of its JSON-encoded WasmResponse as (ptr << 32) | len
+//	heedy_free(ptr i32) - optional, frees memory returned by heedy_request
+//
+// Heedy also provides the following functions in the "heedy" module, which make requests
+// to heedy as the plugin:
+//
+//	api(ptr i32, len i32) i64 - makes the JSON-encoded WasmRequest to heedy's API, and returns the
+//		location of the JSON-encoded WasmResponse (allocated with heedy_malloc) as (ptr << 32) | len
+//	fire_event(ptr i32, len i32) i32 - fires the JSON-encoded event, returning 0 on success
+//
+// Calls into a module are not concurrent, so a request that a module makes to heedy while handling a request
+// can't be answered by the same module. Such requests are marked with the X-Heedy-Wasm-Call header,
+// and fail with an error instead of deadlocking.
+//
+// Reactor modules are initialized with _initialize when started, and cron jobs run a command module's _start.
+
+// WasmRequest is the JSON representation of an http request passed to and from wasm modules
+type WasmRequest struct {
+	Method  string      `json:"method"`
+	URI     string      `json:"uri"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// WasmResponse is the JSON representation of an http response passed to and from wasm modules
+type WasmResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// wasmPageSize is the size of a page of wasm memory
+const wasmPageSize = 65536
+
+// wasmCallHeader holds the call tokens of the modules that are handling the requests that led to a request
+const wasmCallHeader = "X-Heedy-Wasm-Call"
+
+type wasmModule struct {
+	// Calls into a module are not concurrent
+	sync.Mutex
+
+	i       *Info
+	runtime wazero.Runtime
+	mod     api.Module
+	heedy   http.Handler
+
+	// The module's output, which is flushed when it is closed
+	stdout io.Writer
+	stderr io.Writer
+
+	closed bool
+
+	// Identifies requests made by the module while it handles a request
+	callToken string
+	// The call tokens of the request that the module is currently handling, which are passed on
+	// to the requests that it makes
+	callers []string
+}
+
+type WasmHandler struct {
+	sync.Mutex
+	DB      *database.AdminDB
+	Modules map[string]*wasmModule
+}
+
+func NewWasmHandler(db *database.AdminDB) *WasmHandler {
+	return &WasmHandler{
+		DB:      db,
+		Modules: make(map[string]*wasmModule),
+	}
+}
+
+// wasmConfig returns the runtime configuration for the runner, applying its limits
+func wasmConfig(i *Info) (wazero.RuntimeConfig, error) {
+	rc := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	r := i.Run
+	if r.CPULimit != nil || r.User != nil {
+		return nil, errors.New("the wasm runtype does not support cpu_limit or user")
+	}
+	if r.MemoryLimit != nil {
+		m, err := assets.ParseByteSize(*r.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		pages := m / wasmPageSize
+		if pages < 1 {
+			return nil, fmt.Errorf("memory_limit of %s is less than a single wasm page", *r.MemoryLimit)
+		}
+		if pages > 65536 {
+			pages = 65536
+		}
+		rc = rc.WithMemoryLimitPages(uint32(pages))
+	}
+	return rc, nil
+}
+
+// wasmFS gives the module read-only access to the plugin folder, and write access to its writable_dirs
+func wasmFS(i *Info) (wazero.FSConfig, error) {
+	fsc := wazero.NewFSConfig().WithReadOnlyDirMount(i.PluginDir, "/")
+	if i.Run.WritableDirs != nil {
+		for _, d := range *i.Run.WritableDirs {
+			rel := filepath.Clean(d)
+			if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+				return nil, fmt.Errorf("writable_dirs of wasm runners must be within the plugin folder (%s)", d)
+			}
+			fsc = fsc.WithDirMount(path.Join(i.PluginDir, rel), "/"+filepath.ToSlash(rel))
+		}
+	}
+	return fsc, nil
+}
+
+// instantiate loads the runner's wasm module, and initializes it by calling the given start functions
+func (wh *WasmHandler) instantiate(i *Info, startFunctions ...string) (*wasmModule, error) {
+	pathv, ok := i.Run.Config["path"]
+	if !ok {
+		return nil, errors.New("wasm runtype requires the path of the module")
+	}
+	modpath, ok := pathv.(string)
+	if !ok {
+		return nil, errors.New("wasm path must be a string")
+	}
+	b, err := ioutil.ReadFile(path.Join(i.PluginDir, modpath))
+	if err != nil {
+		return nil, err
+	}
+	rc, err := wasmConfig(i)
+	if err != nil {
+		return nil, err
+	}
+	fsc, err := wasmFS(i)
+	if err != nil {
+		return nil, err
+	}
+	if i.Config == nil || i.Config.API == nil {
+		return nil, errors.New("heedy's api must be configured to run wasm modules")
+	}
+	heedy, err := NewReverseProxy(i.DataDir, *i.Config.API)
+	if err != nil {
+		return nil, err
+	}
+
+	wm := &wasmModule{
+		i:         i,
+		heedy:     heedy,
+		stdout:    Output(i, "stdout"),
+		stderr:    Output(i, "stderr"),
+		callToken: uuid.New().String(),
+	}
+	ctx := context.Background()
+	wm.runtime = wazero.NewRuntimeWithConfig(ctx, rc)
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, wm.runtime); err != nil {
+		wm.runtime.Close(ctx)
+		return nil, err
+	}
+	_, err = wm.runtime.NewHostModuleBuilder("heedy").
+		NewFunctionBuilder().WithFunc(wm.hostAPI).Export("api").
+		NewFunctionBuilder().WithFunc(wm.hostFireEvent).Export("fire_event").
+		Instantiate(ctx)
+	if err != nil {
+		wm.runtime.Close(ctx)
+		return nil, err
+	}
+
+	infobytes, err := json.Marshal(i)
+	if err != nil {
+		wm.runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := wm.runtime.CompileModule(ctx, b)
+	if err != nil {
+		wm.runtime.Close(ctx)
+		return nil, err
+	}
+	wm.mod, err = wm.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName(i.Plugin+":"+i.Name).
+		WithArgs(modpath).
+		WithStdin(bytes.NewReader(append(infobytes, '\n'))).
+		WithStdout(wm.stdout).
+		WithStderr(wm.stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithFSConfig(fsc).
+		WithStartFunctions(startFunctions...))
+	if err != nil {
+		wm.runtime.Close(ctx)
+		FlushOutput(wm.stdout, wm.stderr)
+		return nil, err
+	}
+	return wm, nil
+}
+
+func (wh *WasmHandler) Start(i *Info) (http.Handler, error) {
+	wm, err := wh.instantiate(i, "_initialize")
+	if err != nil {
+		return nil, err
+	}
+	wh.Lock()
+	wh.Modules[i.APIKey] = wm
+	wh.Unlock()
+
+	if wm.mod.ExportedFunction("heedy_request") == nil {
+		// The module does not handle requests
+		return nil, nil
+	}
+	if wm.mod.ExportedFunction("heedy_malloc") == nil {
+		wh.Kill(i.APIKey)
+		return nil, errors.New("wasm modules that export heedy_request must also export heedy_malloc")
+	}
+	return wm, nil
+}
+
+func (wh *WasmHandler) Run(i *Info) error {
+	wm, err := wh.instantiate(i, "_start")
+	if err != nil {
+		return err
+	}
+	return wm.close()
+}
+
+func (wh *WasmHandler) Stop(apikey string) error {
+	return wh.Kill(apikey)
+}
+
+func (wh *WasmHandler) Kill(apikey string) error {
+	wh.Lock()
+	wm, ok := wh.Modules[apikey]
+	delete(wh.Modules, apikey)
+	wh.Unlock()
+	if !ok {
+		return errors.New("Couldn't find the wasm module")
+	}
+	return wm.close()
+}
+
+// close closes the module's runtime, interrupting any running calls
+func (wm *wasmModule) close() error {
+	err := wm.runtime.Close(context.Background())
+	FlushOutput(wm.stdout, wm.stderr)
+	wm.Lock()
+	wm.closed = true
+	wm.Unlock()
+	return err
+}
+
+// read returns a copy of the given memory of the module
+func (wm *wasmModule) read(m api.Module, ptr, length uint32) ([]byte, error) {
+	b, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return nil, fmt.Errorf("wasm memory access out of range (%d, %d)", ptr, length)
+	}
+	return append([]byte{}, b...), nil
+}
+
+// write allocates memory in the module with heedy_malloc, and writes the given data to it
+func (wm *wasmModule) write(ctx context.Context, m api.Module, data []byte) (uint32, error) {
+	malloc := m.ExportedFunction("heedy_malloc")
+	if malloc == nil {
+		return 0, errors.New("wasm module does not export heedy_malloc")
+	}
+	res, err := malloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(res[0])
+	if !m.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("wasm memory access out of range (%d, %d)", ptr, len(data))
+	}
+	return ptr, nil
+}
+
+// request makes the given request to heedy as the plugin
+func (wm *wasmModule) request(wr *WasmRequest) *WasmResponse {
+	req, err := http.NewRequest(wr.Method, wr.URI, bytes.NewReader(wr.Body))
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err)
+	}
+	for k, v := range wr.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Heedy-Key", wm.i.APIKey)
+	req.Header[wasmCallHeader] = append(append([]string{}, wm.callers...), wm.callToken)
+	rs := NewResponseStreamer()
+	code := rs.Serve(wm.heedy, req)
+	body, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, err)
+	}
+	return &WasmResponse{
+		Status:  code,
+		Headers: rs.Header(),
+		Body:    body,
+	}
+}
+
+func errorResponse(code int, err error) *WasmResponse {
+	b, _ := json.Marshal(map[string]string{
+		"error":             "plugin_error",
+		"error_description": err.Error(),
+	})
+	return &WasmResponse{
+		Status:  code,
+		Headers: http.Header{"Content-Type": []string{"application/json"}},
+		Body:    b,
+	}
+}
+
+// hostAPI is the heedy.api function available to wasm modules
+func (wm *wasmModule) hostAPI(ctx context.Context, m api.Module, ptr, length uint32) uint64 {
+	var wr WasmRequest
+	var res *WasmResponse
+	b, err := wm.read(m, ptr, length)
+	if err == nil {
+		err = json.Unmarshal(b, &wr)
+	}
+	if err != nil {
+		res = errorResponse(http.StatusBadRequest, err)
+	} else {
+		res = wm.request(&wr)
+	}
+	b, err = json.Marshal(res)
+	if err == nil {
+		ptr, err = wm.write(ctx, m, b)
+	}
+	if err != nil {
+		logrus.Errorf("%s:%s heedy.api failed: %s", wm.i.Plugin, wm.i.Name, err)
+		return 0
+	}
+	return uint64(ptr)<<32 | uint64(len(b))
+}
+
+// hostFireEvent is the heedy.fire_event function available to wasm modules
+func (wm *wasmModule) hostFireEvent(ctx context.Context, m api.Module, ptr, length uint32) uint32 {
+	b, err := wm.read(m, ptr, length)
+	if err != nil {
+		logrus.Errorf("%s:%s heedy.fire_event failed: %s", wm.i.Plugin, wm.i.Name, err)
+		return 1
+	}
+	res := wm.request(&WasmRequest{
+		Method:  "POST",
+		URI:     "/api/events",
+		Headers: http.Header{"Content-Type": []string{"application/json"}},
+		Body:    b,
+	})
+	if res.Status >= 400 {
+		logrus.Errorf("%s:%s heedy.fire_event failed: %s", wm.i.Plugin, wm.i.Name, string(res.Body))
+		return 1
+	}
+	return 0
+}
+
+// ServeHTTP bridges http requests to the module's heedy_request function
+func (wm *wasmModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res, err := wm.serve(r)
+	if err != nil {
+		res = errorResponse(http.StatusInternalServerError, err)
+	}
+	for k, v := range res.Headers {
+		w.Header()[k] = v
+	}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+}
+
+func (wm *wasmModule) serve(r *http.Request) (*WasmResponse, error) {
+	callers := r.Header.Values(wasmCallHeader)
+	for _, c := range callers {
+		if c == wm.callToken {
+			return nil, errors.New("The wasm module can't handle a request that it made while handling a request")
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, wm.i.Config.GetRequestBodyByteLimit()))
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(&WasmRequest{
+		Method:  r.Method,
+		URI:     r.URL.RequestURI(),
+		Headers: r.Header,
+		Body:    body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wm.Lock()
+	defer wm.Unlock()
+	if wm.closed {
+		return nil, errors.New("The wasm module is not running")
+	}
+	wm.callers = callers
+	defer func() { wm.callers = nil }()
+	ctx := context.Background()
+	ptr, err := wm.write(ctx, wm.mod, b)
+	if err != nil {
+		return nil, wm.exited(err)
+	}
+	res, err := wm.mod.ExportedFunction("heedy_request").Call(ctx, uint64(ptr), uint64(len(b)))
+	if err != nil {
+		return nil, wm.exited(err)
+	}
+	rptr, rlen := uint32(res[0]>>32), uint32(res[0])
+	rb, err := wm.read(wm.mod, rptr, rlen)
+	if err != nil {
+		return nil, err
+	}
+	if free := wm.mod.ExportedFunction("heedy_free"); free != nil {
+		if _, err = free.Call(ctx, uint64(rptr)); err != nil {
+			return nil, wm.exited(err)
+		}
+	}
+	var wr WasmResponse
+	if err = json.Unmarshal(rb, &wr); err != nil {
+		return nil, fmt.Errorf("invalid response from wasm module: %w", err)
+	}
+	return &wr, nil
+}
+
+// exited checks whether the module exited during a call, and if so, notifies the run manager,
+// so that the runner is restarted according to its restart policy.
+func (wm *wasmModule) exited(err error) error {
+	if !wm.mod.IsClosed() {
+		return err
+	}
+	wm.closed = true
+	wm.runtime.Close(context.Background())
+	FlushOutput(wm.stdout, wm.stderr)
+	logMessage(wm.i, fmt.Sprintf("wasm module exited: %s", err))
+	go notifyExit(wm.i.APIKey, err)
+	return err
+}
@@ -0,0 +1,144 @@
+package run
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+// buildTestWasm compiles the test plugin in testdata/wasm into the given folder
+func buildTestWasm(t *testing.T, dir string) {
+	src, err := filepath.Abs("testdata/wasm")
+	require.NoError(t, err)
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", path.Join(dir, "plugin.wasm"), ".")
+	cmd.Dir = src
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("Could not build the test wasm module: %s", string(out))
+	}
+}
+
+func wasmRequest(h http.Handler, method, uri, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, uri, strings.NewReader(body)))
+	return rec
+}
+
+func TestWasm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-wasm-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	buildTestWasm(t, dir)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "heedy.conf"), []byte("plugin conf"), 0664))
+
+	// A fake heedy server, which the plugin accesses with its API key
+	var lock sync.Mutex
+	events := []string{}
+	// If set, requests are passed back to the plugin, like heedy does for the plugin's routes
+	var loop http.Handler
+	heedy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Heedy-Key") != "wasmkey" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if loop != nil {
+			loop.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/server/version":
+			w.Write([]byte(`"1.2.3"`))
+		case "/api/events":
+			b, _ := ioutil.ReadAll(r.Body)
+			lock.Lock()
+			events = append(events, string(b))
+			lock.Unlock()
+			w.Write([]byte(`{"result":"ok"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer heedy.Close()
+
+	wasmtype := "wasm"
+	bodyLimit := int64(1 << 20)
+	info := func(memoryLimit string) *Info {
+		r := &assets.Run{
+			Type:   &wasmtype,
+			Config: map[string]interface{}{"path": "plugin.wasm"},
+		}
+		if memoryLimit != "" {
+			r.MemoryLimit = &memoryLimit
+		}
+		return &Info{
+			Plugin:    "wasmplugin",
+			Name:      "backend",
+			APIKey:    "wasmkey",
+			Run:       r,
+			DataDir:   dir,
+			PluginDir: dir,
+			Config: &assets.Configuration{
+				API:                  &heedy.URL,
+				RequestBodyByteLimit: &bodyLimit,
+			},
+		}
+	}
+
+	wh := NewWasmHandler(nil)
+
+	// The go runtime doesn't fit in a single megabyte
+	_, err = wh.Start(info("1MB"))
+	require.Error(t, err)
+
+	h, err := wh.Start(info("256MB"))
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	rec := wasmRequest(h, "POST", "/hello?q=1", "world")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "wasmplugin POST /hello?q=1 world", rec.Body.String())
+
+	rec = wasmRequest(h, "GET", "/heedy", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"1.2.3"`, rec.Body.String())
+
+	rec = wasmRequest(h, "GET", "/event", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{`{"event":"wasm_event","plugin":"wasmplugin"}`}, events)
+
+	// Requests that come back to the module while it is handling a request fail instead of deadlocking
+	loop = h
+	rec = wasmRequest(h, "GET", "/heedy", "")
+	loop = nil
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Contains(t, rec.Body.String(), "made while handling a request")
+
+	rec = wasmRequest(h, "GET", "/file", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "plugin conf", rec.Body.String())
+
+	// Exiting closes the module
+	rec = wasmRequest(h, "GET", "/exit", "")
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	rec = wasmRequest(h, "GET", "/hello", "")
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// Restart the module, and stop it
+	h, err = wh.Start(info(""))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, wasmRequest(h, "GET", "/hello", "").Code)
+	require.NoError(t, wh.Stop("wasmkey"))
+	require.Equal(t, http.StatusInternalServerError, wasmRequest(h, "GET", "/hello", "").Code)
+	require.Error(t, wh.Stop("wasmkey"))
+}
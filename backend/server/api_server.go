@@ -1,13 +1,16 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/heedy/heedy/api/golang/rest"
 	"github.com/heedy/heedy/backend/assets"
@@ -121,6 +124,139 @@ func GetVersion(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(buildinfo.Version))
 }
 
+// GetRunners returns a handler that gives the status of the plugin manager's runners
+func GetRunners(pm *plugins.PluginManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db := rest.CTX(r).DB
+		a := db.AdminDB().Assets()
+		if db.Type() != database.AdminType && !a.Config.UserIsAdmin(db.ID()) {
+			rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: Only admins can view plugin runners"))
+			return
+		}
+		rest.WriteJSON(w, r, pm.RunManager.Health(), nil)
+	}
+}
+
+// ReloadPlugin returns a handler that restarts a single plugin with its current configuration and files,
+// without restarting the rest of heedy
+func ReloadPlugin(pm *plugins.PluginManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db := rest.CTX(r).DB
+		a := db.AdminDB().Assets()
+		if db.Type() != database.AdminType && !a.Config.UserIsAdmin(db.ID()) {
+			rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: Only admins can reload plugins"))
+			return
+		}
+		pluginName, err := rest.URLParam(r, "pluginname", nil)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		rest.WriteResult(w, r, pm.ReloadPlugin(pluginName))
+	}
+}
+
+// GetPluginLogs returns a handler that gives the captured output of the given plugin's runners.
+// The since query parameter limits the entries to those after a time (RFC3339, unix timestamp, or a duration
+// such as 1h for the last hour), and follow=true streams new entries as newline-delimited JSON.
+func GetPluginLogs(pm *plugins.PluginManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db := rest.CTX(r).DB
+		a := db.AdminDB().Assets()
+		if db.Type() != database.AdminType && !a.Config.UserIsAdmin(db.ID()) {
+			rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: Only admins can view plugin logs"))
+			return
+		}
+		pluginName, err := rest.URLParam(r, "pluginname", nil)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if strings.ContainsAny(pluginName, "/.\\") {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("Invalid character in plugin name"))
+			return
+		}
+		var since time.Time
+		if s := r.URL.Query().Get("since"); s != "" {
+			since, err = parseSince(s)
+			if err != nil {
+				rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+		l, ok := pm.RunManager.Logs.Lookup(pluginName)
+		if !ok {
+			// Active plugins that haven't written any output yet can still be followed
+			active := false
+			for _, p := range a.Config.GetActivePlugins() {
+				if p == pluginName {
+					active = true
+				}
+			}
+			if !active {
+				rest.WriteJSONError(w, r, http.StatusNotFound, errors.New("not_found: The plugin has no logs"))
+				return
+			}
+			l = pm.RunManager.Logs.Get(pluginName)
+		}
+		if r.URL.Query().Get("follow") != "true" {
+			entries, err := l.Read(since)
+			rest.WriteJSON(w, r, entries, err)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("bad_request: Following logs is not supported by this connection"))
+			return
+		}
+
+		// Subscribe before reading the existing entries, so that no entries are missed
+		c := l.Subscribe()
+		defer l.Unsubscribe(c)
+		entries, err := l.Read(since)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		var last time.Time
+		for _, e := range entries {
+			enc.Encode(e)
+			last = e.Timestamp
+		}
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-c:
+				if e.Timestamp.After(last) && !e.Timestamp.Before(since) {
+					if err = enc.Encode(e); err != nil {
+						return
+					}
+					flusher.Flush()
+				}
+			}
+		}
+	}
+}
+
+// parseSince parses the since query parameter of the logs endpoint
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(f*1e9)), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("bad_query: Could not parse since time '%s'", s)
+}
+
 func GetAdminUsers(w http.ResponseWriter, r *http.Request) {
 	db := rest.CTX(r).DB
 	a := db.AdminDB().Assets()
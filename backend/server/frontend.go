@@ -33,6 +33,7 @@ type frontendPlugin struct {
 type FrontendContext struct {
 	User     *database.User                    `json:"user"`
 	Settings map[string]map[string]interface{} `json:"settings"`
+	Locale   *assets.UserLocale                `json:"locale"`
 	Admin    bool                              `json:"admin"`
 	Plugins  []frontendPlugin                  `json:"plugins"`
 	Preload  []string                          `json:"preload"`
@@ -109,6 +110,7 @@ func GetFrontendContext(ctx *rest.Context) (*FrontendContext, error) {
 	if u == nil {
 		return &FrontendContext{
 			User:    nil,
+			Locale:  cfg.GetUserLocale(nil),
 			Admin:   false,
 			Plugins: frontendPlugins,
 			Preload: preloads,
@@ -127,6 +129,7 @@ func GetFrontendContext(ctx *rest.Context) (*FrontendContext, error) {
 	return &FrontendContext{
 		User:     u,
 		Settings: pref,
+		Locale:   cfg.GetUserLocale(pref["heedy"]),
 		Admin:    ctx.DB.AdminDB().Assets().Config.UserIsAdmin(*u.UserName),
 		Plugins:  frontendPlugins,
 		Preload:  preloads,
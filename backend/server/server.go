@@ -62,6 +62,9 @@ func Run(a *assets.Assets, o *RunOptions) error {
 		db.Close()
 		return err
 	}
+	apiMux.Get("/server/runners", GetRunners(pm))
+	apiMux.Get("/server/plugins/{pluginname}/logs", GetPluginLogs(pm))
+	apiMux.Post("/server/plugins/{pluginname}/reload", ReloadPlugin(pm))
 
 	requestHandler := http.Handler(NewRequestHandler(auth, pm))
 
@@ -101,28 +101,20 @@ func ReadConfig(configDir string) (*assets.Configuration, error) {
 	return assets.LoadConfigFile(updateHeedy)
 }
 
+// EnablePlugins adds the given plugins to active_plugins, along with any plugins they require.
+// Dependencies are placed before the plugins that require them, so that they are started first.
 func EnablePlugins(configDir string, pname []string) error {
 	c, err := ReadConfig(configDir)
 	if err != nil {
 		return err
 	}
-	if c.ActivePlugins == nil {
-		return ModifyConfigFile(configDir, &assets.Configuration{ActivePlugins: &pname})
+	available, builtin, err := availablePlugins(configDir)
+	if err != nil {
+		return err
 	}
-	ap := *c.ActivePlugins
-	hadModification := false
-	for _, pn := range pname {
-		alreadyExists := false
-		for _, p2 := range ap {
-			if pn == p2 {
-				alreadyExists = true
-				break
-			}
-		}
-		if !alreadyExists {
-			ap = append(ap, pn)
-			hadModification = true
-		}
+	ap, hadModification, err := resolveActivePlugins(c, available, builtin, pname)
+	if err != nil {
+		return err
 	}
 	if hadModification {
 		return ModifyConfigFile(configDir, &assets.Configuration{ActivePlugins: &ap})
@@ -130,6 +122,8 @@ func EnablePlugins(configDir string, pname []string) error {
 	return nil
 }
 
+// DisablePlugins removes the given plugins from active_plugins. Plugins that are required by another active plugin
+// can't be disabled.
 func DisablePlugins(configDir string, pname []string) error {
 	c, err := ReadConfig(configDir)
 	if err != nil {
@@ -138,24 +132,27 @@ func DisablePlugins(configDir string, pname []string) error {
 	if c.ActivePlugins == nil {
 		return nil
 	}
-	ap := *c.ActivePlugins
-	hadModification := false
+	disable := make(map[string]bool)
 	for _, pn := range pname {
-		for i := range ap {
-			if pn == ap[i] {
-				// The plugin is at i - shift the remaining elements
-				for j := i + 1; j < len(ap); j++ {
-					ap[j-1] = ap[j]
-				}
-				ap = ap[:len(ap)-1]
-				hadModification = true
-			}
+		disable[pn] = true
+	}
+	ap := make([]string, 0, len(*c.ActivePlugins))
+	for _, pn := range *c.ActivePlugins {
+		if !disable[pn] {
+			ap = append(ap, pn)
 		}
 	}
-	if hadModification {
-		return ModifyConfigFile(configDir, &assets.Configuration{ActivePlugins: &ap})
+	if len(ap) == len(*c.ActivePlugins) {
+		return nil
 	}
-	return nil
+	available, builtin, err := availablePlugins(configDir)
+	if err != nil {
+		return err
+	}
+	if err = checkDependents(available, builtin, ap, pname); err != nil {
+		return err
+	}
+	return ModifyConfigFile(configDir, &assets.Configuration{ActivePlugins: &ap})
 }
 
 func ReadOptions(configDir string) (*UpdateOptions, error) {
@@ -273,6 +270,15 @@ func GetReadme(configDir string, pluginName string) (io.ReadCloser, error) {
 }
 
 func UpdatePlugin(configDir string, zipFile string) error {
+	cfg, err := ReadConfig(configDir)
+	if err != nil {
+		return err
+	}
+	// Make sure that the plugin was not tampered with before extracting anything
+	if err = VerifyPlugin(zipFile, cfg); err != nil {
+		return err
+	}
+
 	// Extract the file into a temporary directory
 	tmpDir, err := ioutil.TempDir(configDir, "tmp-plugin-")
 	if err != nil {
@@ -343,10 +349,21 @@ func UpdatePlugin(configDir string, zipFile string) error {
 	if err != nil {
 		return err
 	}
-	if _, ok := c.Plugins[d[0].Name()]; !ok {
+	np, ok := c.Plugins[d[0].Name()]
+	if !ok {
 		return errors.New("The plugin folder and name must match")
 	}
 
+	// Make sure that the plugin can be enabled before staging it
+	available, builtin, err := availablePlugins(configDir)
+	if err != nil {
+		return err
+	}
+	available[pn] = np
+	if _, _, err = resolveActivePlugins(cfg, available, builtin, []string{pn}); err != nil {
+		return err
+	}
+
 	// OK, looks like the plugin passed sanity checks. Let's copy it over to the updates folder
 	if err = os.MkdirAll(path.Join(configDir, "updates", "plugins"), os.ModePerm); err != nil {
 		return err
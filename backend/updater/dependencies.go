@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+// availablePlugins returns the configuration of all plugins that can be active, which are the builtin plugins
+// active by default, and the plugins installed in the config folder (including those pending restart).
+// It also returns the list of builtin plugins, which are always active, and therefore never added to active_plugins.
+func availablePlugins(configDir string) (map[string]*assets.Plugin, *[]string, error) {
+	b, err := afero.ReadFile(assets.BuiltinAssets(), "/heedy.conf")
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := assets.LoadConfigBytes(b, "heedy.conf")
+	if err != nil {
+		return nil, nil, err
+	}
+	available, err := ListPlugins(configDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	builtin := base.ActivePlugins
+	if builtin == nil {
+		builtin = &[]string{}
+	}
+	for _, pname := range *builtin {
+		if p, ok := base.Plugins[pname]; ok {
+			available[pname] = p
+		}
+	}
+	return available, builtin, nil
+}
+
+// resolveActivePlugins returns the new active_plugins list after enabling the given plugins, along with the
+// plugins they require. An error is returned if the resulting set of active plugins would be incompatible.
+func resolveActivePlugins(c *assets.Configuration, available map[string]*assets.Plugin, builtin *[]string, pname []string) ([]string, bool, error) {
+	enable, err := assets.ResolvePlugins(available, pname)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ap := []string{}
+	if c.ActivePlugins != nil {
+		ap = append(ap, *c.ActivePlugins...)
+	}
+	isBuiltin := make(map[string]bool)
+	for _, p := range *builtin {
+		isBuiltin[p] = true
+	}
+	hadModification := c.ActivePlugins == nil
+	for _, pn := range enable {
+		if isBuiltin[pn] {
+			continue
+		}
+		alreadyExists := false
+		for _, p2 := range ap {
+			if pn == p2 || "+"+pn == p2 {
+				alreadyExists = true
+				break
+			}
+		}
+		if !alreadyExists {
+			ap = append(ap, pn)
+			hadModification = true
+		}
+	}
+
+	// Finally, make sure that the plugins that were already active are compatible with the new ones
+	if _, err = assets.ResolvePlugins(available, *assets.MergeStringArrays(builtin, &ap)); err != nil {
+		return nil, false, err
+	}
+	return ap, hadModification, nil
+}
+
+// checkDependents returns an error if any of the plugins that remain active after disabling the given plugins
+// requires one of them, directly or through another plugin, since heedy would then fail to start.
+func checkDependents(available map[string]*assets.Plugin, builtin *[]string, ap []string, disabled []string) error {
+	isDisabled := make(map[string]bool)
+	for _, pn := range disabled {
+		isDisabled[pn] = true
+	}
+	dependents := make(map[string][]string)
+	for _, pn := range *assets.MergeStringArrays(builtin, &ap) {
+		if _, ok := available[pn]; !ok {
+			continue
+		}
+		req, err := assets.ResolvePlugins(available, []string{pn})
+		if err != nil {
+			return err
+		}
+		for _, r := range req {
+			if isDisabled[r] {
+				dependents[r] = append(dependents[r], pn)
+			}
+		}
+	}
+	if len(dependents) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(dependents))
+	for pn, d := range dependents {
+		msgs = append(msgs, fmt.Sprintf("'%s' is required by %s", pn, strings.Join(d, ", ")))
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("bad_request: Can't disable plugins that active plugins require: %s", strings.Join(msgs, "; "))
+}
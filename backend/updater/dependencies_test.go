@@ -0,0 +1,65 @@
+package updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePlugin(t *testing.T, dir, name, conf string) {
+	require.NoError(t, os.MkdirAll(path.Join(dir, name), 0775))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, name, "heedy.conf"), []byte(conf), 0664))
+}
+
+func TestEnablePluginDependencies(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "heedy-updater-")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(configDir, "heedy.conf"), []byte(""), 0664))
+
+	pluginDir := path.Join(configDir, "plugins")
+	writePlugin(t, pluginDir, "base", `plugin "base" { version = "1.0.0" }`)
+	writePlugin(t, pluginDir, "top", `plugin "top" {
+		requires = {
+			"base": ">=1.0.0",
+			"timeseries": ""
+		}
+	}`)
+	writePlugin(t, pluginDir, "needsnew", `plugin "needsnew" {
+		requires = { "base": ">=2.0.0" }
+	}`)
+	writePlugin(t, pluginDir, "future", `plugin "future" { heedy_version = ">=1000.0.0" }`)
+
+	require.Error(t, EnablePlugins(configDir, []string{"needsnew"}))
+	require.Error(t, EnablePlugins(configDir, []string{"future"}))
+	require.Error(t, EnablePlugins(configDir, []string{"missing"}))
+
+	// Enabling a plugin also enables its dependencies first. Builtin plugins are always active,
+	// so they are not added.
+	require.NoError(t, EnablePlugins(configDir, []string{"top"}))
+	c, err := ReadConfig(configDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base", "top"}, *c.ActivePlugins)
+
+	// A plugin that an active plugin requires can't be disabled, but can be disabled along with its dependents
+	err = DisablePlugins(configDir, []string{"base"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'base' is required by top")
+	c, err = ReadConfig(configDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base", "top"}, *c.ActivePlugins)
+	require.NoError(t, DisablePlugins(configDir, []string{"top"}))
+	require.NoError(t, EnablePlugins(configDir, []string{"top"}))
+	require.NoError(t, DisablePlugins(configDir, []string{"base", "top"}))
+	c, err = ReadConfig(configDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{}, *c.ActivePlugins)
+	require.NoError(t, EnablePlugins(configDir, []string{"top"}))
+
+	// Updating base to a version that top does not support fails
+	writePlugin(t, path.Join(configDir, "updates", "plugins"), "base", `plugin "base" { version = "0.5.0" }`)
+	require.Error(t, EnablePlugins(configDir, []string{"base"}))
+}
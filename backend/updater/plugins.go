@@ -124,3 +124,28 @@ func RevertPlugins(configDir, backupDir, revertDir string) error {
 	}
 	return nil
 }
+
+// ApplyPluginUpdate moves a single pending plugin update into the plugin folder, backing up the current
+// version of the plugin. It returns false if there is no pending update for the plugin.
+func ApplyPluginUpdate(configDir, pname string) (bool, error) {
+	updatedPluginLocation := path.Join(configDir, "updates", "plugins", pname)
+	if _, err := os.Stat(updatedPluginLocation); os.IsNotExist(err) {
+		return false, nil
+	}
+	backupDir, err := PrepareBackupFolder(configDir)
+	if err != nil {
+		return false, err
+	}
+	if err = os.MkdirAll(path.Join(backupDir, "plugins"), os.ModePerm); err != nil {
+		return false, err
+	}
+	if err = os.MkdirAll(path.Join(configDir, "plugins"), os.ModePerm); err != nil {
+		return false, err
+	}
+	logrus.Infof("Updating plugin %s", pname)
+	err = ShiftFiles(updatedPluginLocation, path.Join(configDir, "plugins", pname), path.Join(backupDir, "plugins", pname))
+	if err != nil {
+		return false, err
+	}
+	return true, RemoveOldBackups(configDir)
+}
@@ -0,0 +1,297 @@
+package updater
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+const (
+	// ManifestFile is the name of the manifest of file hashes, placed in the plugin's folder
+	ManifestFile = "heedy.manifest"
+	// SignatureFile holds the publisher's signature of the manifest, and is placed next to the manifest
+	SignatureFile = "heedy.manifest.sig"
+)
+
+// Manifest holds the sha256 hashes (hex-encoded) of all files in a plugin, with paths relative to the plugin folder
+type Manifest struct {
+	Files map[string]string `json:"files"`
+}
+
+// Signature is an ed25519 signature of the manifest file's raw bytes
+type Signature struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// pluginZip holds the contents of a plugin zip file relevant to verification
+type pluginZip struct {
+	r *zip.ReadCloser
+	// The plugin folder that is the prefix of all files
+	folder string
+	// The plugin's files, excluding the manifest and signature, by path relative to folder
+	files     map[string]*zip.File
+	manifest  *zip.File
+	signature *zip.File
+}
+
+func openPluginZip(zipFile string) (*pluginZip, error) {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	pz := &pluginZip{
+		r:     r,
+		files: make(map[string]*zip.File),
+	}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		s := strings.SplitN(f.Name, "/", 2)
+		if len(s) < 2 || s[0] == "" {
+			r.Close()
+			return nil, errors.New("The plugin must be in a folder")
+		}
+		if pz.folder == "" {
+			pz.folder = s[0]
+		} else if pz.folder != s[0] {
+			r.Close()
+			return nil, errors.New("Only a single plugin folder per zip file is supported")
+		}
+		switch s[1] {
+		case ManifestFile:
+			pz.manifest = f
+		case SignatureFile:
+			pz.signature = f
+		default:
+			pz.files[s[1]] = f
+		}
+	}
+	if pz.folder == "" {
+		r.Close()
+		return nil, errors.New("Empty zip file")
+	}
+	return pz, nil
+}
+
+func (pz *pluginZip) Close() error {
+	return pz.r.Close()
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func hashZipFile(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyPlugin checks the manifest and signature of a plugin zip file, without extracting it.
+// Packages whose files do not match their manifest, or whose signature is invalid, are always rejected.
+// If the configuration requires plugin signatures, the package must also be signed by a trusted publisher.
+func VerifyPlugin(zipFile string, c *assets.Configuration) error {
+	requireSignature := c.RequirePluginSignatures != nil && *c.RequirePluginSignatures
+	trusted := []string{}
+	if c.TrustedPublishers != nil {
+		trusted = *c.TrustedPublishers
+	}
+
+	pz, err := openPluginZip(zipFile)
+	if err != nil {
+		return err
+	}
+	defer pz.Close()
+
+	if pz.manifest == nil {
+		if requireSignature {
+			return errors.New("The plugin is not signed, and plugin signatures are required")
+		}
+		if pz.signature != nil {
+			return errors.New("The plugin has a signature, but no manifest")
+		}
+		return nil
+	}
+
+	mbytes, err := readZipFile(pz.manifest)
+	if err != nil {
+		return err
+	}
+	var m Manifest
+	if err = json.Unmarshal(mbytes, &m); err != nil {
+		return fmt.Errorf("Invalid plugin manifest: %w", err)
+	}
+
+	// Every file in the zip must be in the manifest with the correct hash, and every file in the manifest
+	// must be in the zip
+	for fname, f := range pz.files {
+		mhash, ok := m.Files[fname]
+		if !ok {
+			return fmt.Errorf("The plugin file %s is not in its manifest", fname)
+		}
+		fhash, err := hashZipFile(f)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(fhash, mhash) {
+			return fmt.Errorf("The plugin file %s does not match its manifest", fname)
+		}
+	}
+	for fname := range m.Files {
+		if _, ok := pz.files[fname]; !ok {
+			return fmt.Errorf("The plugin file %s is missing", fname)
+		}
+	}
+
+	if pz.signature == nil {
+		if requireSignature {
+			return errors.New("The plugin is not signed, and plugin signatures are required")
+		}
+		return nil
+	}
+	sbytes, err := readZipFile(pz.signature)
+	if err != nil {
+		return err
+	}
+	var s Signature
+	if err = json.Unmarshal(sbytes, &s); err != nil {
+		return fmt.Errorf("Invalid plugin signature: %w", err)
+	}
+	pub, err := assets.ParsePublicKey(s.PublicKey)
+	if err != nil {
+		return fmt.Errorf("Invalid plugin signature key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return fmt.Errorf("Invalid plugin signature: %w", err)
+	}
+	if !ed25519.Verify(pub, mbytes, sig) {
+		return errors.New("The plugin's signature is invalid")
+	}
+
+	for _, k := range trusted {
+		tk, err := assets.ParsePublicKey(k)
+		if err == nil && tk.Equal(pub) {
+			return nil
+		}
+	}
+	if requireSignature {
+		return fmt.Errorf("The plugin is signed by an untrusted publisher (%s)", s.PublicKey)
+	}
+	logrus.Warnf("Plugin %s is signed by an untrusted publisher (%s)", pz.folder, s.PublicKey)
+	return nil
+}
+
+// GenerateSigningKey creates a new key for signing plugins. It returns the base64-encoded public key,
+// which is added to trusted_publishers, and the base64-encoded private key, which must be kept secret.
+func GenerateSigningKey() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// ParsePrivateKey decodes a base64-encoded private key created by GenerateSigningKey
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, errors.New("Invalid ed25519 private key")
+}
+
+// SignPlugin writes a copy of the plugin zip file to outFile, including a manifest of all the plugin's files,
+// signed with the given private key. Any existing manifest and signature are replaced.
+// The output file must be different from the input file.
+func SignPlugin(zipFile string, outFile string, key ed25519.PrivateKey) error {
+	pz, err := openPluginZip(zipFile)
+	if err != nil {
+		return err
+	}
+	defer pz.Close()
+
+	m := Manifest{Files: make(map[string]string)}
+	for fname, f := range pz.files {
+		if m.Files[fname], err = hashZipFile(f); err != nil {
+			return err
+		}
+	}
+	mbytes, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return err
+	}
+	sbytes, err := json.MarshalIndent(&Signature{
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, mbytes)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, f := range pz.r.File {
+		if f == pz.manifest || f == pz.signature {
+			continue
+		}
+		if err = zw.Copy(f); err != nil {
+			return err
+		}
+	}
+	w, err := zw.Create(path.Join(pz.folder, ManifestFile))
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(mbytes); err != nil {
+		return err
+	}
+	if w, err = zw.Create(path.Join(pz.folder, SignatureFile)); err != nil {
+		return err
+	}
+	if _, err = w.Write(sbytes); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/backend/assets"
+)
+
+func writeZip(t *testing.T, filename string, files map[string]string) {
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
+
+func readZip(t *testing.T, filename string) map[string]string {
+	r, err := zip.OpenReader(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	files := make(map[string]string)
+	for _, f := range r.File {
+		b, err := readZipFile(f)
+		require.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestSignPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-sign-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	unsigned := path.Join(dir, "unsigned.zip")
+	signed := path.Join(dir, "signed.zip")
+	writeZip(t, unsigned, map[string]string{
+		"myplugin/heedy.conf":   `plugin "myplugin" {}`,
+		"myplugin/main.py":      "print('hi')",
+		"myplugin/data/file.js": "hello",
+	})
+
+	pub, priv, err := GenerateSigningKey()
+	require.NoError(t, err)
+	key, err := ParsePrivateKey(priv)
+	require.NoError(t, err)
+	require.NoError(t, SignPlugin(unsigned, signed, key))
+
+	trusted := &assets.Configuration{TrustedPublishers: &[]string{pub}}
+	required := true
+	requiring := &assets.Configuration{TrustedPublishers: &[]string{pub}, RequirePluginSignatures: &required}
+	untrusted := &assets.Configuration{RequirePluginSignatures: &required}
+
+	require.NoError(t, VerifyPlugin(unsigned, trusted))
+	require.Error(t, VerifyPlugin(unsigned, requiring))
+	require.NoError(t, VerifyPlugin(signed, trusted))
+	require.NoError(t, VerifyPlugin(signed, requiring))
+	require.Error(t, VerifyPlugin(signed, untrusted))
+
+	// Modifying, adding or removing a file invalidates the package
+	files := readZip(t, signed)
+	tampered := path.Join(dir, "tampered.zip")
+	files["myplugin/main.py"] = "print('evil')"
+	writeZip(t, tampered, files)
+	require.Error(t, VerifyPlugin(tampered, trusted))
+
+	files = readZip(t, signed)
+	files["myplugin/extra.py"] = "print('evil')"
+	writeZip(t, tampered, files)
+	require.Error(t, VerifyPlugin(tampered, trusted))
+
+	files = readZip(t, signed)
+	delete(files, "myplugin/data/file.js")
+	writeZip(t, tampered, files)
+	require.Error(t, VerifyPlugin(tampered, trusted))
+
+	// Changing the manifest invalidates the signature
+	files = readZip(t, signed)
+	files["myplugin/"+ManifestFile] = `{"files": {}}`
+	delete(files, "myplugin/main.py")
+	delete(files, "myplugin/heedy.conf")
+	delete(files, "myplugin/data/file.js")
+	writeZip(t, tampered, files)
+	require.Error(t, VerifyPlugin(tampered, trusted))
+}
+
+func TestUpdatePluginSignature(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "heedy-updater-")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(configDir, "heedy.conf"), []byte("require_plugin_signatures = true"), 0664))
+
+	zipFile := path.Join(configDir, "myplugin.zip")
+	writeZip(t, zipFile, map[string]string{
+		"myplugin/heedy.conf": `plugin "myplugin" {}`,
+	})
+
+	// Unsigned plugins are rejected before anything is extracted
+	require.Error(t, UpdatePlugin(configDir, zipFile))
+	_, err = os.Stat(path.Join(configDir, "updates", "plugins", "myplugin"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/heedy/heedy/backend/events"
 	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/mitchellh/mapstructure"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -23,6 +24,7 @@ type DashboardSettings struct {
 		QuerySchema    map[string]interface{} `mapstructure:"query_schema"`
 		FrontendSchema map[string]interface{} `mapstructure:"frontend_schema"`
 	} `mapstructure:"types"`
+	Templates map[string]*Template `mapstructure:"templates"`
 }
 
 type DashboardType struct {
@@ -63,16 +65,26 @@ type DashboardProcessor struct {
 
 	Types map[string]*DashboardType
 
+	// The templates from which users can create dashboards
+	Templates map[string]*Template
+
 	// The actively waiting dashboards are set here
 	sync.Mutex
 	active map[string][]chan []byte
+
+	// The cron entries of elements that are refreshed periodically, by object_id/element_id
+	schedules map[string]cron.EntryID
 }
 
 // Dashboard is a global variable that is initialized with NewDashboardProcessor when the plugin is set up
 var Dashboard *DashboardProcessor
 
+// HandlerGetter gives the dashboard access to the handlers of dashboard types, and to the run manager's cron,
+// which is used to refresh elements periodically
 type HandlerGetter interface {
 	GetHandler(uri string) (http.Handler, error)
+	AddCron(spec string, job func()) (cron.EntryID, error)
+	RemoveCron(id cron.EntryID)
 }
 
 func NewDashboardProcessor(db *database.AdminDB, p *assets.Plugin, h HandlerGetter) (*DashboardProcessor, error) {
@@ -103,11 +115,23 @@ func NewDashboardProcessor(db *database.AdminDB, p *assets.Plugin, h HandlerGett
 		dTypes[t] = &dt
 	}
 
+	// Templates can come from other plugins, so an invalid template is skipped rather than stopping the dashboard
+	templates := make(map[string]*Template)
+	for name, t := range ds.Templates {
+		if err = t.Validate(dTypes); err != nil {
+			logrus.Warnf("Skipping dashboard template '%s': %v", name, err)
+			continue
+		}
+		templates[name] = t
+	}
+
 	return &DashboardProcessor{
-		ADB:    db,
-		Types:  dTypes,
-		active: make(map[string][]chan []byte),
-		h:      h,
+		ADB:       db,
+		Types:     dTypes,
+		Templates: templates,
+		active:    make(map[string][]chan []byte),
+		schedules: make(map[string]cron.EntryID),
+		h:         h,
 	}, nil
 }
 
@@ -278,6 +302,20 @@ func (dp *DashboardProcessor) UpdateElement(as string, de *DashboardElement) (ch
 	return c, nil
 }
 
+// elementInfo holds the details of an element and its dashboard object needed to recompute it
+type elementInfo struct {
+	ElementID string              `db:"element_id"`
+	Type      string              `db:"type"`
+	Query     []byte              `db:"query"`
+	Owner     string              `db:"owner"`
+	ObjectID  string              `db:"objectID"`
+	OnDemand  bool                `db:"on_demand"`
+	App       string              `db:"app"`
+	Plugin    *string             `json:"plugin,omitempty" db:"plugin"`
+	Key       *string             `json:"key,omitempty" db:"key"`
+	Tags      *dbutil.StringArray `json:"tags,omitempty" db:"tags"`
+}
+
 // Fire handles events
 func (dp *DashboardProcessor) Fire(e *events.Event) {
 	// So... Let's check if a dashboard is listening to this event
@@ -286,18 +324,7 @@ func (dp *DashboardProcessor) Fire(e *events.Event) {
 	}
 
 	// Otherwise, get the API calls for matching events
-	var s []struct {
-		ElementID string              `db:"element_id"`
-		Type      string              `db:"type"`
-		Query     []byte              `db:"query"`
-		Owner     string              `db:"owner"`
-		ObjectID  string              `db:"objectID"`
-		OnDemand  bool                `db:"on_demand"`
-		App       string              `db:"app"`
-		Plugin    *string             `json:"plugin,omitempty" db:"plugin"`
-		Key       *string             `json:"key,omitempty" db:"key"`
-		Tags      *dbutil.StringArray `json:"tags,omitempty" db:"tags"`
-	}
+	var s []elementInfo
 
 	err := dp.ADB.Select(&s, `SELECT dashboard_elements.element_id,dashboard_elements.type,dashboard_elements.query,dashboard_elements.on_demand,objects.owner,objects.id AS objectID,COALESCE(objects.app,'') AS app,apps.plugin,objects.tags,objects.key FROM dashboard_events
 							JOIN dashboard_elements ON (dashboard_elements.element_id=dashboard_events.element_id AND dashboard_elements.object_id=dashboard_events.object_id)
@@ -310,12 +337,20 @@ func (dp *DashboardProcessor) Fire(e *events.Event) {
 		return
 	}
 
+	dp.outdate(s)
+}
+
+// outdate marks the elements as outdated, and dispatches the queries of elements that are not computed on demand.
+// The returned WaitGroup is done once all of the queries finished.
+func (dp *DashboardProcessor) outdate(s []elementInfo) *sync.WaitGroup {
+	var wg sync.WaitGroup
+
 	// Mark them all as outdated
 	if len(s) > 0 {
 		tx, err := dp.ADB.Beginx()
 		if err != nil {
-			logrus.Errorf("Failed to start dashboard transaction (%s,%s): %v", e.Event, e.Object, err)
-			return
+			logrus.Errorf("Failed to start dashboard transaction: %v", err)
+			return &wg
 		}
 		for ev := range s {
 			res, err := tx.Exec(`UPDATE dashboard_elements SET outdated=TRUE WHERE element_id=? AND object_id=?;`, s[ev].ElementID, s[ev].ObjectID)
@@ -323,14 +358,14 @@ func (dp *DashboardProcessor) Fire(e *events.Event) {
 			if err != nil {
 				logrus.Errorf("Failed to set dashboard element outdated %s/%s/%s, %v", s[ev].Owner, s[ev].ObjectID, s[ev].ElementID, err)
 				tx.Rollback()
-				return
+				return &wg
 			}
 		}
 		err = tx.Commit()
 		if err != nil {
 			tx.Rollback()
-			logrus.Errorf("Failed to commit outdated dashboard elements for (%s,%s): %v", e.Event, e.Object, err)
-			return
+			logrus.Errorf("Failed to commit outdated dashboard elements: %v", err)
+			return &wg
 		}
 
 		// For each of the dashboard elements,
@@ -342,8 +377,10 @@ func (dp *DashboardProcessor) Fire(e *events.Event) {
 				if sv.App != "" {
 					as += "/" + sv.App
 				}
+				wg.Add(1)
 				go func() {
-					Dashboard.Query(as, sv.ObjectID, sv.ElementID, sv.Type, sv.Query)
+					defer wg.Done()
+					dp.Query(as, sv.ObjectID, sv.ElementID, sv.Type, sv.Query)
 					events.Fire(&events.Event{
 						Event:  "dashboard_element_update",
 						User:   sv.Owner,
@@ -380,4 +417,5 @@ func (dp *DashboardProcessor) Fire(e *events.Event) {
 		}
 	}
 
+	return &wg
 }
@@ -1,16 +1,20 @@
 package dashboard
 
 import (
+	"encoding/json"
 	"net/http"
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/heedy/heedy/api/golang/rest"
 	"github.com/heedy/heedy/backend/assets"
 	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/database/dbutil"
 	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/jmoiron/sqlx/types"
+	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,6 +42,14 @@ func (t testHandlerGetter) GetHandler(uri string) (http.Handler, error) {
 	return t.m.GetHandler("dashboard", uri)
 }
 
+func (t testHandlerGetter) AddCron(spec string, job func()) (cron.EntryID, error) {
+	return t.m.AddCron(spec, job)
+}
+
+func (t testHandlerGetter) RemoveCron(id cron.EntryID) {
+	t.m.RemoveCron(id)
+}
+
 func newAssets(t *testing.T) (*assets.Assets, func()) {
 	a, err := assets.Open("", nil)
 	require.NoError(t, err)
@@ -204,3 +216,239 @@ func TestCRUD(t *testing.T) {
 	require.Len(t, da, 0)
 
 }
+
+func TestRefresh(t *testing.T) {
+	adb, oid1, _, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	for _, r := range []string{"15m", "@every 1h", "0 * * * *", "@daily"} {
+		_, err := RefreshSpec(r)
+		require.NoError(t, err, r)
+	}
+	for _, r := range []string{"10s", "@every 1s", "not a schedule"} {
+		_, err := RefreshSpec(r)
+		require.Error(t, err, r)
+	}
+
+	zeroObject := types.JSONText("0")
+	bad := "5s"
+	require.Error(t, WriteDashboard(adb, "test", oid1, []DashboardElement{
+		{Type: "test", Query: &zeroObject, Refresh: &bad},
+	}))
+
+	refresh := "1h"
+	onDemand := false
+	require.NoError(t, WriteDashboard(adb, "test", oid1, []DashboardElement{
+		{Type: "test", Query: &zeroObject, Refresh: &refresh, OnDemand: &onDemand},
+		{Type: "test", Query: &zeroObject},
+	}))
+	da, err := ReadDashboard(adb, "test", oid1, true)
+	require.NoError(t, err)
+	require.Len(t, da, 2)
+	require.Equal(t, "1h", *da[0].Refresh)
+	require.Nil(t, da[1].Refresh)
+	require.Len(t, Dashboard.schedules, 1)
+	eid := da[0].ID
+
+	// Refreshing recomputes elements that are not on demand right away
+	readData := func() string {
+		el, err := ReadDashboardElement(adb, "test", oid1, eid, true)
+		require.NoError(t, err)
+		if el.Data == nil {
+			return ""
+		}
+		b, err := el.Data.MarshalJSON()
+		require.NoError(t, err)
+		return string(b)
+	}
+	// The element's first query runs in the background
+	require.Eventually(t, func() bool { return readData() != "" }, time.Second, 10*time.Millisecond)
+	before := readData()
+	Dashboard.Refresh(oid1, eid)
+	var outdated bool
+	require.NoError(t, adb.Get(&outdated, "SELECT outdated FROM dashboard_elements WHERE element_id=?", eid))
+	require.False(t, outdated)
+	require.NotEqual(t, before, readData())
+
+	// Schedules are restored on start, and removed with an empty refresh or when the element is deleted
+	Dashboard.Unschedule()
+	require.Len(t, Dashboard.schedules, 0)
+	require.NoError(t, Dashboard.ScheduleAll())
+	require.Len(t, Dashboard.schedules, 1)
+
+	empty := ""
+	require.NoError(t, WriteDashboard(adb, "test", oid1, []DashboardElement{{ID: eid, Refresh: &empty}}))
+	require.Len(t, Dashboard.schedules, 0)
+	el, err := ReadDashboardElement(adb, "test", oid1, eid, true)
+	require.NoError(t, err)
+	require.Nil(t, el.Refresh)
+
+	require.NoError(t, WriteDashboard(adb, "test", oid1, []DashboardElement{{ID: eid, Refresh: &refresh}}))
+	require.Len(t, Dashboard.schedules, 1)
+	require.NoError(t, DeleteDashboardElement(adb, oid1, eid))
+	require.Len(t, Dashboard.schedules, 0)
+
+	// A scheduled refresh of an element that no longer exists removes its schedule
+	require.NoError(t, Dashboard.Schedule(oid1, eid, &refresh))
+	Dashboard.Refresh(oid1, eid)
+	require.Len(t, Dashboard.schedules, 0)
+}
+
+func TestTemplates(t *testing.T) {
+	adb, _, _, cleanup := newDBWithObjects(t)
+	defer cleanup()
+	db := database.NewUserDB(adb, "test")
+
+	// Templates with unknown element types are not available
+	require.Contains(t, Dashboard.Templates, "steps")
+	require.NotContains(t, Dashboard.Templates, "broken")
+
+	_, err := Dashboard.CreateFromTemplate(db, "notatemplate", &TemplateOptions{})
+	require.Error(t, err)
+
+	// The user doesn't have a steps object yet
+	_, err = Dashboard.CreateFromTemplate(db, "steps", &TemplateOptions{})
+	require.Error(t, err)
+
+	oname := "steps"
+	otype := "dashboard"
+	uname := "test"
+	var tags dbutil.StringArray
+	tags.Load("health steps")
+	stepsid, err := adb.CreateObject(&database.Object{
+		Details: database.Details{Name: &oname},
+		Type:    &otype,
+		Owner:   &uname,
+		Tags:    &tags,
+	})
+	require.NoError(t, err)
+
+	// The sleep placeholder is optional, so its element is left out
+	oid, err := Dashboard.CreateFromTemplate(db, "steps", &TemplateOptions{})
+	require.NoError(t, err)
+	o, err := db.ReadObject(oid, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Steps", *o.Name)
+	require.Equal(t, "test", *o.Owner)
+	da, err := ReadDashboard(adb, "test", oid, true)
+	require.NoError(t, err)
+	require.Len(t, da, 1)
+	require.JSONEq(t, `{"object":"`+stepsid+`"}`, string(*da[0].Settings))
+
+	oname = "sleep"
+	tags.Load("sleep")
+	sleepid, err := adb.CreateObject(&database.Object{
+		Details: database.Details{Name: &oname},
+		Type:    &otype,
+		Owner:   &uname,
+		Tags:    &tags,
+	})
+	require.NoError(t, err)
+	oid, err = Dashboard.CreateFromTemplate(db, "steps", &TemplateOptions{Name: "My Steps"})
+	require.NoError(t, err)
+	o, err = db.ReadObject(oid, nil)
+	require.NoError(t, err)
+	require.Equal(t, "My Steps", *o.Name)
+	da, err = ReadDashboard(adb, "test", oid, true)
+	require.NoError(t, err)
+	require.Len(t, da, 2)
+	require.JSONEq(t, `{"objects":["`+stepsid+`","`+sleepid+`"]}`, string(*da[1].Settings))
+
+	// Users can't create dashboards for others
+	name := "other"
+	passwd := "test"
+	require.NoError(t, adb.CreateUser(&database.User{
+		UserName: &name,
+		Password: &passwd,
+	}))
+	_, err = Dashboard.CreateFromTemplate(database.NewUserDB(adb, "other"), "steps", &TemplateOptions{Owner: "test"})
+	require.Error(t, err)
+}
+
+func TestShares(t *testing.T) {
+	adb, oid1, oid2, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	zeroObject := types.JSONText("0")
+	title := "Steps"
+	onDemand := false
+	require.NoError(t, WriteDashboard(adb, "test", oid1, []DashboardElement{
+		{Type: "test", Title: &title, Query: &zeroObject, OnDemand: &onDemand},
+	}))
+	// The element's first query runs in the background
+	require.Eventually(t, func() bool {
+		da, err := ReadDashboard(adb, "test", oid1, true)
+		require.NoError(t, err)
+		return da[0].Data != nil && string(*da[0].Data) != "null"
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := ReadPublicDashboard(adb, "notatoken")
+	require.Equal(t, ErrShareNotFound, err)
+
+	past := unixNow() - 10
+	_, err = CreateShare(adb, oid1, &past)
+	require.Error(t, err)
+
+	s, err := CreateShare(adb, oid1, nil)
+	require.NoError(t, err)
+	require.Nil(t, s.ExpiresAt)
+	require.Len(t, s.Token, 43)
+	future := unixNow() + 3600
+	s2, err := CreateShare(adb, oid1, &future)
+	require.NoError(t, err)
+	require.NotEqual(t, s.Token, s2.Token)
+
+	shares, err := ListShares(adb, oid1)
+	require.NoError(t, err)
+	require.Len(t, shares, 2)
+	shares, err = ListShares(adb, oid2)
+	require.NoError(t, err)
+	require.Len(t, shares, 0)
+
+	// Visitors get the cached data, but not the query
+	d, err := ReadPublicDashboard(adb, s.Token)
+	require.NoError(t, err)
+	require.Equal(t, "myobject", d.Name)
+	require.Len(t, d.Elements, 1)
+	require.Equal(t, "Steps", d.Elements[0].Title)
+	require.NotNil(t, d.Elements[0].Data)
+	b, err := json.Marshal(d)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "query")
+
+	// Outdated elements that are computed on demand are computed for visitors
+	onDemand = true
+	require.NoError(t, WriteDashboard(adb, "test", oid1, []DashboardElement{
+		{Type: "test", Title: &title, Query: &zeroObject, OnDemand: &onDemand},
+	}))
+	d, err = ReadPublicDashboard(adb, s.Token)
+	require.NoError(t, err)
+	require.Len(t, d.Elements, 2)
+	require.NotNil(t, d.Elements[1].Data)
+	require.NotEqual(t, "null", string(*d.Elements[1].Data))
+	var outdated bool
+	require.NoError(t, adb.Get(&outdated, "SELECT outdated FROM dashboard_elements WHERE element_id=?", d.Elements[1].ID))
+	require.False(t, outdated)
+
+	// Expired links stop working
+	_, err = adb.Exec("UPDATE dashboard_shares SET expires_at=? WHERE token=?", unixNow()-1, s2.Token)
+	require.NoError(t, err)
+	_, err = ReadPublicDashboard(adb, s2.Token)
+	require.Equal(t, ErrShareNotFound, err)
+	shares, err = ListShares(adb, oid1)
+	require.NoError(t, err)
+	require.Len(t, shares, 1)
+
+	// Links can only be revoked through their own dashboard
+	require.Error(t, DeleteShare(adb, oid2, s.Token))
+	require.NoError(t, DeleteShare(adb, oid1, s.Token))
+	_, err = ReadPublicDashboard(adb, s.Token)
+	require.Equal(t, ErrShareNotFound, err)
+
+	// Deleting the dashboard removes its links
+	s, err = CreateShare(adb, oid1, nil)
+	require.NoError(t, err)
+	require.NoError(t, adb.DelObject(oid1))
+	_, err = ReadPublicDashboard(adb, s.Token)
+	require.Equal(t, ErrShareNotFound, err)
+}
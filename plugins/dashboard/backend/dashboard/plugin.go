@@ -32,6 +32,15 @@ func StartDashboard(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) erro
 	// Set up the event handler
 	events.AddHandler(Dashboard)
 
+	// Elements with a refresh are recomputed periodically
+	return Dashboard.ScheduleAll()
+}
+
+func StopDashboard(db *database.AdminDB, apikey string) error {
+	if Dashboard != nil {
+		events.RemoveHandler(Dashboard)
+		Dashboard.Unschedule()
+	}
 	return nil
 }
 
@@ -41,6 +50,7 @@ func init() {
 	run.Builtin.Add(&run.BuiltinRunner{
 		Key:     PluginName,
 		Start:   StartDashboard,
+		Stop:    StopDashboard,
 		Handler: Handler,
 	})
 	// Runs schema creation on database create instead of on first start
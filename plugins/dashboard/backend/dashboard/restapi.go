@@ -108,16 +108,128 @@ func WriteElementHandler(w http.ResponseWriter, r *http.Request) {
 	rest.WriteResult(w, r, err)
 }
 
+// validateShareRequest checks that the caller can manage the dashboard's share links, which, like sharing
+// the object with other users, is only permitted for the object's owner
+func validateShareRequest(w http.ResponseWriter, r *http.Request) (*plugin.ObjectInfo, bool) {
+	oi, ok := validateRequest(w, r, "read")
+	if !ok {
+		return nil, false
+	}
+	c := rest.CTX(r)
+	if c.DB.Type() != database.AdminType && (c.DB.Type() != database.UserType || c.DB.ID() != oi.Owner) {
+		rest.WriteJSONError(w, r, http.StatusForbidden, database.ErrAccessDenied("Only the dashboard's owner can manage its links"))
+		return nil, false
+	}
+	return oi, true
+}
+
+func ListSharesHandler(w http.ResponseWriter, r *http.Request) {
+	oi, ok := validateShareRequest(w, r)
+	if !ok {
+		return
+	}
+	c := rest.CTX(r)
+	shares, err := ListShares(c.DB.AdminDB(), oi.ID)
+	rest.WriteJSON(w, r, shares, err)
+}
+
+func CreateShareHandler(w http.ResponseWriter, r *http.Request) {
+	oi, ok := validateShareRequest(w, r)
+	if !ok {
+		return
+	}
+	c := rest.CTX(r)
+	var o struct {
+		ExpiresAt *float64 `json:"expires_at"`
+	}
+	if err := rest.UnmarshalRequest(r, &o); err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	s, err := CreateShare(c.DB.AdminDB(), oi.ID, o.ExpiresAt)
+	rest.WriteJSON(w, r, s, err)
+}
+
+func DeleteShareHandler(w http.ResponseWriter, r *http.Request) {
+	oi, ok := validateShareRequest(w, r)
+	if !ok {
+		return
+	}
+	c := rest.CTX(r)
+	token, err := rest.URLParam(r, "token", nil)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	rest.WriteResult(w, r, DeleteShare(c.DB.AdminDB(), oi.ID, token))
+}
+
+// PublicHandler returns the dashboard behind a share link. The token is the only credential needed,
+// so it works for visitors that are not logged in.
+func PublicHandler(w http.ResponseWriter, r *http.Request) {
+	c := rest.CTX(r)
+	token, err := rest.URLParam(r, "token", nil)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	d, err := ReadPublicDashboard(c.DB.AdminDB(), token)
+	if err == ErrShareNotFound {
+		rest.WriteJSONError(w, r, http.StatusNotFound, err)
+		return
+	}
+	rest.WriteGzipJSON(w, r, d, err)
+}
+
+// ListTemplatesHandler returns the templates that dashboards can be created from
+func ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if Dashboard == nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, errors.New("The dashboard plugin is not running"))
+		return
+	}
+	rest.WriteJSON(w, r, Dashboard.Templates, nil)
+}
+
+// CreateFromTemplateHandler creates a new dashboard object from a template, and returns the object
+func CreateFromTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if Dashboard == nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, errors.New("The dashboard plugin is not running"))
+		return
+	}
+	c := rest.CTX(r)
+	var o TemplateOptions
+	err := rest.UnmarshalRequest(r, &o)
+	template, err := rest.URLParam(r, "template", err)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	oid, err := Dashboard.CreateFromTemplate(c.DB, template, &o)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	obj, err := c.DB.ReadObject(oid, nil)
+	rest.WriteJSON(w, r, obj, err)
+}
+
 // Handler is the global router for the timeseries API
 var Handler = func() *chi.Mux {
 	m := chi.NewMux()
 
 	m.Get("/object/dashboard", ReadHandler)
 	m.Post("/object/dashboard", WriteHandler)
+	m.Get("/object/dashboard/shares", ListSharesHandler)
+	m.Post("/object/dashboard/shares", CreateShareHandler)
+	m.Delete("/object/dashboard/shares/{token}", DeleteShareHandler)
 	m.Get("/object/dashboard/{element_id}", ReadElementHandler)
 	m.Patch("/object/dashboard/{element_id}", WriteElementHandler)
 	m.Delete("/object/dashboard/{element_id}", DeleteElementHandler)
 
+	m.Get("/api/dashboard/templates", ListTemplatesHandler)
+	m.Post("/api/dashboard/templates/{template}", CreateFromTemplateHandler)
+	m.Get("/api/dashboard/public/{token}", PublicHandler)
+
 	m.NotFound(rest.NotFoundHandler)
 	m.MethodNotAllowed(rest.NotFoundHandler)
 
@@ -0,0 +1,107 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// minRefresh is the shortest interval at which dashboard elements can be refreshed
+var minRefresh = time.Minute
+
+// RefreshSpec returns the cron spec for an element's refresh, which is either an interval such as "15m",
+// or a cron expression such as "0 * * * *" or "@daily"
+func RefreshSpec(refresh string) (string, error) {
+	refresh = strings.TrimSpace(refresh)
+	interval := strings.TrimPrefix(refresh, "@every ")
+	if d, err := time.ParseDuration(interval); err == nil {
+		if d < minRefresh {
+			return "", fmt.Errorf("The refresh interval must be at least %s", minRefresh)
+		}
+		return "@every " + d.String(), nil
+	}
+	if _, err := cron.ParseStandard(refresh); err != nil {
+		return "", fmt.Errorf("Invalid refresh '%s': it must be an interval such as 15m, or a cron expression", refresh)
+	}
+	return refresh, nil
+}
+
+// Schedule sets up the refresh of the given element, replacing its previous schedule. A nil refresh removes
+// the element's schedule.
+func (dp *DashboardProcessor) Schedule(oid, eid string, refresh *string) error {
+	key := oid + "/" + eid
+	dp.Lock()
+	defer dp.Unlock()
+	if id, ok := dp.schedules[key]; ok {
+		dp.h.RemoveCron(id)
+		delete(dp.schedules, key)
+	}
+	if refresh == nil {
+		return nil
+	}
+	spec, err := RefreshSpec(*refresh)
+	if err != nil {
+		return err
+	}
+	id, err := dp.h.AddCron(spec, func() {
+		dp.Refresh(oid, eid)
+	})
+	if err != nil {
+		return err
+	}
+	dp.schedules[key] = id
+	return nil
+}
+
+// ScheduleAll sets up the refresh of all elements that have one, and is run when the plugin starts
+func (dp *DashboardProcessor) ScheduleAll() error {
+	var elements []struct {
+		ObjectID  string `db:"object_id"`
+		ElementID string `db:"element_id"`
+		Refresh   string `db:"refresh"`
+	}
+	err := dp.ADB.Select(&elements, `SELECT object_id,element_id,refresh FROM dashboard_elements WHERE refresh IS NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	for i := range elements {
+		if err = dp.Schedule(elements[i].ObjectID, elements[i].ElementID, &elements[i].Refresh); err != nil {
+			logrus.Warnf("Failed to schedule refresh of dashboard element %s/%s: %v", elements[i].ObjectID, elements[i].ElementID, err)
+		}
+	}
+	return nil
+}
+
+// Unschedule removes the refresh schedules of all elements
+func (dp *DashboardProcessor) Unschedule() {
+	dp.Lock()
+	defer dp.Unlock()
+	for key, id := range dp.schedules {
+		dp.h.RemoveCron(id)
+		delete(dp.schedules, key)
+	}
+}
+
+// Refresh marks the element as outdated, and recomputes it if it is not computed on demand.
+// It waits until the element is recomputed, so that a slow element is not refreshed again in the meantime.
+func (dp *DashboardProcessor) Refresh(oid, eid string) {
+	var s []elementInfo
+	err := dp.ADB.Select(&s, `SELECT dashboard_elements.element_id,dashboard_elements.type,dashboard_elements.query,dashboard_elements.on_demand,objects.owner,objects.id AS objectID,COALESCE(objects.app,'') AS app,apps.plugin,objects.tags,objects.key FROM dashboard_elements
+							JOIN objects ON (dashboard_elements.object_id=objects.id)
+							LEFT JOIN apps ON (objects.app=apps.id)
+							WHERE dashboard_elements.object_id=? AND dashboard_elements.element_id=?;`, oid, eid)
+	if err != nil {
+		logrus.Errorf("Failed to read dashboard element %s/%s for refresh: %v", oid, eid, err)
+		return
+	}
+	if len(s) == 0 {
+		// The element (or its dashboard) was deleted
+		dp.Schedule(oid, eid, nil)
+		return
+	}
+	logrus.Debugf("Refreshing dashboard element %s/%s", oid, eid)
+	dp.outdate(s).Wait()
+}
@@ -0,0 +1,132 @@
+package dashboard
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// ErrShareNotFound is returned for share links that don't exist, were revoked, or have expired
+var ErrShareNotFound = errors.New("not_found: The dashboard link does not exist or has expired")
+
+// DashboardShare is a link that gives anyone with its token read access to the cached data of a dashboard
+type DashboardShare struct {
+	Token       string   `json:"token" db:"token"`
+	ObjectID    string   `json:"object_id" db:"object_id"`
+	CreatedDate string   `json:"created_date" db:"created_date"`
+	ExpiresAt   *float64 `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// PublicElement is the part of a dashboard element that is visible through a share link. The element's query
+// is not included, so that visitors can't see or re-run queries on the underlying objects.
+type PublicElement struct {
+	ID       string          `json:"id" db:"element_id"`
+	Type     string          `json:"type" db:"type"`
+	Title    string          `json:"title" db:"title"`
+	Data     *CompressedJSON `json:"data,omitempty" db:"data"`
+	Settings *types.JSONText `json:"settings,omitempty" db:"settings"`
+}
+
+// PublicDashboard is the read-only view of a dashboard given to visitors of a share link
+type PublicDashboard struct {
+	Name        string          `json:"name" db:"name"`
+	Description string          `json:"description" db:"description"`
+	Icon        string          `json:"icon" db:"icon"`
+	Elements    []PublicElement `json:"elements" db:"-"`
+}
+
+func unixNow() float64 {
+	return float64(time.Now().UnixNano()) * 1e-9
+}
+
+// shareToken generates an unguessable token that can be used in a URL
+func shareToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b), err
+}
+
+// CreateShare creates a new share link for the dashboard, which stops working at expiresAt if it is given
+func CreateShare(adb *database.AdminDB, oid string, expiresAt *float64) (*DashboardShare, error) {
+	now := unixNow()
+	if expiresAt != nil && *expiresAt <= now {
+		return nil, errors.New("bad_request: The link's expiry must be in the future")
+	}
+	token, err := shareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired links are no longer useful, so they are cleaned up whenever a new link is created
+	_, err = adb.Exec(`DELETE FROM dashboard_shares WHERE object_id=? AND expires_at<=?;`, oid, now)
+	if err != nil {
+		return nil, err
+	}
+	_, err = adb.Exec(`INSERT INTO dashboard_shares(token,object_id,expires_at) VALUES (?,?,?);`, token, oid, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	var s DashboardShare
+	err = adb.Get(&s, `SELECT * FROM dashboard_shares WHERE token=?;`, token)
+	return &s, err
+}
+
+// ListShares returns the dashboard's share links that have not expired
+func ListShares(adb *database.AdminDB, oid string) ([]DashboardShare, error) {
+	shares := []DashboardShare{}
+	err := adb.Select(&shares, `SELECT * FROM dashboard_shares WHERE object_id=? AND (expires_at IS NULL OR expires_at>?) ORDER BY created_date ASC;`, oid, unixNow())
+	return shares, err
+}
+
+// DeleteShare revokes the given share link of the dashboard
+func DeleteShare(adb *database.AdminDB, oid string, token string) error {
+	res, err := adb.Exec(`DELETE FROM dashboard_shares WHERE object_id=? AND token=?;`, oid, token)
+	return database.GetExecError(res, err)
+}
+
+// ReadPublicDashboard returns the dashboard that the share link points to. Just like when the dashboard's owner
+// reads it, outdated elements that are computed on demand are first recomputed, with the owner's permissions.
+// Visitors only get the elements' data, so that they can't see or change the queries on the underlying objects.
+func ReadPublicDashboard(adb *database.AdminDB, token string) (*PublicDashboard, error) {
+	var oid string
+	err := adb.Get(&oid, `SELECT object_id FROM dashboard_shares WHERE token=? AND (expires_at IS NULL OR expires_at>?);`, token, unixNow())
+	if err == sql.ErrNoRows {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var o struct {
+		PublicDashboard
+		// The owner (or the owner's app) that the dashboard's queries run as
+		As string `db:"reader"`
+	}
+	err = adb.Get(&o, `SELECT name,description,icon,owner || COALESCE('/' || app,'') AS reader FROM objects WHERE id=?;`, oid)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := ReadDashboard(adb, o.As, oid, false)
+	if err != nil {
+		return nil, err
+	}
+	d := o.PublicDashboard
+	d.Elements = make([]PublicElement, 0, len(elements))
+	for _, el := range elements {
+		pe := PublicElement{
+			ID:       el.ID,
+			Type:     el.Type,
+			Data:     el.Data,
+			Settings: el.Settings,
+		}
+		if el.Title != nil {
+			pe.Title = *el.Title
+		}
+		d.Elements = append(d.Elements, pe)
+	}
+	return &d, nil
+}
@@ -12,9 +12,10 @@ import (
 	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/jmoiron/sqlx/types"
 	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
 )
 
-var SQLVersion = 1
+var SQLVersion = 3
 
 const sqlSchema = `
 
@@ -39,6 +40,8 @@ CREATE TABLE dashboard_elements (
 	data BLOB DEFAULT NULL,
 	-- Settings for displaying the data on the frontend
 	settings BLOB NOT NULL,
+	-- Interval or cron expression on which the element is refreshed
+	refresh VARCHAR DEFAULT NULL,
 
 	PRIMARY KEY (object_id,element_id),
 
@@ -75,6 +78,25 @@ CREATE TABLE dashboard_events (
 CREATE INDEX events_idx ON dashboard_events(event_object_id,event);
 `
 
+const sharesSchema = `
+CREATE TABLE dashboard_shares (
+	-- The unguessable token of the public link
+	token VARCHAR PRIMARY KEY NOT NULL,
+	object_id VARCHAR(36) NOT NULL,
+
+	created_date DATE NOT NULL DEFAULT CURRENT_DATE,
+	-- The link stops working after this time, if set
+	expires_at REAL DEFAULT NULL,
+
+	CONSTRAINT object_updater
+		FOREIGN KEY(object_id)
+		REFERENCES objects(id)
+		ON UPDATE CASCADE
+		ON DELETE CASCADE
+);
+CREATE INDEX shares_idx ON dashboard_shares(object_id);
+`
+
 // SQLUpdater is in the format expected by Heedy to update the database
 func SQLUpdater(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, curversion int) error {
 	if curversion == SQLVersion {
@@ -83,7 +105,17 @@ func SQLUpdater(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, curversi
 	if curversion >= SQLVersion {
 		return errors.New("Dashboard database version too new")
 	}
-	_, err := db.ExecUncached(sqlSchema)
+	if curversion == 0 {
+		_, err := db.ExecUncached(sqlSchema + sharesSchema)
+		return err
+	}
+	if curversion == 1 {
+		_, err := db.ExecUncached(`ALTER TABLE dashboard_elements ADD COLUMN refresh VARCHAR DEFAULT NULL;`)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.ExecUncached(sharesSchema)
 	return err
 }
 
@@ -140,6 +172,9 @@ type DashboardElement struct {
 	Type     string `json:"type,omitempty" db:"type"`
 	OnDemand *bool  `json:"on_demand,omitempty" db:"on_demand"`
 
+	// Refresh is an interval such as "15m", or a cron expression, on which the element is recomputed
+	Refresh *string `json:"refresh,omitempty" db:"refresh"`
+
 	Title    *string         `json:"title,omitempty"`
 	Query    *types.JSONText `json:"query,omitempty"`
 	Data     *CompressedJSON `json:"data,omitempty"`
@@ -186,6 +221,7 @@ func ReadDashboard(adb *database.AdminDB, as string, oid string, include_query b
 		for i := range elements {
 			elements[i].Query = nil
 			elements[i].OnDemand = nil
+			elements[i].Refresh = nil
 		}
 	}
 
@@ -206,6 +242,11 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 				return fmt.Errorf("Can't create dashboard element without a type")
 			}
 		}
+		if el.Refresh != nil && *el.Refresh != "" {
+			if _, err := RefreshSpec(*el.Refresh); err != nil {
+				return err
+			}
+		}
 
 	}
 
@@ -235,12 +276,14 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 	// Prepare an array of events to fire and dashboard queries to initiate
 	requery := make([]*DashboardElement, 0)
 	evts := make([]*events.Event, 0)
+	// The elements whose refresh schedule changed
+	reschedule := make([]DashboardElement, 0)
 
 	for _, el := range elements {
 		if el.ID != "" {
 			// If there is an ID, check if the element already exists
 			var de DashboardElement
-			err = adb.Get(&de, `SELECT element_id,element_index,type,on_demand,query,settings,title FROM dashboard_elements WHERE element_id=? AND object_id=?;`, el.ID, oid)
+			err = adb.Get(&de, `SELECT element_id,object_id,element_index,type,on_demand,query,settings,title,refresh FROM dashboard_elements WHERE element_id=? AND object_id=?;`, el.ID, oid)
 			if err == nil {
 				// The element exists
 				if el.Type == "" {
@@ -282,6 +325,14 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 				if el.Title != nil {
 					de.Title = el.Title
 				}
+				if el.Refresh != nil {
+					// An empty refresh removes the element's schedule
+					de.Refresh = el.Refresh
+					if *el.Refresh == "" {
+						de.Refresh = nil
+					}
+					reschedule = append(reschedule, de)
+				}
 				if el.Index != nil {
 					// We are setting the index of a dashboard element, so make sure that the indices of all elements
 					// are shifted correctly
@@ -314,9 +365,10 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 							settings=?,
 							query=?,
 							on_demand=?,
+							refresh=?,
 							element_index=?,outdated=?
 						WHERE element_id=? AND object_id=?;`,
-					de.Title, de.Type, de.Settings, de.Query, de.OnDemand, de.Index, de.Outdated, el.ID, oid)
+					de.Title, de.Type, de.Settings, de.Query, de.OnDemand, de.Refresh, de.Index, de.Outdated, el.ID, oid)
 				err = database.GetExecError(res, err)
 				if err != nil {
 					tx.Rollback()
@@ -377,8 +429,17 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 			defaultOd := true
 			el.OnDemand = &defaultOd
 		}
+		el.ObjectID = oid
 		if !*el.OnDemand {
-			requery = append(requery, &el)
+			// el is reused by the loop, so the requery gets its own copy
+			rq := el
+			requery = append(requery, &rq)
+		}
+		if el.Refresh != nil && *el.Refresh == "" {
+			el.Refresh = nil
+		}
+		if el.Refresh != nil {
+			reschedule = append(reschedule, el)
 		}
 
 		// If there is an index, and we are inserting somewhere inside the array, we need to shift indices
@@ -396,8 +457,8 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 			}
 		}
 
-		res, err := tx.Exec(`INSERT INTO dashboard_elements(title,type,settings,query,on_demand,element_index,data,outdated,object_id,element_id) VALUES (?,?,?,?,?,?,NULL,TRUE,?,?);`,
-			el.Title, el.Type, el.Settings, el.Query, el.OnDemand, el.Index, oid, el.ID)
+		res, err := tx.Exec(`INSERT INTO dashboard_elements(title,type,settings,query,on_demand,refresh,element_index,data,outdated,object_id,element_id) VALUES (?,?,?,?,?,?,?,NULL,TRUE,?,?);`,
+			el.Title, el.Type, el.Settings, el.Query, el.OnDemand, el.Refresh, el.Index, oid, el.ID)
 		err = database.GetExecError(res, err)
 		if err != nil {
 			tx.Rollback()
@@ -421,6 +482,12 @@ func WriteDashboard(adb *database.AdminDB, as string, oid string, elements []Das
 	if err != nil {
 		return err
 	}
+	for _, e := range reschedule {
+		if err = Dashboard.Schedule(oid, e.ID, e.Refresh); err != nil {
+			// The refresh was already validated, so this shouldn't happen
+			logrus.Errorf("Failed to schedule refresh of dashboard element %s/%s: %v", oid, e.ID, err)
+		}
+	}
 	for i := range requery {
 		e := requery[i]
 		// Dispatch requery requests for all of the objects that are being changed which are not ondemand
@@ -474,6 +541,7 @@ func ReadDashboardElement(adb *database.AdminDB, as string, oid string, deid str
 	if !include_query {
 		de.Query = nil
 		de.OnDemand = nil
+		de.Refresh = nil
 	}
 
 	return &de, nil
@@ -512,6 +580,7 @@ func DeleteDashboardElement(adb *database.AdminDB, oid string, deid string) erro
 
 	err = tx.Commit()
 	if err == nil {
+		Dashboard.Schedule(oid, deid, nil)
 		events.Fire(evt)
 	}
 	return err
@@ -0,0 +1,251 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/sirupsen/logrus"
+)
+
+// TemplatePlaceholder finds the object that a template's placeholder refers to, among the objects
+// of the user that the template is instantiated for. All of the given fields must match.
+type TemplatePlaceholder struct {
+	Tags *string `mapstructure:"tags" json:"tags,omitempty"`
+	Key  *string `mapstructure:"key" json:"key,omitempty"`
+	Type *string `mapstructure:"type" json:"type,omitempty"`
+
+	// If the user has no matching object, the elements using an optional placeholder are left out.
+	// Otherwise, the template can't be instantiated.
+	Optional bool `mapstructure:"optional" json:"optional,omitempty"`
+}
+
+// TemplateElement is a dashboard element of a template. Strings in the query and settings can contain
+// placeholders in the form {{name}}, which are replaced by the ID of the placeholder's object.
+type TemplateElement struct {
+	Type     string      `mapstructure:"type" json:"type"`
+	Title    string      `mapstructure:"title" json:"title,omitempty"`
+	Query    interface{} `mapstructure:"query" json:"query"`
+	Settings interface{} `mapstructure:"settings" json:"settings,omitempty"`
+	OnDemand *bool       `mapstructure:"on_demand" json:"on_demand,omitempty"`
+	Refresh  *string     `mapstructure:"refresh" json:"refresh,omitempty"`
+}
+
+// Template is a dashboard that can be instantiated for any user. Templates are defined in the dashboard
+// plugin's templates config, which other plugins can add to from their own configuration.
+type Template struct {
+	Name         string                          `mapstructure:"name" json:"name"`
+	Description  string                          `mapstructure:"description" json:"description,omitempty"`
+	Icon         string                          `mapstructure:"icon" json:"icon,omitempty"`
+	Placeholders map[string]*TemplatePlaceholder `mapstructure:"placeholders" json:"placeholders,omitempty"`
+	Elements     []TemplateElement               `mapstructure:"elements" json:"elements"`
+}
+
+// Validate checks that the template's elements have known types, and only use defined placeholders
+func (t *Template) Validate(dTypes map[string]*DashboardType) error {
+	for i, el := range t.Elements {
+		if _, ok := dTypes[el.Type]; !ok {
+			return fmt.Errorf("element %d has unrecognized type '%s'", i, el.Type)
+		}
+		if el.Refresh != nil {
+			if _, err := RefreshSpec(*el.Refresh); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		for _, p := range el.placeholders() {
+			if _, ok := t.Placeholders[p]; !ok {
+				return fmt.Errorf("element %d uses undefined placeholder '%s'", i, p)
+			}
+		}
+	}
+	return nil
+}
+
+// placeholders returns the names of the placeholders used by the element
+func (el *TemplateElement) placeholders() []string {
+	names := make(map[string]bool)
+	find := func(s string) string {
+		for {
+			i := strings.Index(s, "{{")
+			if i < 0 {
+				return s
+			}
+			j := strings.Index(s[i:], "}}")
+			if j < 0 {
+				return s
+			}
+			names[strings.TrimSpace(s[i+2:i+j])] = true
+			s = s[i+j+2:]
+		}
+	}
+	replacePlaceholders(el.Query, find)
+	replacePlaceholders(el.Settings, find)
+	res := make([]string, 0, len(names))
+	for n := range names {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// replacePlaceholders returns a copy of the json value with all strings passed through replace
+func replacePlaceholders(v interface{}, replace func(string) string) interface{} {
+	switch vv := v.(type) {
+	case string:
+		return replace(vv)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[k] = replacePlaceholders(e, replace)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(vv))
+		for i, e := range vv {
+			a[i] = replacePlaceholders(e, replace)
+		}
+		return a
+	}
+	return v
+}
+
+// resolve returns the ID of each placeholder's object among the owner's objects. Optional placeholders without
+// an object are left out.
+func (t *Template) resolve(db database.DB, owner string) (map[string]string, error) {
+	ids := make(map[string]string)
+	limit := 1
+	for name, p := range t.Placeholders {
+		objs, err := db.ListObjects(&database.ListObjectsOptions{
+			Owner: &owner,
+			Tags:  p.Tags,
+			Key:   p.Key,
+			Type:  p.Type,
+			Limit: &limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(objs) == 0 {
+			if p.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("bad_request: %s has no object matching the template's '%s'", owner, name)
+		}
+		ids[name] = objs[0].ID
+	}
+	return ids, nil
+}
+
+// Instantiate returns the dashboard elements of the template with their placeholders replaced by the given object IDs
+func (t *Template) Instantiate(ids map[string]string) ([]DashboardElement, error) {
+	elements := make([]DashboardElement, 0, len(t.Elements))
+	for i := range t.Elements {
+		el := &t.Elements[i]
+		missing := false
+		for _, p := range el.placeholders() {
+			if _, ok := ids[p]; !ok {
+				missing = true
+			}
+		}
+		if missing {
+			continue
+		}
+		replace := func(s string) string {
+			for name, id := range ids {
+				s = strings.ReplaceAll(s, "{{"+name+"}}", id)
+			}
+			return s
+		}
+		q, err := json.Marshal(replacePlaceholders(el.Query, replace))
+		if err != nil {
+			return nil, err
+		}
+		settings := el.Settings
+		if settings == nil {
+			settings = map[string]interface{}{}
+		}
+		s, err := json.Marshal(replacePlaceholders(settings, replace))
+		if err != nil {
+			return nil, err
+		}
+		query := types.JSONText(q)
+		settingsText := types.JSONText(s)
+		title := el.Title
+		elements = append(elements, DashboardElement{
+			Type:     el.Type,
+			Title:    &title,
+			Query:    &query,
+			Settings: &settingsText,
+			OnDemand: el.OnDemand,
+			Refresh:  el.Refresh,
+		})
+	}
+	return elements, nil
+}
+
+// TemplateOptions are the options for creating a dashboard from a template
+type TemplateOptions struct {
+	// The user to create the dashboard for. Defaults to the caller.
+	Owner string `json:"owner,omitempty"`
+	// The name of the dashboard, which defaults to the template's name
+	Name string `json:"name,omitempty"`
+}
+
+// CreateFromTemplate creates a new dashboard object from the given template, and returns its ID. The placeholders
+// are resolved against the owner's objects, which must be readable by the database, and the dashboard is created
+// with the database's permissions.
+func (dp *DashboardProcessor) CreateFromTemplate(db database.DB, template string, o *TemplateOptions) (string, error) {
+	t, ok := dp.Templates[template]
+	if !ok {
+		return "", errors.New("not_found: The dashboard template was not found")
+	}
+	owner := o.Owner
+	if owner == "" {
+		if db.Type() != database.UserType {
+			return "", errors.New("bad_request: The dashboard's owner must be given")
+		}
+		owner = db.ID()
+	}
+	ids, err := t.resolve(db, owner)
+	if err != nil {
+		return "", err
+	}
+	elements, err := t.Instantiate(ids)
+	if err != nil {
+		return "", err
+	}
+
+	name := o.Name
+	if name == "" {
+		name = t.Name
+	}
+	otype := "dashboard"
+	obj := &database.Object{
+		Details: database.Details{
+			Name: &name,
+		},
+		Type:  &otype,
+		Owner: &owner,
+	}
+	if t.Description != "" {
+		obj.Description = &t.Description
+	}
+	if t.Icon != "" {
+		obj.Icon = &t.Icon
+	}
+	oid, err := db.CreateObject(obj)
+	if err != nil {
+		return "", err
+	}
+	if err = WriteDashboard(dp.ADB, owner, oid, elements); err != nil {
+		if derr := dp.ADB.DelObject(oid); derr != nil {
+			logrus.Errorf("Failed to delete dashboard %s after failing to create it from template: %v", oid, derr)
+		}
+		return "", err
+	}
+	return oid, nil
+}
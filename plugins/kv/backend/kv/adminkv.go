@@ -9,23 +9,30 @@ type AdminUserKV struct {
 }
 
 func (k *AdminUserKV) Get() (map[string]interface{}, error) {
-	return getKV(k.DB, `SELECT key,value FROM kv_user WHERE user=? AND namespace=?`, k.ID, k.Namespace)
+	return getKV(k.DB, `SELECT key,value FROM kv_user WHERE user=? AND namespace=? AND (expires_at IS NULL OR expires_at>?)`, k.ID, k.Namespace, unixNow())
 }
 func (k *AdminUserKV) Set(data map[string]interface{}) error {
-	return setKV(k.DB, data, `DELETE FROM kv_user WHERE user=? AND namespace=?`, []interface{}{k.ID, k.Namespace}, `INSERT INTO kv_user(user,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return setKV(k.DB, "kv_user", "user", k.ID, k.Namespace, data)
 }
 func (k *AdminUserKV) Update(data map[string]interface{}) error {
-	return updateKV(k.DB, data, `INSERT OR REPLACE INTO kv_user(user,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return updateKV(k, data)
 }
 
 func (k *AdminUserKV) SetKey(key string, value interface{}) error {
-	return setKey(k.DB, key, value, `INSERT OR REPLACE INTO kv_user(user,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	_, err := k.Batch([]Op{{Op: "set", Key: key, Value: value}})
+	return err
 }
 func (k *AdminUserKV) GetKey(key string) (interface{}, error) {
-	return getKey(k.DB, `SELECT value FROM kv_user WHERE user=? AND namespace=? AND key=?;`, k.ID, k.Namespace, key)
+	return getKey(k.DB, `SELECT value FROM kv_user WHERE user=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
 }
 func (k *AdminUserKV) DelKey(key string) error {
-	return runStatement(k.DB, "DELETE FROM kv_user WHERE user=? AND namespace=? AND key=?;", k.ID, k.Namespace, key)
+	return delKey(k.DB, "kv_user", "user", k.ID, k.Namespace, key)
+}
+func (k *AdminUserKV) GetKeyETag(key string) (interface{}, string, error) {
+	return getKeyETag(k.DB, `SELECT value FROM kv_user WHERE user=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
+}
+func (k *AdminUserKV) Batch(ops []Op) ([]OpResult, error) {
+	return batchKV(k.DB, "kv_user", "user", k.ID, k.Namespace, ops)
 }
 
 type AdminAppKV struct {
@@ -35,23 +42,30 @@ type AdminAppKV struct {
 }
 
 func (k *AdminAppKV) Get() (map[string]interface{}, error) {
-	return getKV(k.DB, `SELECT key,value FROM kv_app WHERE app=? AND namespace=?`, k.ID, k.Namespace)
+	return getKV(k.DB, `SELECT key,value FROM kv_app WHERE app=? AND namespace=? AND (expires_at IS NULL OR expires_at>?)`, k.ID, k.Namespace, unixNow())
 }
 func (k *AdminAppKV) Set(data map[string]interface{}) error {
-	return setKV(k.DB, data, `DELETE FROM kv_app WHERE app=? AND namespace=?`, []interface{}{k.ID, k.Namespace}, `INSERT INTO kv_app(app,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return setKV(k.DB, "kv_app", "app", k.ID, k.Namespace, data)
 }
 func (k *AdminAppKV) Update(data map[string]interface{}) error {
-	return updateKV(k.DB, data, `INSERT OR REPLACE INTO kv_app(app,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return updateKV(k, data)
 }
 
 func (k *AdminAppKV) SetKey(key string, value interface{}) error {
-	return setKey(k.DB, key, value, `INSERT OR REPLACE INTO kv_app(app,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	_, err := k.Batch([]Op{{Op: "set", Key: key, Value: value}})
+	return err
 }
 func (k *AdminAppKV) GetKey(key string) (interface{}, error) {
-	return getKey(k.DB, `SELECT value FROM kv_app WHERE app=? AND namespace=? AND key=?;`, k.ID, k.Namespace, key)
+	return getKey(k.DB, `SELECT value FROM kv_app WHERE app=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
 }
 func (k *AdminAppKV) DelKey(key string) error {
-	return runStatement(k.DB, "DELETE FROM kv_app WHERE app=? AND namespace=? AND key=?;", k.ID, k.Namespace, key)
+	return delKey(k.DB, "kv_app", "app", k.ID, k.Namespace, key)
+}
+func (k *AdminAppKV) GetKeyETag(key string) (interface{}, string, error) {
+	return getKeyETag(k.DB, `SELECT value FROM kv_app WHERE app=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
+}
+func (k *AdminAppKV) Batch(ops []Op) ([]OpResult, error) {
+	return batchKV(k.DB, "kv_app", "app", k.ID, k.Namespace, ops)
 }
 
 type AdminObjectKV struct {
@@ -61,21 +75,28 @@ type AdminObjectKV struct {
 }
 
 func (k *AdminObjectKV) Get() (map[string]interface{}, error) {
-	return getKV(k.DB, `SELECT key,value FROM kv_object WHERE object=? AND namespace=?`, k.ID, k.Namespace)
+	return getKV(k.DB, `SELECT key,value FROM kv_object WHERE object=? AND namespace=? AND (expires_at IS NULL OR expires_at>?)`, k.ID, k.Namespace, unixNow())
 }
 func (k *AdminObjectKV) Set(data map[string]interface{}) error {
-	return setKV(k.DB, data, `DELETE FROM kv_object WHERE object=? AND namespace=?`, []interface{}{k.ID, k.Namespace}, `INSERT INTO kv_object(object,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return setKV(k.DB, "kv_object", "object", k.ID, k.Namespace, data)
 }
 func (k *AdminObjectKV) Update(data map[string]interface{}) error {
-	return updateKV(k.DB, data, `INSERT OR REPLACE INTO kv_object(object,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	return updateKV(k, data)
 }
 
 func (k *AdminObjectKV) SetKey(key string, value interface{}) error {
-	return setKey(k.DB, key, value, `INSERT OR REPLACE INTO kv_object(object,namespace,key,value) VALUES (?,?,?,?)`, k.ID, k.Namespace)
+	_, err := k.Batch([]Op{{Op: "set", Key: key, Value: value}})
+	return err
 }
 func (k *AdminObjectKV) GetKey(key string) (interface{}, error) {
-	return getKey(k.DB, `SELECT value FROM kv_object WHERE object=? AND namespace=? AND key=?;`, k.ID, k.Namespace, key)
+	return getKey(k.DB, `SELECT value FROM kv_object WHERE object=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
 }
 func (k *AdminObjectKV) DelKey(key string) error {
-	return runStatement(k.DB, "DELETE FROM kv_object WHERE object=? AND namespace=? AND key=?;", k.ID, k.Namespace, key)
+	return delKey(k.DB, "kv_object", "object", k.ID, k.Namespace, key)
+}
+func (k *AdminObjectKV) GetKeyETag(key string) (interface{}, string, error) {
+	return getKeyETag(k.DB, `SELECT value FROM kv_object WHERE object=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?);`, k.ID, k.Namespace, key, unixNow())
+}
+func (k *AdminObjectKV) Batch(ops []Op) ([]OpResult, error) {
+	return batchKV(k.DB, "kv_object", "object", k.ID, k.Namespace, ops)
 }
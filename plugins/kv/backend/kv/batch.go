@@ -0,0 +1,237 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+// ErrPreconditionFailed is returned when a key's ETag doesn't match the one given in If-Match
+var ErrPreconditionFailed = errors.New("precondition_failed: The key was modified")
+
+// BatchKey is the path under a namespace that runs batches, so it can't be used as the name of a key
+const BatchKey = "_batch"
+
+// ErrReservedKey is returned when setting a key named BatchKey
+var ErrReservedKey = errors.New("bad_request: _batch is reserved, and can't be used as a key")
+
+// Op is a single operation in a batch. The op is one of set, delete, merge (a JSON merge patch of the key's value),
+// increment (adds the numeric value, 1 by default, to the key's numeric value) and check (only checks if_match).
+type Op struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+
+	// If set, the operation is only run if the key's current ETag matches. "*" matches any existing key.
+	IfMatch string `json:"if_match,omitempty"`
+
+	// The number of seconds after which the key expires. A set without a ttl makes the key permanent,
+	// while merge and increment keep the key's current expiry.
+	TTL *float64 `json:"ttl,omitempty"`
+}
+
+// OpResult is the value of an operation's key after the operation was run
+type OpResult struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	ETag  string      `json:"etag,omitempty"`
+
+	// The unix time at which the key expires, if it has a ttl
+	ExpiresAt *float64 `json:"expires_at,omitempty"`
+}
+
+// ETag returns the ETag of a stored json value
+func ETag(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return `"` + hex.EncodeToString(h[:8]) + `"`
+}
+
+// ETagMatches returns whether the etag satisfies the given If-Match header. An empty etag means that the key doesn't exist.
+func ETagMatches(ifMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, m := range strings.Split(ifMatch, ",") {
+		m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+		if m == "*" || m == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MergePatch applies a JSON merge patch (RFC 7386) to the given value
+func MergePatch(value, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	vm, ok := value.(map[string]interface{})
+	if !ok {
+		vm = make(map[string]interface{})
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(vm, k)
+		} else {
+			vm[k] = MergePatch(vm[k], v)
+		}
+	}
+	return vm
+}
+
+// applyOp returns the key's new value after running the op on its current value
+func applyOp(op *Op, cur interface{}) (interface{}, error) {
+	switch op.Op {
+	case "set":
+		return op.Value, nil
+	case "merge":
+		return MergePatch(cur, op.Value), nil
+	case "increment":
+		delta := 1.0
+		if op.Value != nil {
+			d, ok := op.Value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("bad_request: can't increment '%s' by a non-numeric value", op.Key)
+			}
+			delta = d
+		}
+		if cur == nil {
+			return delta, nil
+		}
+		c, ok := cur.(float64)
+		if !ok {
+			return nil, fmt.Errorf("bad_request: can't increment '%s', since it is not a number", op.Key)
+		}
+		return c + delta, nil
+	}
+	return nil, fmt.Errorf("bad_request: unrecognized operation '%s'", op.Op)
+}
+
+// batchKV runs the given operations on the keys of the namespace in the given table, where idcol is the
+// column holding the user, app or object id. The kv_set and kv_delete events of the modified keys are fired
+// once the batch is committed.
+func batchKV(adb *database.AdminDB, table, idcol, id, namespace string, ops []Op) ([]OpResult, error) {
+	selectStatement := fmt.Sprintf("SELECT value,expires_at FROM %s WHERE %s=? AND namespace=? AND key=?;", table, idcol)
+	setStatement := fmt.Sprintf("INSERT OR REPLACE INTO %s(%s,namespace,key,value,expires_at) VALUES (?,?,?,?,?);", table, idcol)
+	deleteStatement := fmt.Sprintf("DELETE FROM %s WHERE %s=? AND namespace=? AND key=?;", table, idcol)
+
+	tx, err := adb.BeginImmediatex()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := unixNow()
+	res := make([]OpResult, len(ops))
+	var changed []events.Event
+	for i := range ops {
+		op := &ops[i]
+		if op.Key == "" {
+			return nil, errors.New("bad_request: operations must have a key")
+		}
+		if op.Key == BatchKey {
+			return nil, ErrReservedKey
+		}
+		if op.TTL != nil && *op.TTL <= 0 {
+			return nil, fmt.Errorf("bad_request: the ttl of '%s' must be positive", op.Key)
+		}
+		res[i].Key = op.Key
+
+		var row struct {
+			Value     string
+			ExpiresAt *float64 `db:"expires_at"`
+		}
+		err = tx.Get(&row, selectStatement, id, namespace, op.Key)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		// Expired keys that were not yet removed don't exist
+		exists := err == nil && (row.ExpiresAt == nil || *row.ExpiresAt > now)
+		etag := ""
+		var cur interface{}
+		if exists {
+			etag = ETag(row.Value)
+			if err = json.Unmarshal([]byte(row.Value), &cur); err != nil {
+				return nil, err
+			}
+		}
+		if op.IfMatch != "" && !ETagMatches(op.IfMatch, etag) {
+			return nil, fmt.Errorf("%w (%s)", ErrPreconditionFailed, op.Key)
+		}
+
+		switch op.Op {
+		case "check":
+			res[i].Value = cur
+			res[i].ETag = etag
+			if exists {
+				res[i].ExpiresAt = row.ExpiresAt
+			}
+		case "delete":
+			if _, err = tx.Exec(deleteStatement, id, namespace, op.Key); err != nil {
+				return nil, err
+			}
+			if exists {
+				changed = append(changed, kvEvent("kv_delete", idcol, id, namespace, op.Key))
+			}
+		default:
+			v, err := applyOp(op, cur)
+			if err != nil {
+				return nil, err
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			// set replaces the key's ttl, while the other operations keep it unless a new ttl is given
+			var expiresAt *float64
+			if op.TTL != nil {
+				t := now + *op.TTL
+				expiresAt = &t
+			} else if op.Op != "set" && exists {
+				expiresAt = row.ExpiresAt
+			}
+			if _, err = tx.Exec(setStatement, id, namespace, op.Key, string(b), expiresAt); err != nil {
+				return nil, err
+			}
+			// The result holds the value as it was stored, just like a later read would return it
+			if err = json.Unmarshal(b, &res[i].Value); err != nil {
+				return nil, err
+			}
+			res[i].ETag = ETag(string(b))
+			res[i].ExpiresAt = expiresAt
+			changed = append(changed, kvEvent("kv_set", idcol, id, namespace, op.Key))
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if changed != nil {
+		if expiresAny(ops) {
+			wakeExpirer()
+		}
+		eh := database.NewFilledHandler(adb, events.GlobalHandler)
+		for i := range changed {
+			eh.Fire(&changed[i])
+		}
+	}
+	return res, nil
+}
+
+// expiresAny returns whether any of the operations sets a ttl
+func expiresAny(ops []Op) bool {
+	for i := range ops {
+		if ops[i].TTL != nil {
+			return true
+		}
+	}
+	return false
+}
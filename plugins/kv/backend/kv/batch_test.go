@@ -0,0 +1,248 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+func newDB(t *testing.T) (*database.AdminDB, func()) {
+	dir, err := ioutil.TempDir("", "heedy-kv-")
+	require.NoError(t, err)
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	addr := ":1324"
+	sqla := "sqlite3://heedy.db?_journal=WAL&_fk=1"
+	limit := int64(4e6)
+	a, err := assets.Open("", &assets.Configuration{Addr: &addr, SQL: &sqla, RequestBodyByteLimit: &limit})
+	require.NoError(t, err)
+	a.FolderPath = dir
+	assets.SetGlobal(a)
+	if err = database.Create(a); err != nil {
+		cleanup()
+	}
+	require.NoError(t, err)
+	db, err := database.Open(a)
+	require.NoError(t, err)
+
+	name := "test"
+	passwd := "test"
+	require.NoError(t, db.CreateUser(&database.User{
+		UserName: &name,
+		Password: &passwd,
+	}))
+	return db, func() {
+		db.Close()
+		cleanup()
+	}
+}
+
+func TestBatch(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+	k := &AdminUserKV{DB: db, ID: "test", Namespace: "myplugin"}
+
+	require.NoError(t, k.SetKey("cursor", "abc"))
+	v, etag, err := k.GetKeyETag("cursor")
+	require.NoError(t, err)
+	require.Equal(t, "abc", v)
+	require.NotEmpty(t, etag)
+
+	_, etag2, err := k.GetKeyETag("nokey")
+	require.NoError(t, err)
+	require.Empty(t, etag2)
+
+	// Compare-and-set only writes if the key wasn't modified
+	res, err := k.Batch([]Op{{Op: "set", Key: "cursor", Value: "def", IfMatch: etag}})
+	require.NoError(t, err)
+	require.NotEqual(t, etag, res[0].ETag)
+	_, err = k.Batch([]Op{{Op: "set", Key: "cursor", Value: "ghi", IfMatch: etag}})
+	require.True(t, errors.Is(err, ErrPreconditionFailed))
+	_, err = k.Batch([]Op{{Op: "delete", Key: "nokey", IfMatch: "*"}})
+	require.True(t, errors.Is(err, ErrPreconditionFailed))
+	v, err = k.GetKey("cursor")
+	require.NoError(t, err)
+	require.Equal(t, "def", v)
+
+	res, err = k.Batch([]Op{
+		{Op: "increment", Key: "counter"},
+		{Op: "increment", Key: "counter", Value: 2.5},
+		{Op: "set", Key: "obj", Value: map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}}},
+		{Op: "merge", Key: "obj", Value: map[string]interface{}{"a": nil, "b": map[string]interface{}{"d": 3}}},
+		{Op: "check", Key: "cursor", IfMatch: res[0].ETag},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1.0, res[0].Value)
+	require.Equal(t, 3.5, res[1].Value)
+	require.Equal(t, map[string]interface{}{"b": map[string]interface{}{"c": 2.0, "d": 3.0}}, res[3].Value)
+	require.Equal(t, "def", res[4].Value)
+
+	// A failed operation rolls back the whole batch
+	_, err = k.Batch([]Op{
+		{Op: "increment", Key: "counter"},
+		{Op: "increment", Key: "cursor"},
+	})
+	require.Error(t, err)
+	_, err = k.Batch([]Op{
+		{Op: "delete", Key: "counter"},
+		{Op: "set", Key: "cursor", Value: 1, IfMatch: etag},
+	})
+	require.Error(t, err)
+	_, err = k.Batch([]Op{{Op: "rename", Key: "counter"}})
+	require.Error(t, err)
+
+	m, err := k.Get()
+	require.NoError(t, err)
+	require.Equal(t, 3.5, m["counter"])
+	require.Equal(t, "def", m["cursor"])
+
+	res, err = k.Batch([]Op{{Op: "delete", Key: "counter"}})
+	require.NoError(t, err)
+	require.Nil(t, res[0].Value)
+	v, err = k.GetKey("counter")
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// Deleting a missing key succeeds in a batch, but not on its own
+	_, err = k.Batch([]Op{{Op: "delete", Key: "counter"}})
+	require.NoError(t, err)
+	require.Error(t, k.DelKey("counter"))
+
+	// The batch path can't be used as a key
+	require.Equal(t, ErrReservedKey, k.SetKey(BatchKey, 1))
+	require.Equal(t, ErrReservedKey, k.Set(map[string]interface{}{BatchKey: 1}))
+
+	handler := GenerateHandler(UserAuth)
+	request := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), rest.HeedyContext, &rest.Context{
+			DB:  db,
+			Log: logrus.NewEntry(logrus.StandardLogger()),
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := request(http.MethodPost, "/test/myplugin/_batch", `[{"op":"set","key":"counter","value":1}]`, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = request(http.MethodDelete, "/test/myplugin/counter", "", map[string]string{"If-Match": `"notetag"`})
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+	rec = request(http.MethodDelete, "/test/myplugin/counter", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = request(http.MethodDelete, "/test/myplugin/counter", "", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "not_found")
+}
+
+type testEvents struct {
+	sync.Mutex
+	events []*events.Event
+}
+
+func (te *testEvents) Fire(e *events.Event) {
+	te.Lock()
+	defer te.Unlock()
+	if strings.HasPrefix(e.Event, "kv_") {
+		te.events = append(te.events, e)
+	}
+}
+
+func (te *testEvents) get() []*events.Event {
+	te.Lock()
+	defer te.Unlock()
+	return te.events
+}
+
+func TestExpiryAndEvents(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+	te := &testEvents{}
+	events.AddHandler(te)
+	defer events.RemoveHandler(te)
+
+	k := &AdminUserKV{DB: db, ID: "test", Namespace: "myplugin"}
+	require.NoError(t, k.SetKey("config", "a"))
+	require.Len(t, te.get(), 1)
+	require.Equal(t, "kv_set", te.events[0].Event)
+	require.Equal(t, "test", te.events[0].User)
+	require.Equal(t, &KVEvent{Namespace: "myplugin", Key: "config"}, te.events[0].Data)
+
+	require.NoError(t, k.DelKey("config"))
+	require.Error(t, k.DelKey("config"))
+	require.Len(t, te.get(), 2)
+	require.Equal(t, "kv_delete", te.events[1].Event)
+
+	// Replacing the namespace fires deletes for the removed keys
+	require.NoError(t, k.Update(map[string]interface{}{"a": 1, "b": 2}))
+	require.Len(t, te.get(), 4)
+	require.NoError(t, k.Set(map[string]interface{}{"b": 3}))
+	require.Len(t, te.get(), 6)
+	require.Equal(t, "kv_delete", te.events[4].Event)
+	require.Equal(t, "a", te.events[4].Data.(*KVEvent).Key)
+	require.Equal(t, "kv_set", te.events[5].Event)
+
+	// A failed batch fires nothing
+	_, err := k.Batch([]Op{{Op: "set", Key: "c", Value: 1}, {Op: "set", Key: "d", TTL: &[]float64{-1}[0]}})
+	require.Error(t, err)
+	require.Len(t, te.get(), 6)
+
+	ttl := 0.2
+	res, err := k.Batch([]Op{{Op: "set", Key: "session", Value: "s", TTL: &ttl}})
+	require.NoError(t, err)
+	require.NotNil(t, res[0].ExpiresAt)
+
+	// Increments keep the expiry, while a set without a ttl removes it
+	res, err = k.Batch([]Op{{Op: "increment", Key: "session2", TTL: &ttl}, {Op: "increment", Key: "session2"}, {Op: "set", Key: "b", Value: 4}})
+	require.NoError(t, err)
+	require.Equal(t, res[0].ExpiresAt, res[1].ExpiresAt)
+	require.Nil(t, res[2].ExpiresAt)
+	require.Len(t, te.get(), 10)
+
+	time.Sleep(300 * time.Millisecond)
+
+	// Expired keys are not visible, even before they are removed
+	v, err := k.GetKey("session")
+	require.NoError(t, err)
+	require.Nil(t, v)
+	m, err := k.Get()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"b": 4.0}, m)
+	require.Error(t, k.DelKey("session"))
+	_, err = k.Batch([]Op{{Op: "check", Key: "session", IfMatch: "*"}})
+	require.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	e := &Expirer{DB: db}
+	next, err := e.next()
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.NoError(t, e.process(unixNow()))
+	next, err = e.next()
+	require.NoError(t, err)
+	require.Nil(t, next)
+
+	evts := te.get()
+	require.Len(t, evts, 12)
+	deleted := []string{evts[10].Data.(*KVEvent).Key, evts[11].Data.(*KVEvent).Key}
+	require.ElementsMatch(t, []string{"session", "session2"}, deleted)
+	require.Equal(t, "kv_delete", evts[10].Event)
+}
@@ -0,0 +1,39 @@
+package kv
+
+import (
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+// KVEvent is the data of the kv_set and kv_delete events. The value itself is not included, since the keys of
+// a namespace are private to the plugin that owns it.
+type KVEvent struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// kvEvent returns the event for a change to the given key, targeting the user, app or object that owns it
+func kvEvent(event, idcol, id, namespace, key string) events.Event {
+	evt := events.Event{
+		Event: event,
+		Data: &KVEvent{
+			Namespace: namespace,
+			Key:       key,
+		},
+	}
+	switch idcol {
+	case "object":
+		evt.Object = id
+	case "app":
+		evt.App = id
+	default:
+		evt.User = id
+	}
+	return evt
+}
+
+// fireKV fires the event for a change to the given key
+func fireKV(adb *database.AdminDB, event, idcol, id, namespace, key string) {
+	evt := kvEvent(event, idcol, id, namespace, key)
+	database.NewFilledHandler(adb, events.GlobalHandler).Fire(&evt)
+}
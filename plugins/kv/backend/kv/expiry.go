@@ -0,0 +1,136 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/heedy/heedy/backend/database"
+)
+
+// expirerMaxWait is the longest time that the expirer sleeps between checking for expired keys
+var expirerMaxWait = time.Minute
+
+// Expirer removes keys once their ttl runs out, firing their kv_delete events
+type Expirer struct {
+	DB *database.AdminDB
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	expirerLock sync.Mutex
+	expirer     *Expirer
+)
+
+// StartExpirer starts the global key expirer
+func StartExpirer(db *database.AdminDB) {
+	expirerLock.Lock()
+	defer expirerLock.Unlock()
+	if expirer != nil {
+		return
+	}
+	expirer = &Expirer{
+		DB:   db,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go expirer.run()
+}
+
+// StopExpirer stops the global key expirer
+func StopExpirer() {
+	expirerLock.Lock()
+	e := expirer
+	expirer = nil
+	expirerLock.Unlock()
+	if e != nil {
+		close(e.stop)
+		<-e.done
+	}
+}
+
+// wakeExpirer makes the expirer recompute when it needs to run, since a key's ttl was set
+func wakeExpirer() {
+	expirerLock.Lock()
+	e := expirer
+	expirerLock.Unlock()
+	if e != nil {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Expirer) run() {
+	defer close(e.done)
+	for {
+		now := unixNow()
+		if err := e.process(now); err != nil {
+			logrus.Errorf("Failed to remove expired keys: %s", err)
+		}
+		wait := expirerMaxWait
+		next, err := e.next()
+		if err != nil {
+			logrus.Errorf("Failed to read key expiry: %s", err)
+		} else if next != nil {
+			if d := time.Duration((*next - unixNow()) * float64(time.Second)); d < wait {
+				wait = d
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.wake:
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// next returns the time at which the next key expires, or nil if no keys have a ttl
+func (e *Expirer) next() (*float64, error) {
+	var next *float64
+	for _, tbl := range kvTables {
+		var t *float64
+		if err := e.DB.Get(&t, fmt.Sprintf("SELECT MIN(expires_at) FROM %s WHERE expires_at IS NOT NULL", tbl.Name)); err != nil {
+			return nil, err
+		}
+		if t != nil && (next == nil || *t < *next) {
+			next = t
+		}
+	}
+	return next, nil
+}
+
+// process deletes the keys that expired by the given time, and fires their delete events
+func (e *Expirer) process(now float64) error {
+	for _, tbl := range kvTables {
+		var expired []struct {
+			ID        string `db:"id"`
+			Namespace string
+			Key       string
+		}
+		err := e.DB.Select(&expired, fmt.Sprintf("SELECT %s AS id,namespace,key FROM %s WHERE expires_at<=?", tbl.IDCol, tbl.Name), now)
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			// The key is only deleted if it wasn't given a new ttl in the meantime
+			res, err := e.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s=? AND namespace=? AND key=? AND expires_at<=?", tbl.Name, tbl.IDCol), k.ID, k.Namespace, k.Key, now)
+			if err = database.GetExecError(res, err); err == database.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			fireKV(e.DB, "kv_delete", tbl.IDCol, k.ID, k.Namespace, k.Key)
+		}
+	}
+	return nil
+}
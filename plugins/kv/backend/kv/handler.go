@@ -1,7 +1,9 @@
 package kv
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/heedy/heedy/api/golang/rest"
@@ -69,28 +71,75 @@ func GenerateHandler(authenticator func(ctx *rest.Context, id string, namespace
 			rest.WriteJSONError(w, r, http.StatusForbidden, err)
 			return
 		}
-		m, err := v.GetKey(key)
+		m, etag, err := v.GetKeyETag(key)
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		rest.WriteJSON(w, r, m, err)
 	}))
 
-	kvmux.Post("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// runOp runs a single operation on the url's key, which is only run if the key matches the If-Match header.
+	// If the operation has a value, it is read from the request body, which is optional for increments.
+	// The key's ttl in seconds can be given in the ttl query parameter.
+	runOp := func(w http.ResponseWriter, r *http.Request, op string, hasValue bool) (*OpResult, bool) {
 		v, err := getauth(r)
 		if err != nil {
 			rest.WriteJSONError(w, r, http.StatusForbidden, err)
-			return
+			return nil, false
 		}
-		var m interface{}
-		err = rest.UnmarshalRequest(r, &m)
-		key, err := rest.URLParam(r, "key", err)
+		o := Op{Op: op, IfMatch: r.Header.Get("If-Match")}
+		if hasValue && (op != "increment" || r.ContentLength != 0) {
+			err = rest.UnmarshalRequest(r, &o.Value)
+		}
+		if ttl := r.URL.Query().Get("ttl"); ttl != "" && err == nil {
+			var t float64
+			if t, err = strconv.ParseFloat(ttl, 64); err != nil {
+				err = errors.New("bad_request: the ttl must be a number of seconds")
+			}
+			o.TTL = &t
+		}
+		o.Key, err = rest.URLParam(r, "key", err)
 		if err != nil {
 			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
-			return
+			return nil, false
+		}
+		res, err := v.Batch([]Op{o})
+		if err != nil {
+			writeKVError(w, r, err)
+			return nil, false
 		}
+		if res[0].ETag != "" {
+			w.Header().Set("ETag", res[0].ETag)
+		}
+		return &res[0], true
+	}
+
+	kvmux.Post("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := runOp(w, r, "set", true); ok {
+			rest.WriteResult(w, r, nil)
+		}
+	}))
 
-		rest.WriteResult(w, r, v.SetKey(key, m))
+	kvmux.Patch("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if res, ok := runOp(w, r, "merge", true); ok {
+			rest.WriteJSON(w, r, res.Value, nil)
+		}
+	}))
+
+	kvmux.Post("/{id}/{namespace}/{key}/increment", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if res, ok := runOp(w, r, "increment", true); ok {
+			rest.WriteJSON(w, r, res.Value, nil)
+		}
 	}))
 
 	kvmux.Delete("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") != "" {
+			if _, ok := runOp(w, r, "delete", false); ok {
+				rest.WriteResult(w, r, nil)
+			}
+			return
+		}
+		// Unlike a delete in a batch, deleting a single key fails if it doesn't exist
 		v, err := getauth(r)
 		key, err := rest.URLParam(r, "key", err)
 		if err != nil {
@@ -100,9 +149,37 @@ func GenerateHandler(authenticator func(ctx *rest.Context, id string, namespace
 		rest.WriteResult(w, r, v.DelKey(key))
 	}))
 
+	kvmux.Post("/{id}/{namespace}/"+BatchKey, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, err := getauth(r)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusForbidden, err)
+			return
+		}
+		var ops []Op
+		if err = rest.UnmarshalRequest(r, &ops); err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		res, err := v.Batch(ops)
+		if err != nil {
+			writeKVError(w, r, err)
+			return
+		}
+		rest.WriteJSON(w, r, res, nil)
+	}))
+
 	return kvmux
 }
 
+// writeKVError writes the error, using 412 Precondition Failed if the key didn't match If-Match
+func writeKVError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrPreconditionFailed) {
+		rest.WriteJSONError(w, r, http.StatusPreconditionFailed, err)
+		return
+	}
+	rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+}
+
 var Handler = func() *chi.Mux {
 
 	apiMux := chi.NewMux()
@@ -113,5 +190,9 @@ var Handler = func() *chi.Mux {
 	apiMux.Mount("/api/kv/apps", GenerateHandler(AppAuth))
 	apiMux.Mount("/api/kv/objects", GenerateHandler(ObjectAuth))
 
+	apiMux.Mount("/api/kv/secrets/users", GenerateSecretsHandler(UserSecrets))
+	apiMux.Mount("/api/kv/secrets/apps", GenerateSecretsHandler(AppSecrets))
+	apiMux.Mount("/api/kv/secrets/objects", GenerateSecretsHandler(ObjectSecrets))
+
 	return apiMux
 }()
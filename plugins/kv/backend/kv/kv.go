@@ -4,15 +4,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/heedy/heedy/api/golang/rest"
 	"github.com/heedy/heedy/backend/database"
 	"github.com/heedy/heedy/backend/plugins/run"
 )
 
-const SQLVersion = 1
+const SQLVersion = 3
 
 const sqlSchema = `
 
@@ -22,6 +24,7 @@ CREATE TABLE kv_user (
 	namespace VARCHAR NOT NULL,
 	key VARCHAR NOT NULL,
 	value VARCHAR NOT NULL DEFAULT 'null',
+	expires_at REAL DEFAULT NULL,
 
 	CONSTRAINT pk PRIMARY KEY (user,namespace,key),
 	CONSTRAINT valid_value CHECK(json_valid(value)),
@@ -39,6 +42,7 @@ CREATE TABLE kv_app (
 	namespace VARCHAR NOT NULL,
 	key VARCHAR NOT NULL,
 	value VARCHAR NOT NULL DEFAULT 'null',
+	expires_at REAL DEFAULT NULL,
 
 	CONSTRAINT pk PRIMARY KEY (app,namespace,key),
 	CONSTRAINT valid_value CHECK(json_valid(value)),
@@ -56,6 +60,7 @@ CREATE TABLE kv_object (
 	namespace VARCHAR NOT NULL,
 	key VARCHAR NOT NULL,
 	value VARCHAR NOT NULL DEFAULT 'null',
+	expires_at REAL DEFAULT NULL,
 
 	CONSTRAINT pk PRIMARY KEY (object,namespace,key),
 	CONSTRAINT valid_value CHECK(json_valid(value)),
@@ -67,20 +72,117 @@ CREATE TABLE kv_object (
 		ON DELETE CASCADE
 );
 
+CREATE INDEX kv_user_expires ON kv_user(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX kv_app_expires ON kv_app(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX kv_object_expires ON kv_object(expires_at) WHERE expires_at IS NOT NULL;
+
+` + secretsSchema
+
+// secretsSchema holds the encrypted secrets, which are kept separate from the kv tables, so that they are never
+// returned by the normal kv queries
+const secretsSchema = `
+
+CREATE TABLE kv_user_secret (
+	user VARCHAR NOT NULL,
+
+	namespace VARCHAR NOT NULL,
+	key VARCHAR NOT NULL,
+	value VARCHAR NOT NULL,
+
+	CONSTRAINT pk PRIMARY KEY (user,namespace,key),
+
+	CONSTRAINT fk
+		FOREIGN KEY (user)
+		REFERENCES users(username)
+		ON UPDATE CASCADE
+		ON DELETE CASCADE
+);
+
+CREATE TABLE kv_app_secret (
+	app VARCHAR NOT NULL,
+
+	namespace VARCHAR NOT NULL,
+	key VARCHAR NOT NULL,
+	value VARCHAR NOT NULL,
+
+	CONSTRAINT pk PRIMARY KEY (app,namespace,key),
+
+	CONSTRAINT fk
+		FOREIGN KEY (app)
+		REFERENCES apps(id)
+		ON UPDATE CASCADE
+		ON DELETE CASCADE
+);
+
+CREATE TABLE kv_object_secret (
+	object VARCHAR NOT NULL,
+
+	namespace VARCHAR NOT NULL,
+	key VARCHAR NOT NULL,
+	value VARCHAR NOT NULL,
+
+	CONSTRAINT pk PRIMARY KEY (object,namespace,key),
+
+	CONSTRAINT fk
+		FOREIGN KEY (object)
+		REFERENCES objects(id)
+		ON UPDATE CASCADE
+		ON DELETE CASCADE
+);
+
 `
 
 // SQLUpdater is in the format expected by Heedy to update the database
-func SQLUpdater(db *database.AdminDB, i *run.Info, curversion int) error {
+func SQLUpdater(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, curversion int) error {
 	if curversion == SQLVersion {
 		return nil
 	}
-	if curversion != 0 {
+	if curversion > SQLVersion {
 		return errors.New("KV database version too new")
 	}
-	_, err := db.ExecUncached(sqlSchema)
+	if curversion == 0 {
+		_, err := db.ExecUncached(sqlSchema)
+		return err
+	}
+	if curversion == 1 {
+		if err := addExpiry(db); err != nil {
+			return err
+		}
+	}
+	_, err := db.ExecUncached(secretsSchema)
 	return err
 }
 
+// addExpiry migrates from version 1, adding the expires_at column used for key TTLs
+func addExpiry(db *database.AdminDB) error {
+	tx, err := db.BeginImmediatex()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, tbl := range kvTables {
+		if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN expires_at REAL DEFAULT NULL", tbl.Name)); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(fmt.Sprintf("CREATE INDEX %[1]s_expires ON %[1]s(expires_at) WHERE expires_at IS NOT NULL", tbl.Name)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// kvTable is a table holding the keys of users, apps or objects, where IDCol is the column holding their id
+type kvTable struct {
+	Name  string
+	IDCol string
+}
+
+var kvTables = []kvTable{{"kv_user", "user"}, {"kv_app", "app"}, {"kv_object", "object"}}
+
+func unixNow() float64 {
+	return float64(time.Now().UnixNano()) * 1e-9
+}
+
 type KV interface {
 	Get() (map[string]interface{}, error)
 	Set(data map[string]interface{}) error
@@ -89,6 +191,11 @@ type KV interface {
 	SetKey(key string, value interface{}) error
 	GetKey(key string) (interface{}, error)
 	DelKey(key string) error
+
+	// GetKeyETag returns the key's value together with its ETag, which is empty if the key doesn't exist
+	GetKeyETag(key string) (interface{}, string, error)
+	// Batch runs the given operations in a single transaction. If any of them fails, none are applied.
+	Batch(ops []Op) ([]OpResult, error)
 }
 
 func UserAuth(ctx *rest.Context, username string, namespace string) (KV, error) {
@@ -169,68 +276,58 @@ func getKVr(w http.ResponseWriter, r *http.Request, selectStatement string, args
 	rest.WriteJSON(w, r, m, err)
 }
 
-func setKV(adb *database.AdminDB, data map[string]interface{}, deleteStatement string, deleteArgs []interface{}, setKeyStatement string, args ...interface{}) error {
-	tx, err := adb.Beginx()
+// setKV replaces all keys of the namespace with the given data
+func setKV(adb *database.AdminDB, table, idcol, id, namespace string, data map[string]interface{}) error {
+	if _, ok := data[BatchKey]; ok {
+		return ErrReservedKey
+	}
+	tx, err := adb.BeginImmediatex()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec(deleteStatement, deleteArgs...)
+	var oldKeys []string
+	err = tx.Select(&oldKeys, fmt.Sprintf("SELECT key FROM %s WHERE %s=? AND namespace=? AND (expires_at IS NULL OR expires_at>?)", table, idcol), id, namespace, unixNow())
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-
-	// Add the key and value elements
-	kindex := len(args)
-	vindex := kindex + 1
-	args = append(args, "", "")
+	if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s=? AND namespace=?", table, idcol), id, namespace); err != nil {
+		return err
+	}
+	setStatement := fmt.Sprintf("INSERT INTO %s(%s,namespace,key,value) VALUES (?,?,?,?)", table, idcol)
 	for k, v := range data {
-		args[kindex] = k
 		b, err := json.Marshal(v)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-		args[vindex] = string(b)
-		res, err := tx.Exec(setKeyStatement, args...)
-		err = database.GetExecError(res, err)
-		if err != nil {
-			tx.Rollback()
+		if _, err = tx.Exec(setStatement, id, namespace, k, string(b)); err != nil {
 			return err
 		}
 	}
-
-	return tx.Commit()
-}
-
-func updateKV(adb *database.AdminDB, data map[string]interface{}, setKeyStatement string, args ...interface{}) error {
-	tx, err := adb.Beginx()
-	if err != nil {
+	if err = tx.Commit(); err != nil {
 		return err
 	}
 
-	// Add the key and value elements
-	kindex := len(args)
-	vindex := kindex + 1
-	args = append(args, "", "")
-	for k, v := range data {
-		args[kindex] = k
-		b, err := json.Marshal(v)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		args[vindex] = string(b)
-		res, err := tx.Exec(setKeyStatement, args...)
-		err = database.GetExecError(res, err)
-		if err != nil {
-			tx.Rollback()
-			return err
+	for _, k := range oldKeys {
+		if _, ok := data[k]; !ok {
+			fireKV(adb, "kv_delete", idcol, id, namespace, k)
 		}
 	}
+	for k := range data {
+		fireKV(adb, "kv_set", idcol, id, namespace, k)
+	}
+	return nil
+}
 
-	return tx.Commit()
+// updateKV sets the given keys, leaving the other keys of the namespace unchanged
+func updateKV(k KV, data map[string]interface{}) error {
+	ops := make([]Op, 0, len(data))
+	for key, v := range data {
+		ops = append(ops, Op{Op: "set", Key: key, Value: v})
+	}
+	_, err := k.Batch(ops)
+	return err
 }
 
 func getKey(adb *database.AdminDB, selectStatement string, args ...interface{}) (interface{}, error) {
@@ -247,18 +344,26 @@ func getKey(adb *database.AdminDB, selectStatement string, args ...interface{})
 	return v, err
 }
 
-// The final argument of args in marshalled into json
-func setKey(adb *database.AdminDB, key string, value interface{}, insertStatement string, args ...interface{}) error {
-
-	b, err := json.Marshal(value)
+func getKeyETag(adb *database.AdminDB, selectStatement string, args ...interface{}) (interface{}, string, error) {
+	var sv string
+	err := adb.Get(&sv, selectStatement, args...)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return nil, "", nil
+		}
+		return nil, "", err
 	}
-	args = append(args, key, string(b))
-	return runStatement(adb, insertStatement, args)
+	var v interface{}
+	err = json.Unmarshal([]byte(sv), &v)
+	return v, ETag(sv), err
 }
 
-func runStatement(adb *database.AdminDB, statement string, args ...interface{}) error {
-	res, err := adb.Exec(statement, args...)
-	return database.GetExecError(res, err)
+// delKey deletes the key, returning an error if it doesn't exist
+func delKey(adb *database.AdminDB, table, idcol, id, namespace, key string) error {
+	res, err := adb.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s=? AND namespace=? AND key=? AND (expires_at IS NULL OR expires_at>?)", table, idcol), id, namespace, key, unixNow())
+	if err = database.GetExecError(res, err); err != nil {
+		return err
+	}
+	fireKV(adb, "kv_delete", idcol, id, namespace, key)
+	return nil
 }
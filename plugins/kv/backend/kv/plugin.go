@@ -2,6 +2,7 @@ package kv
 
 import (
 	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins"
 	"github.com/heedy/heedy/backend/plugins/run"
 )
 
@@ -10,14 +11,24 @@ const PluginName = "kv"
 // This is not needed for normal plugins. The init simply registers the plugin with heedy internals
 // for when it is compiled directly into the main heedy executable.
 func init() {
-	withversion := run.WithVersion(PluginName, SQLVersion, func(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, sqlVersion int) error {
-		return SQLUpdater(db, i, sqlVersion)
-	})
+	withversion := run.WithVersion(PluginName, SQLVersion, SQLUpdater)
 	run.Builtin.Add(&run.BuiltinRunner{
-		Key:     PluginName,
-		Start:   withversion,
+		Key: PluginName,
+		Start: func(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+			if err := withversion(db, i, h); err != nil {
+				return err
+			}
+			StartExpirer(db)
+			return nil
+		},
+		Stop: func(db *database.AdminDB, apikey string) error {
+			StopExpirer()
+			return nil
+		},
 		Handler: Handler,
 	})
+	// Secrets are only added to user exports when requested
+	plugins.AddUserExporter(exportSecrets)
 	// Runs schema creation on database create instead of on first start
 	database.AddCreateHook(run.WithNilInfo(withversion))
 }
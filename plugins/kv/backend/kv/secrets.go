@@ -0,0 +1,336 @@
+package kv
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins"
+)
+
+// SecretsKeyEnv is the environment variable holding the server key used to encrypt secrets. If it is not set,
+// the key is read from SecretsKeyFile in heedy's config folder, which is generated on first use.
+// The key is never stored in the database, so that a copy of heedy.db doesn't reveal the secrets.
+const SecretsKeyEnv = "HEEDY_SECRETS_KEY"
+
+// SecretsKeyFile is the name of the keyfile in heedy's config folder
+const SecretsKeyFile = "secrets.key"
+
+// ErrSecretAccess is returned when reading secrets from outside of the plugin that owns their namespace
+var ErrSecretAccess = errors.New("access_denied: Secrets can only be read by the plugin that owns their namespace")
+
+var secretsKey struct {
+	sync.Mutex
+	folder string
+	aead   cipher.AEAD
+}
+
+// secretsCipher returns the cipher used to encrypt the secrets of the heedy instance in the given folder
+func secretsCipher(folder string) (cipher.AEAD, error) {
+	secretsKey.Lock()
+	defer secretsKey.Unlock()
+	if secretsKey.aead != nil && secretsKey.folder == folder {
+		return secretsKey.aead, nil
+	}
+
+	passphrase := os.Getenv(SecretsKeyEnv)
+	if passphrase == "" {
+		keyfile := path.Join(folder, SecretsKeyFile)
+		b, err := ioutil.ReadFile(keyfile)
+		if os.IsNotExist(err) {
+			k := make([]byte, 32)
+			if _, err = rand.Read(k); err != nil {
+				return nil, err
+			}
+			b = []byte(base64.StdEncoding.EncodeToString(k))
+			err = ioutil.WriteFile(keyfile, b, 0600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the secrets keyfile: %w", err)
+		}
+		passphrase = string(b)
+	}
+	key := sha256.Sum256([]byte(strings.TrimSpace(passphrase)))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	secretsKey.folder = folder
+	secretsKey.aead = aead
+	return aead, nil
+}
+
+// Secrets is a namespace of encrypted values belonging to a user, app or object. Anyone that can write to the
+// owner can set secrets, but they can only be read by the plugin with the namespace's name.
+type Secrets struct {
+	DB        *database.AdminDB
+	Table     string
+	IDCol     string
+	ID        string
+	Namespace string
+
+	// Whether the values can be read
+	CanRead bool
+}
+
+// additionalData ties the encrypted value to its key, so that values can't be swapped between keys in the database
+func (s *Secrets) additionalData(key string) []byte {
+	return []byte(s.Namespace + "\x00" + key)
+}
+
+func (s *Secrets) encrypt(key string, value interface{}) (string, error) {
+	aead, err := secretsCipher(s.DB.Assets().FolderPath)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, b, s.additionalData(key))), nil
+}
+
+func (s *Secrets) decrypt(key string, value string) (interface{}, error) {
+	aead, err := secretsCipher(s.DB.Assets().FolderPath)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("The secret is corrupted")
+	}
+	b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], s.additionalData(key))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt secret '%s' - was the secrets key changed?", key)
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+// Keys returns the keys of the namespace's secrets, which are visible to anyone that can write them
+func (s *Secrets) Keys() ([]string, error) {
+	keys := []string{}
+	err := s.DB.Select(&keys, fmt.Sprintf("SELECT key FROM %s WHERE %s=? AND namespace=? ORDER BY key", s.Table, s.IDCol), s.ID, s.Namespace)
+	return keys, err
+}
+
+// Get returns all of the namespace's secrets
+func (s *Secrets) Get() (map[string]interface{}, error) {
+	if !s.CanRead {
+		return nil, ErrSecretAccess
+	}
+	var res []struct {
+		Key   string
+		Value string
+	}
+	err := s.DB.Select(&res, fmt.Sprintf("SELECT key,value FROM %s WHERE %s=? AND namespace=?", s.Table, s.IDCol), s.ID, s.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	for _, r := range res {
+		if m[r.Key], err = s.decrypt(r.Key, r.Value); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// GetKey returns the secret with the given key, or nil if it doesn't exist
+func (s *Secrets) GetKey(key string) (interface{}, error) {
+	if !s.CanRead {
+		return nil, ErrSecretAccess
+	}
+	var v string
+	err := s.DB.Get(&v, fmt.Sprintf("SELECT value FROM %s WHERE %s=? AND namespace=? AND key=?", s.Table, s.IDCol), s.ID, s.Namespace, key)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(key, v)
+}
+
+// SetKey encrypts and stores the secret
+func (s *Secrets) SetKey(key string, value interface{}) error {
+	v, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %s(%s,namespace,key,value) VALUES (?,?,?,?)", s.Table, s.IDCol), s.ID, s.Namespace, key, v)
+	return err
+}
+
+// DelKey deletes the secret, returning an error if it doesn't exist
+func (s *Secrets) DelKey(key string) error {
+	res, err := s.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s=? AND namespace=? AND key=?", s.Table, s.IDCol), s.ID, s.Namespace, key)
+	return database.GetExecError(res, err)
+}
+
+// requestPlugin returns the plugin that sent the request using its API key. Requests that heedy forwards on
+// behalf of users are sent with the core key, so they are not treated as coming from a plugin.
+func requestPlugin(ctx *rest.Context) string {
+	if ctx.Plugin == "heedy" {
+		return ""
+	}
+	return ctx.Plugin
+}
+
+// canReadSecrets returns whether the request comes from the plugin that owns the namespace. Plugins identify
+// themselves with their API key, so the caller's identity in X-Heedy-As doesn't matter.
+func canReadSecrets(ctx *rest.Context, namespace string) bool {
+	p := requestPlugin(ctx)
+	return p != "" && p == namespace
+}
+
+// canWriteSecrets returns whether the request is allowed to set the secrets of the given owner in the namespace.
+// This is the case for the plugin that owns the namespace, and for users writing to themselves or to their own
+// apps and objects. Other plugins are only let through as the owner, so they can't replace another plugin's secrets.
+func canWriteSecrets(ctx *rest.Context, namespace string, owner func(db database.DB) (*string, error)) error {
+	if p := requestPlugin(ctx); p != "" {
+		if p == namespace {
+			return nil
+		}
+	} else if ctx.DB.Type() == database.AdminType {
+		return nil
+	}
+	if ctx.DB.Type() == database.UserType {
+		o, err := owner(ctx.DB)
+		if err != nil {
+			return err
+		}
+		if o != nil && *o == ctx.DB.ID() {
+			return nil
+		}
+	}
+	return errors.New("access_denied: You can't set secrets here")
+}
+
+func UserSecrets(ctx *rest.Context, username string, namespace string) (*Secrets, error) {
+	err := canWriteSecrets(ctx, namespace, func(db database.DB) (*string, error) {
+		return &username, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{
+		DB:        ctx.DB.AdminDB(),
+		Table:     "kv_user_secret",
+		IDCol:     "user",
+		ID:        username,
+		Namespace: namespace,
+		CanRead:   canReadSecrets(ctx, namespace),
+	}, nil
+}
+
+func AppSecrets(ctx *rest.Context, appid string, namespace string) (*Secrets, error) {
+	err := canWriteSecrets(ctx, namespace, func(db database.DB) (*string, error) {
+		a, err := db.ReadApp(appid, nil)
+		if err != nil {
+			return nil, err
+		}
+		return a.Owner, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{
+		DB:        ctx.DB.AdminDB(),
+		Table:     "kv_app_secret",
+		IDCol:     "app",
+		ID:        appid,
+		Namespace: namespace,
+		CanRead:   canReadSecrets(ctx, namespace),
+	}, nil
+}
+
+func ObjectSecrets(ctx *rest.Context, oid string, namespace string) (*Secrets, error) {
+	err := canWriteSecrets(ctx, namespace, func(db database.DB) (*string, error) {
+		o, err := db.ReadObject(oid, nil)
+		if err != nil {
+			return nil, err
+		}
+		return o.Owner, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{
+		DB:        ctx.DB.AdminDB(),
+		Table:     "kv_object_secret",
+		IDCol:     "object",
+		ID:        oid,
+		Namespace: namespace,
+		CanRead:   canReadSecrets(ctx, namespace),
+	}, nil
+}
+
+// exportSecrets adds the user's secrets to their export when they are explicitly requested. Since the export
+// contains the decrypted values, users can only export their own secrets.
+func exportSecrets(c *rest.Context, u *database.User, opath string, zipWriter *zip.Writer, opt *plugins.ExportUserOptions) error {
+	if opt == nil || !opt.IncludeSecrets {
+		return nil
+	}
+	if c.DB.Type() != database.AdminType && c.DB.ID() != *u.UserName {
+		return errors.New("access_denied: Users can only export their own secrets")
+	}
+	adb := c.DB.AdminDB()
+	var res []struct {
+		Namespace string
+		Key       string
+		Value     string
+	}
+	if err := adb.Select(&res, "SELECT namespace,key,value FROM kv_user_secret WHERE user=?", *u.UserName); err != nil {
+		return err
+	}
+	secrets := make(map[string]map[string]interface{})
+	for _, r := range res {
+		s := &Secrets{DB: adb, Namespace: r.Namespace}
+		v, err := s.decrypt(r.Key, r.Value)
+		if err != nil {
+			return err
+		}
+		if _, ok := secrets[r.Namespace]; !ok {
+			secrets[r.Namespace] = make(map[string]interface{})
+		}
+		secrets[r.Namespace][r.Key] = v
+	}
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	f, err := zipWriter.Create(filepath.Join(opath, "secrets.json"))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return err
+}
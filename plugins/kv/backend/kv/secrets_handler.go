@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/heedy/heedy/api/golang/rest"
+)
+
+// GenerateSecretsHandler returns the handler of the secrets of users, apps or objects. It mirrors the kv handler,
+// but secrets can only be read by the plugin that owns their namespace.
+func GenerateSecretsHandler(authenticator func(ctx *rest.Context, id string, namespace string) (*Secrets, error)) *chi.Mux {
+	smux := chi.NewMux()
+
+	getauth := func(r *http.Request) (*Secrets, error) {
+		ctx := rest.CTX(r)
+		id, err := rest.URLParam(r, "id", nil)
+		namespace, err := rest.URLParam(r, "namespace", err)
+		if err != nil {
+			return nil, err
+		}
+		return authenticator(ctx, id, namespace)
+	}
+
+	smux.Get("/{id}/{namespace}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := getauth(r)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusForbidden, err)
+			return
+		}
+		if !s.CanRead {
+			// Anyone that can write secrets can see which ones are set
+			keys, err := s.Keys()
+			rest.WriteJSON(w, r, keys, err)
+			return
+		}
+		m, err := s.Get()
+		rest.WriteJSON(w, r, m, err)
+	}))
+
+	smux.Get("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := getauth(r)
+		key, err := rest.URLParam(r, "key", err)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusForbidden, err)
+			return
+		}
+		if !s.CanRead {
+			rest.WriteJSONError(w, r, http.StatusForbidden, ErrSecretAccess)
+			return
+		}
+		v, err := s.GetKey(key)
+		rest.WriteJSON(w, r, v, err)
+	}))
+
+	smux.Post("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := getauth(r)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusForbidden, err)
+			return
+		}
+		var v interface{}
+		err = rest.UnmarshalRequest(r, &v)
+		key, err := rest.URLParam(r, "key", err)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		rest.WriteResult(w, r, s.SetKey(key, v))
+	}))
+
+	smux.Delete("/{id}/{namespace}/{key}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := getauth(r)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusForbidden, err)
+			return
+		}
+		key, err := rest.URLParam(r, "key", nil)
+		if err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		rest.WriteResult(w, r, s.DelKey(key))
+	}))
+
+	return smux
+}
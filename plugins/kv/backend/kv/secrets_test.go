@@ -0,0 +1,145 @@
+package kv
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins"
+)
+
+func TestSecrets(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	name := "other"
+	passwd := "test"
+	require.NoError(t, db.CreateUser(&database.User{
+		UserName: &name,
+		Password: &passwd,
+	}))
+
+	userCtx := &rest.Context{DB: database.NewUserDB(db, "test")}
+	otherCtx := &rest.Context{DB: database.NewUserDB(db, "other")}
+	pluginCtx := &rest.Context{DB: database.NewUserDB(db, "test"), Plugin: "fitbit"}
+	wrongPluginCtx := &rest.Context{DB: db, Plugin: "other"}
+	coreCtx := &rest.Context{DB: database.NewUserDB(db, "test"), Plugin: "heedy"}
+
+	// Users can set their own secrets, but can't read them
+	s, err := UserSecrets(userCtx, "test", "fitbit")
+	require.NoError(t, err)
+	require.False(t, s.CanRead)
+	require.NoError(t, s.SetKey("refresh_token", "mytoken"))
+	_, err = s.GetKey("refresh_token")
+	require.Error(t, err)
+	_, err = s.Get()
+	require.Error(t, err)
+	keys, err := s.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"refresh_token"}, keys)
+
+	_, err = UserSecrets(otherCtx, "test", "fitbit")
+	require.Error(t, err)
+
+	// The value is encrypted in the database
+	var stored string
+	require.NoError(t, db.Get(&stored, "SELECT value FROM kv_user_secret WHERE user='test'"))
+	require.NotContains(t, stored, "mytoken")
+	_, err = os.Stat(path.Join(db.Assets().FolderPath, SecretsKeyFile))
+	require.NoError(t, err)
+
+	// Only the plugin that owns the namespace can read the secrets, even when it acts as the user
+	s, err = UserSecrets(pluginCtx, "test", "fitbit")
+	require.NoError(t, err)
+	v, err := s.GetKey("refresh_token")
+	require.NoError(t, err)
+	require.Equal(t, "mytoken", v)
+	v, err = s.GetKey("notakey")
+	require.NoError(t, err)
+	require.Nil(t, v)
+	require.NoError(t, s.SetKey("client", map[string]interface{}{"id": "abc"}))
+	m, err := s.Get()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"refresh_token": "mytoken", "client": map[string]interface{}{"id": "abc"}}, m)
+
+	// Other plugins can only change the namespace's secrets when acting as the owner
+	for _, ctx := range []*rest.Context{wrongPluginCtx, {DB: database.NewUserDB(db, "other"), Plugin: "other"}} {
+		_, err = UserSecrets(ctx, "test", "fitbit")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "access_denied")
+	}
+	secretsHandler := GenerateSecretsHandler(UserSecrets)
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		wrongPluginCtx.Log = logrus.NewEntry(logrus.StandardLogger())
+		req := httptest.NewRequest(method, "/test/fitbit/refresh_token", strings.NewReader(`"othertoken"`))
+		req = req.WithContext(context.WithValue(req.Context(), rest.HeedyContext, wrongPluginCtx))
+		rec := httptest.NewRecorder()
+		secretsHandler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "access_denied")
+	}
+	s, err = UserSecrets(pluginCtx, "test", "fitbit")
+	require.NoError(t, err)
+	v, err = s.GetKey("refresh_token")
+	require.NoError(t, err)
+	require.Equal(t, "mytoken", v)
+
+	for _, ctx := range []*rest.Context{{DB: database.NewUserDB(db, "test"), Plugin: "other"}, coreCtx} {
+		s, err = UserSecrets(ctx, "test", "fitbit")
+		require.NoError(t, err)
+		_, err = s.GetKey("refresh_token")
+		require.Error(t, err)
+	}
+
+	// An encrypted value can't be moved to another key
+	_, err = db.Exec("UPDATE kv_user_secret SET value=? WHERE user='test' AND key='client'", stored)
+	require.NoError(t, err)
+	s, err = UserSecrets(pluginCtx, "test", "fitbit")
+	require.NoError(t, err)
+	_, err = s.GetKey("client")
+	require.Error(t, err)
+	require.NoError(t, s.DelKey("client"))
+	require.Error(t, s.DelKey("client"))
+
+	// Secrets are only exported when requested, and only by their user
+	export := func(ctx *rest.Context, includeSecrets bool) (*zip.Reader, error) {
+		u, err := db.ReadUser("test", nil)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		err = exportSecrets(ctx, u, "/", zw, &plugins.ExportUserOptions{IncludeSecrets: includeSecrets})
+		require.NoError(t, zw.Close())
+		if err != nil {
+			return nil, err
+		}
+		return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	}
+	zr, err := export(userCtx, false)
+	require.NoError(t, err)
+	require.Len(t, zr.File, 0)
+	_, err = export(otherCtx, true)
+	require.Error(t, err)
+	zr, err = export(userCtx, true)
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	f, err := zr.File[0].Open()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	var exported map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &exported))
+	require.Equal(t, map[string]map[string]interface{}{"fitbit": {"refresh_token": "mytoken"}}, exported)
+}
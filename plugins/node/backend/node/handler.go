@@ -0,0 +1,163 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins/run"
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+)
+
+type NodeSettings struct {
+	// Exec runs the node processes of heedy's runners
+	Exec *run.ExecHandler `mapstructure:"-"`
+
+	IsEnabled bool              `mapstructure:"-"`
+	DB        *database.AdminDB `mapstructure:"-"`
+
+	Path         string   `mapstructure:"path"`
+	NpmPath      string   `mapstructure:"npm_path"`
+	NpmArgs      []string `mapstructure:"npm_args"`
+	ValidateNode bool     `mapstructure:"validate_node"`
+}
+
+var (
+	l        = logrus.WithField("plugin", "node:backend")
+	settings = NodeSettings{
+		Exec: run.NewExecHandler(nil),
+	}
+)
+
+// Start checks the currently set node path to make sure that it is valid
+func Start(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+	settings.DB = db
+	settings.Exec.DB = db
+
+	nodeplugin, ok := db.Assets().Config.Plugins["node"]
+	if !ok {
+		return errors.New("Could not find node plugin configuration")
+	}
+
+	err := mapstructure.Decode(nodeplugin.Config, &settings)
+	if err != nil {
+		return err
+	}
+
+	if settings.Path == "" {
+		// Node is not set up, so don't do anything
+		l.Debug("Node is not set up")
+		return nil
+	}
+	if settings.ValidateNode {
+		err = ValidateNode(settings.Path)
+		settings.IsEnabled = err == nil
+		if err != nil {
+			return err
+		}
+	} else {
+		settings.IsEnabled = true
+	}
+	if settings.NpmPath == "" {
+		// npm is only needed for plugins with a package.json, so a missing npm is reported when it is used
+		settings.NpmPath, _ = NpmPath(settings.Path)
+	}
+
+	return nil
+}
+
+func StartNodeProcess(w http.ResponseWriter, r *http.Request) {
+	if !settings.IsEnabled {
+		rest.WriteJSONError(w, r, http.StatusFailedDependency, errors.New("No valid node.js interpreter is set up. Please check your heedy configuration."))
+		return
+	}
+	var i run.Info
+	err := rest.UnmarshalRequest(r, &i)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var rs struct {
+		Path string   `mapstructure:"path"`
+		Args []string `mapstructure:"args,omitempty"`
+		API  string   `mapstructure:"api,omitempty"`
+	}
+
+	if err = mapstructure.Decode(i.Run.Config, &rs); err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	fp := path.Join(i.PluginDir, rs.Path)
+	_, err = os.Stat(fp)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	packageFolder := filepath.Dir(fp)
+	needsInstall, err := NeedsInstall(packageFolder)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if needsInstall {
+		if settings.NpmPath == "" {
+			rest.WriteJSONError(w, r, http.StatusFailedDependency, errors.New("npm was not found, so the plugin's packages can't be installed. Please set npm_path in the node plugin's configuration."))
+			return
+		}
+		if err = EnsurePackages(settings.NpmPath, packageFolder); err != nil {
+			rest.WriteJSONError(w, r, http.StatusBadRequest, fmt.Errorf("Failed to install plugin packages: %w", err))
+			return
+		}
+	}
+
+	// Run the actual code now
+	err = settings.Exec.StartCommand(&i, append([]string{settings.Path, rs.Path}, rs.Args...), rs.API)
+	rest.WriteJSON(w, r, run.StartMessage{API: rs.API}, err)
+}
+
+func StopNode(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("kill") == "true" {
+		KillNode(w, r)
+		return
+	}
+	apikey, err := rest.URLParam(r, "apikey", nil)
+	if err == nil {
+		err = settings.Exec.Stop(apikey)
+	}
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	rest.WriteResult(w, r, nil)
+}
+
+func KillNode(w http.ResponseWriter, r *http.Request) {
+	apikey, err := rest.URLParam(r, "apikey", nil)
+	if err == nil {
+		err = settings.Exec.Kill(apikey)
+	}
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	rest.WriteResult(w, r, nil)
+}
+
+// Handler is the main API handler
+var Handler = func() *chi.Mux {
+	mux := chi.NewMux()
+	mux.NotFound(rest.NotFoundHandler)
+	mux.MethodNotAllowed(rest.NotFoundHandler)
+	mux.Post("/runtypes/node", StartNodeProcess)
+	mux.Delete("/runtypes/node/{apikey}", StopNode)
+	return mux
+}()
@@ -0,0 +1,165 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/heedy/heedy/backend/plugins/run"
+	"github.com/sirupsen/logrus"
+)
+
+const testScript = `
+const major = parseInt(process.versions.node.split(".")[0]);
+if (major < 14) {
+	console.log("Heedy's node support requires at least node 14");
+	process.exit(1);
+}
+console.log("OK");
+`
+
+// Paths to search for the executable. These are commonly used names when node is in PATH
+var PathNames = []string{"node", "nodejs"}
+
+// Lockfiles that allow installing the exact dependencies with npm ci
+var Lockfiles = []string{"package-lock.json", "npm-shrinkwrap.json"}
+
+// installStamp is written to node_modules after installing dependencies, and holds the hash
+// of the package.json and lockfile used for the install
+const installStamp = ".heedy-install"
+
+// SearchNode finds a valid installed node version
+func SearchNode() (string, error) {
+	logrus.Debug("Searching for compatible node interpreter")
+	for i := range PathNames {
+		exepath, err := exec.LookPath(PathNames[i])
+		if err == nil {
+			err = ValidateNode(exepath)
+			if err == nil {
+				return filepath.Abs(exepath)
+			}
+			logrus.Debug(err)
+		}
+	}
+	return "", errors.New("No supported node.js found")
+}
+
+// ValidateNode checks if the given node executable satisfies all requirements
+func ValidateNode(exepath string) error {
+	if _, err := exec.LookPath(exepath); err != nil {
+		return fmt.Errorf("node.js was not found at %s", exepath)
+	}
+	if settings.DB != nil && settings.DB.Verbose {
+		logrus.Debugf("Checking node at %s with script: %s", exepath, testScript)
+	} else {
+		logrus.Debugf("Checking node at %s", exepath)
+	}
+	testResult, err := exec.Command(exepath, "-e", testScript).CombinedOutput()
+	if err != nil {
+		if len(testResult) > 0 {
+			return fmt.Errorf("node.js at %s not supported: %s, (%w)", exepath, strings.TrimSpace(string(testResult)), err)
+		}
+		return err
+	}
+	cmdout := strings.TrimSpace(string(testResult))
+	if cmdout != "OK" {
+		return fmt.Errorf("node.js at %s not supported: %s", exepath, cmdout)
+	}
+	return nil
+}
+
+// NpmPath returns the npm executable to use with the given node. It prefers the npm installed
+// alongside node, and falls back to the npm in PATH.
+func NpmPath(nodepath string) (string, error) {
+	npmname := "npm"
+	if runtime.GOOS == "windows" {
+		npmname = "npm.cmd"
+	}
+	npmpath := path.Join(filepath.Dir(nodepath), npmname)
+	if _, err := os.Stat(npmpath); err == nil {
+		return npmpath, nil
+	}
+	npmpath, err := exec.LookPath(npmname)
+	if err != nil {
+		return "", fmt.Errorf("npm was not found alongside node at %s, or in PATH", nodepath)
+	}
+	return npmpath, nil
+}
+
+func RunCommand(dir string, exepath string, args []string) error {
+	return run.RunCommand(settings.DB, "node.log", dir, exepath, args)
+}
+
+// packageHash returns a hash of the package.json and lockfile in the given folder, along with the name of
+// the lockfile, which is empty if there is no lockfile.
+func packageHash(folder string) (string, string, error) {
+	h := sha256.New()
+	b, err := ioutil.ReadFile(path.Join(folder, "package.json"))
+	if err != nil {
+		return "", "", err
+	}
+	h.Write(b)
+	lockfile := ""
+	for _, lf := range Lockfiles {
+		b, err = ioutil.ReadFile(path.Join(folder, lf))
+		if err == nil {
+			lockfile = lf
+			h.Write([]byte(lf))
+			h.Write(b)
+			break
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), lockfile, nil
+}
+
+// NeedsInstall returns whether the dependencies in the folder's package.json need to be installed.
+// Dependencies are installed if node_modules is missing, or if the package.json or lockfile changed
+// since the last install.
+func NeedsInstall(folder string) (bool, error) {
+	hash, _, err := packageHash(folder)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No package.json, so nothing to install
+			return false, nil
+		}
+		return false, err
+	}
+	b, err := ioutil.ReadFile(path.Join(folder, "node_modules", installStamp))
+	if err != nil {
+		return true, nil
+	}
+	return strings.TrimSpace(string(b)) != hash, nil
+}
+
+// EnsurePackages installs the dependencies in the folder's package.json, if they are not already installed.
+// If there is a lockfile, npm ci is used to install the exact locked versions. Otherwise, npm install is run.
+func EnsurePackages(npmpath, folder string) error {
+	needsInstall, err := NeedsInstall(folder)
+	if err != nil || !needsInstall {
+		return err
+	}
+	hash, lockfile, err := packageHash(folder)
+	if err != nil {
+		return err
+	}
+	args := []string{"install"}
+	if lockfile != "" {
+		args = []string{"ci"}
+	}
+	l.Debugf("Installing dependencies from %s", path.Join(folder, "package.json"))
+	if err = RunCommand(folder, npmpath, append(args, settings.NpmArgs...)); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Join(folder, "node_modules"), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(folder, "node_modules", installStamp), []byte(hash), 0644)
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNode(t *testing.T) {
+	require.Error(t, ValidateNode("/path/to/nonexistent/node"))
+
+	nodepath, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	require.NoError(t, ValidateNode(nodepath))
+}
+
+func TestNeedsInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-node-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Nothing to install without a package.json
+	ni, err := NeedsInstall(dir)
+	require.NoError(t, err)
+	require.False(t, ni)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "package.json"), []byte(`{"name": "test"}`), 0644))
+	ni, err = NeedsInstall(dir)
+	require.NoError(t, err)
+	require.True(t, ni)
+
+	hash, lockfile, err := packageHash(dir)
+	require.NoError(t, err)
+	require.Equal(t, "", lockfile)
+	require.NoError(t, os.MkdirAll(path.Join(dir, "node_modules"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "node_modules", installStamp), []byte(hash), 0644))
+	ni, err = NeedsInstall(dir)
+	require.NoError(t, err)
+	require.False(t, ni)
+
+	// Adding a lockfile changes the hash, so packages are reinstalled with npm ci
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "package-lock.json"), []byte(`{"lockfileVersion": 2}`), 0644))
+	ni, err = NeedsInstall(dir)
+	require.NoError(t, err)
+	require.True(t, ni)
+	_, lockfile, err = packageHash(dir)
+	require.NoError(t, err)
+	require.Equal(t, "package-lock.json", lockfile)
+}
@@ -0,0 +1,48 @@
+package node
+
+import (
+	"path"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins/run"
+	"github.com/sirupsen/logrus"
+)
+
+const PluginName = "node"
+
+// setupDefaultNode searches the system for a supported node interpreter,
+// so that heedy can automatically run node plugins without requiring manual
+// intervention. No packages are installed until a plugin requiring them is run.
+func setupDefaultNode(db *database.AdminDB) error {
+	nodepath, err := SearchNode()
+	if err != nil {
+		logrus.Warn("No supported node.js interpreter found - you will need to configure one manually to use node plugins.")
+		return nil
+	}
+	a := db.Assets()
+	logrus.Infof("Using node from %s", nodepath)
+	return assets.WriteConfig(path.Join(a.FolderPath, "heedy.conf"), &assets.Configuration{
+		Plugins: map[string]*assets.Plugin{
+			"node": &assets.Plugin{
+				Config: map[string]interface{}{
+					"path": nodepath,
+				},
+			},
+		},
+	})
+}
+
+// This is not needed for normal plugins. The init simply registers the plugin with heedy internals
+// for when it is compiled directly into the main heedy executable.
+func init() {
+
+	run.Builtin.Add(&run.BuiltinRunner{
+		Key:     PluginName,
+		Start:   Start,
+		Handler: Handler,
+	})
+
+	// When creating the database, try to find a supported interpreter
+	database.AddCreateHook(setupDefaultNode)
+}
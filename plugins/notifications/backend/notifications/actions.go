@@ -0,0 +1,226 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/assets"
+)
+
+// ActionEvent is the data of the notification_action event, which is fired when a notification's action is run
+type ActionEvent struct {
+	Key    string      `json:"key"`
+	Action int         `json:"action"`
+	Title  string      `json:"title"`
+	By     string      `json:"by"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Schema returns the json schema of the action's form, or nil if the form has no schema. Just like in the frontend,
+// a form schema without a type is treated as the properties of an object.
+func (a *Action) Schema() (*gojsonschema.Schema, error) {
+	if len(a.FormSchema) == 0 {
+		return nil, nil
+	}
+	if _, ok := a.FormSchema["const"]; ok {
+		return nil, nil
+	}
+	s := a.FormSchema
+	if _, ok := s["type"]; !ok {
+		props := make(map[string]interface{})
+		s = map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+		}
+		for k, v := range a.FormSchema {
+			if k == "required" {
+				s["required"] = v
+			} else {
+				props[k] = v
+			}
+		}
+	}
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(s))
+}
+
+// FormData returns the data to post for the action, given the submitted body
+func (a *Action) FormData(body []byte) (interface{}, error) {
+	if c, ok := a.FormSchema["const"]; ok {
+		// The action always posts its constant data
+		return c, nil
+	}
+	var data interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("bad_request: %w", err)
+		}
+	}
+	s, err := a.Schema()
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		res, err := s.Validate(gojsonschema.NewGoLoader(data))
+		if err != nil {
+			return nil, err
+		}
+		if !res.Valid() {
+			return nil, fmt.Errorf("bad_request: %s", res.Errors()[0].String())
+		}
+	}
+	return data, nil
+}
+
+// encodeForm encodes the data as multipart/form-data. Just like in the frontend, string values are written as-is,
+// and all other values are written as json.
+func encodeForm(data interface{}) ([]byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if data != nil {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, "", errors.New("bad_request: form-data actions must be given an object")
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, ok := m[k].(string)
+			if !ok {
+				b, err := json.Marshal(m[k])
+				if err != nil {
+					return nil, "", err
+				}
+				v = string(b)
+			}
+			if err := mw.WriteField(k, v); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// RunAction submits the given data to a notification's post action, as the caller. The data is validated against
+// the action's form schema, and forwarded to the action's href, which must be a heedy route. The response of the
+// href is returned.
+func RunAction(c *rest.Context, o *NotificationsQuery, key string, index int, body []byte) (*bytes.Buffer, error) {
+	if o == nil {
+		o = &NotificationsQuery{}
+	}
+	o.Key = &key
+	nl, err := ReadNotifications(c.DB, o)
+	if err != nil {
+		return nil, err
+	}
+	if len(nl) == 0 {
+		return nil, errors.New("not_found: The notification was not found")
+	}
+	if len(nl) > 1 {
+		return nil, errors.New("bad_request: Multiple notifications have the given key, specify its user, app or object")
+	}
+	n := &nl[0]
+	if n.Actions == nil || index < 0 || index >= len(*n.Actions) {
+		return nil, errors.New("not_found: The notification has no such action")
+	}
+	a := &(*n.Actions)[index]
+	if a.Type != "post" && !strings.HasPrefix(a.Type, "post/") {
+		return nil, errors.New("bad_request: Only post actions can be submitted")
+	}
+	if !strings.HasPrefix(a.Href, "/") || strings.HasPrefix(a.Href, "//") {
+		return nil, errors.New("bad_request: Actions can only be submitted to heedy routes")
+	}
+
+	data, err := a.FormData(body)
+	if err != nil {
+		return nil, err
+	}
+	var postBody interface{}
+	headers := map[string]string{}
+	if a.Type == "post/form-data" {
+		b, contentType, err := encodeForm(data)
+		if err != nil {
+			return nil, err
+		}
+		postBody = b
+		headers["Content-Type"] = contentType
+	} else if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		postBody = b
+		headers["Content-Type"] = "application/json"
+	}
+
+	res, err := c.RequestBuffer(c, http.MethodPost, a.Href, postBody, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Dismiss {
+		if err = dismissNotification(c.DB.AdminDB(), n); err != nil {
+			return nil, err
+		}
+	}
+	c.Events.Fire(targetEvent("notification_action", n, &ActionEvent{
+		Key:    n.Key,
+		Action: index,
+		Title:  a.Title,
+		By:     c.DB.ID(),
+		Data:   data,
+	}))
+	return res, nil
+}
+
+func runAction(w http.ResponseWriter, r *http.Request) {
+	c := rest.CTX(r)
+	key, err := rest.URLParam(r, "key", nil)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("bad_request: The action index must be an integer"))
+		return
+	}
+	var o NotificationsQuery
+	if err = rest.QueryDecoder.Decode(&o, r.URL.Query()); err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, assets.Config().GetRequestBodyByteLimit()))
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	res, err := RunAction(c, &o, key, index, body)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !json.Valid(res.Bytes()) {
+		rest.WriteResult(w, r, nil)
+		return
+	}
+	rest.WriteJSON(w, r, json.RawMessage(res.Bytes()), nil)
+}
@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+type testPost struct {
+	Path   string
+	Header map[string]string
+	Body   []byte
+}
+
+// testRequester records the requests that are forwarded by actions
+type testRequester struct {
+	posts []testPost
+}
+
+func (tr *testRequester) Request(c *rest.Context, method, path string, body interface{}, header map[string]string) (io.ReadCloser, error) {
+	b, err := tr.RequestBuffer(c, method, path, body, header)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(b), nil
+}
+
+func (tr *testRequester) RequestBuffer(c *rest.Context, method, path string, body interface{}, header map[string]string) (*bytes.Buffer, error) {
+	if path == "/api/fail" {
+		return nil, errors.New("plugin_error: failed")
+	}
+	b, _ := body.([]byte)
+	tr.posts = append(tr.posts, testPost{Path: path, Header: header, Body: b})
+	return bytes.NewBufferString(`{"answer":42}`), nil
+}
+
+type testEvents struct {
+	events []*events.Event
+}
+
+func (te *testEvents) Fire(e *events.Event) {
+	te.events = append(te.events, e)
+}
+
+func TestRunAction(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	tr := &testRequester{}
+	te := &testEvents{}
+	c := &rest.Context{
+		Requester: tr,
+		DB:        database.NewUserDB(db, "test"),
+		Events:    te,
+	}
+
+	user := "test"
+	title := "Rate your day"
+	actions := ActionArray{
+		{Title: "Link", Href: "#/somewhere"},
+		{Title: "Rate", Href: "/api/rating", Type: "post/json", FormSchema: map[string]interface{}{
+			"rating":   map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 5},
+			"required": []interface{}{"rating"},
+		}},
+		{Title: "Great", Href: "/api/rating", FormSchema: map[string]interface{}{"const": map[string]interface{}{"rating": 5}}, Dismiss: true},
+		{Title: "Upload", Href: "/api/upload", Type: "post/form-data", FormSchema: map[string]interface{}{
+			"name":  map[string]interface{}{"type": "string"},
+			"count": map[string]interface{}{"type": "integer"},
+		}},
+		{Title: "Fail", Href: "/api/fail", Type: "post/json", Dismiss: true},
+		{Title: "Outside", Href: "https://example.com", Type: "post/json"},
+	}
+	require.NoError(t, WriteNotification(db, &Notification{Key: "rate", Title: &title, User: &user, Actions: &actions}))
+
+	// Only post actions that exist can be run
+	_, err := RunAction(c, nil, "notakey", 1, nil)
+	require.Error(t, err)
+	_, err = RunAction(c, nil, "rate", 6, nil)
+	require.Error(t, err)
+	_, err = RunAction(c, nil, "rate", 0, nil)
+	require.Error(t, err)
+	_, err = RunAction(c, nil, "rate", 5, nil)
+	require.Error(t, err)
+
+	// The form is validated against the schema
+	_, err = RunAction(c, nil, "rate", 1, []byte(`{"rating": 10}`))
+	require.Error(t, err)
+	_, err = RunAction(c, nil, "rate", 1, []byte(`{}`))
+	require.Error(t, err)
+	require.Len(t, tr.posts, 0)
+	require.Len(t, te.events, 0)
+
+	res, err := RunAction(c, nil, "rate", 1, []byte(`{"rating": 3}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"answer":42}`, res.String())
+	require.Len(t, tr.posts, 1)
+	require.Equal(t, "/api/rating", tr.posts[0].Path)
+	require.Equal(t, "application/json", tr.posts[0].Header["Content-Type"])
+	require.JSONEq(t, `{"rating": 3}`, string(tr.posts[0].Body))
+	require.Len(t, te.events, 1)
+	require.Equal(t, "notification_action", te.events[0].Event)
+	require.Equal(t, "test", te.events[0].User)
+	ae := te.events[0].Data.(*ActionEvent)
+	require.Equal(t, "rate", ae.Key)
+	require.Equal(t, 1, ae.Action)
+	require.Equal(t, "test", ae.By)
+
+	// form-data actions are posted as multipart forms
+	_, err = RunAction(c, nil, "rate", 3, []byte(`{"name": "me", "count": 2}`))
+	require.NoError(t, err)
+	require.Len(t, tr.posts, 2)
+	_, params, err := mime.ParseMediaType(tr.posts[1].Header["Content-Type"])
+	require.NoError(t, err)
+	form, err := multipart.NewReader(bytes.NewReader(tr.posts[1].Body), params["boundary"]).ReadForm(1 << 20)
+	require.NoError(t, err)
+	require.Equal(t, []string{"me"}, form.Value["name"])
+	require.Equal(t, []string{"2"}, form.Value["count"])
+
+	// A failed post doesn't dismiss the notification
+	_, err = RunAction(c, nil, "rate", 4, nil)
+	require.Error(t, err)
+	require.Len(t, te.events, 2)
+
+	// Constant actions ignore the body, and dismissing the notification deletes it
+	_, err = RunAction(c, nil, "rate", 2, []byte(`{"rating": 1}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"rating": 5}`, string(tr.posts[2].Body))
+	var data map[string]interface{}
+	b, err := json.Marshal(te.events[2].Data)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &data))
+	require.Equal(t, "Great", data["title"])
+
+	n, err := ReadNotifications(db, &NotificationsQuery{User: &user})
+	require.NoError(t, err)
+	require.Len(t, n, 0)
+}
@@ -0,0 +1,487 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+// SMTPSettings is the server used to send notifications by email
+type SMTPSettings struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+}
+
+// DeliverySettings are the server-wide settings for delivering notifications outside of heedy,
+// set in the notifications plugin's config
+type DeliverySettings struct {
+	SMTP     SMTPSettings        `mapstructure:"smtp"`
+	Channels []string            `mapstructure:"channels"`
+	Commands map[string][]string `mapstructure:"commands"`
+	Timeout  string              `mapstructure:"delivery_timeout"`
+
+	// By default, the webhook, ntfy and gotify channels can't make requests to loopback, private
+	// or link-local addresses, so that users can't reach services on heedy's network
+	AllowPrivateURLs bool `mapstructure:"allow_private_urls"`
+}
+
+// DeliveryRule is a user's rule for forwarding notifications to a delivery channel.
+// The rules are set in the "delivery" user setting of the notifications plugin.
+type DeliveryRule struct {
+	// Only notifications of the given type are forwarded. All notifications are forwarded if empty.
+	Type    string `mapstructure:"type" json:"type,omitempty"`
+	Channel string `mapstructure:"channel" json:"channel"`
+
+	To      string `mapstructure:"to" json:"to,omitempty"`
+	URL     string `mapstructure:"url" json:"url,omitempty"`
+	Topic   string `mapstructure:"topic" json:"topic,omitempty"`
+	Token   string `mapstructure:"token" json:"token,omitempty"`
+	Command string `mapstructure:"command" json:"command,omitempty"`
+}
+
+// Matches returns whether the rule forwards the given notification
+func (r *DeliveryRule) Matches(n *Notification) bool {
+	return r.Type == "" || n.Type != nil && *n.Type == r.Type
+}
+
+// Channel delivers notifications to a destination outside of heedy
+type Channel interface {
+	Deliver(r *DeliveryRule, n *Notification) error
+}
+
+// Delivery forwards newly created notifications to the delivery channels chosen by each user's rules
+type Delivery struct {
+	DB       *database.AdminDB
+	Channels map[string]Channel
+
+	// Notifications waiting to be delivered by the workers
+	queue chan *Notification
+}
+
+var (
+	deliveryLock sync.Mutex
+	delivery     *Delivery
+
+	// The number of notifications delivered at the same time
+	deliveryWorkers = 4
+	// The number of notifications that can wait for delivery. Notifications beyond this are dropped.
+	deliveryQueueSize = 1000
+)
+
+// publicAddressOnly is the Control function of a dialer that refuses to connect to loopback, private,
+// link-local or unspecified addresses. It checks the resolved address, so it can't be bypassed
+// with a domain name that resolves to a private address.
+func publicAddressOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("access_denied: notifications can't be delivered to the private address %s", host)
+	}
+	return nil
+}
+
+// NewDelivery sets up the channels enabled in the given settings
+func NewDelivery(db *database.AdminDB, s *DeliverySettings) (*Delivery, error) {
+	timeout := 10 * time.Second
+	if s.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return nil, fmt.Errorf("Invalid delivery_timeout: %w", err)
+		}
+	}
+	client := &http.Client{Timeout: timeout}
+	if !s.AllowPrivateURLs {
+		client.Transport = &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: timeout, Control: publicAddressOnly}).DialContext,
+			TLSHandshakeTimeout: timeout,
+		}
+	}
+	available := map[string]Channel{
+		"email":   &EmailChannel{SMTP: s.SMTP, Timeout: timeout},
+		"webhook": &WebhookChannel{Client: client},
+		"ntfy":    &NtfyChannel{Client: client},
+		"gotify":  &GotifyChannel{Client: client},
+		"command": &CommandChannel{Commands: s.Commands, Timeout: timeout},
+	}
+	d := &Delivery{
+		DB:       db,
+		Channels: make(map[string]Channel),
+	}
+	for _, c := range s.Channels {
+		ch, ok := available[c]
+		if !ok {
+			return nil, fmt.Errorf("Unrecognized notification delivery channel '%s'", c)
+		}
+		d.Channels[c] = ch
+	}
+	return d, nil
+}
+
+// StartDelivery starts forwarding notifications, using the settings in the notifications plugin's config
+func StartDelivery(db *database.AdminDB) error {
+	var s DeliverySettings
+	if p, ok := db.Assets().Config.Plugins[PluginName]; ok {
+		if err := mapstructure.Decode(p.Config, &s); err != nil {
+			return err
+		}
+	}
+	d, err := NewDelivery(db, &s)
+	if err != nil {
+		return err
+	}
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	if delivery != nil {
+		events.RemoveHandler(delivery)
+		delivery.stop()
+	}
+	d.start()
+	delivery = d
+	events.AddHandler(d)
+	return nil
+}
+
+// StopDelivery stops forwarding notifications
+func StopDelivery() {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	if delivery != nil {
+		events.RemoveHandler(delivery)
+		delivery.stop()
+		delivery = nil
+	}
+}
+
+// start starts the workers that deliver queued notifications
+func (d *Delivery) start() {
+	d.queue = make(chan *Notification, deliveryQueueSize)
+	for i := 0; i < deliveryWorkers; i++ {
+		go func() {
+			for n := range d.queue {
+				d.deliver(n)
+			}
+		}()
+	}
+}
+
+// stop stops the workers once they deliver the notifications that are already queued.
+// The delivery must no longer receive events.
+func (d *Delivery) stop() {
+	close(d.queue)
+}
+
+// currentDelivery returns the running delivery, or nil if notifications are not being delivered
+func currentDelivery() *Delivery {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	return delivery
+}
+
+// Fire forwards the notifications of *_notification_create events
+func (d *Delivery) Fire(e *events.Event) {
+	if !strings.HasSuffix(e.Event, "_notification_create") {
+		return
+	}
+	n, ok := e.Data.(*Notification)
+	if !ok || n.User == nil {
+		return
+	}
+	// The event is fired from within database hooks, so the notification is delivered in the background
+	select {
+	case d.queue <- n:
+	default:
+		logrus.WithField("plugin", PluginName).Warnf("Notification delivery queue is full, dropping notification %s to user %s", n.Key, *n.User)
+	}
+}
+
+// deliver sends the notification to the delivery channels of its user's rules
+func (d *Delivery) deliver(n *Notification) {
+	rules, err := d.Rules(*n.User)
+	if err == nil {
+		err = d.Send(rules, n)
+	}
+	if err != nil {
+		logrus.WithField("plugin", PluginName).Warnf("Failed to deliver notification %s to user %s: %s", n.Key, *n.User, err)
+	}
+}
+
+// Rules returns the user's delivery rules
+func (d *Delivery) Rules(user string) ([]DeliveryRule, error) {
+	s, err := d.DB.ReadUserPluginSettings(user, PluginName)
+	if err != nil {
+		return nil, err
+	}
+	var rules []DeliveryRule
+	err = mapstructure.Decode(s["delivery"], &rules)
+	return rules, err
+}
+
+// Send delivers the notification through each of the rules that match it.
+// All matching rules are attempted, and the first error is returned.
+func (d *Delivery) Send(rules []DeliveryRule, n *Notification) error {
+	var rerr error
+	for i := range rules {
+		if !rules[i].Matches(n) {
+			continue
+		}
+		ch, ok := d.Channels[rules[i].Channel]
+		if !ok {
+			err := fmt.Errorf("bad_request: notification delivery channel '%s' is not enabled", rules[i].Channel)
+			if rerr == nil {
+				rerr = err
+			}
+			continue
+		}
+		if err := ch.Deliver(&rules[i], n); err != nil && rerr == nil {
+			rerr = fmt.Errorf("%s: %w", rules[i].Channel, err)
+		}
+	}
+	return rerr
+}
+
+func notificationType(n *Notification) string {
+	if n.Type == nil || *n.Type == "" {
+		return "info"
+	}
+	return *n.Type
+}
+
+func notificationTitle(n *Notification) string {
+	if n.Title == nil {
+		return n.Key
+	}
+	return *n.Title
+}
+
+// notificationMessage returns the body of the message sent for the notification
+func notificationMessage(n *Notification) string {
+	if n.Description == nil || *n.Description == "" {
+		return notificationTitle(n)
+	}
+	return *n.Description
+}
+
+// headerValue makes the given string safe to use in a mail or http header
+func headerValue(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// EmailChannel sends notifications by email, using the server's SMTP settings
+type EmailChannel struct {
+	SMTP    SMTPSettings
+	Timeout time.Duration
+}
+
+func (c *EmailChannel) Deliver(r *DeliveryRule, n *Notification) error {
+	if c.SMTP.Host == "" || c.SMTP.From == "" {
+		return errors.New("bad_request: email delivery is not configured on this server")
+	}
+	if r.To == "" || strings.ContainsAny(r.To, "\r\n") {
+		return errors.New("bad_request: invalid email address")
+	}
+	port := c.SMTP.Port
+	if port == 0 {
+		port = 587
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.SMTP.Host, strconv.Itoa(port)), c.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	sc, err := smtp.NewClient(conn, c.SMTP.Host)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	if ok, _ := sc.Extension("STARTTLS"); ok {
+		if err = sc.StartTLS(&tls.Config{ServerName: c.SMTP.Host}); err != nil {
+			return err
+		}
+	}
+	if c.SMTP.Username != "" {
+		if err = sc.Auth(smtp.PlainAuth("", c.SMTP.Username, c.SMTP.Password, c.SMTP.Host)); err != nil {
+			return err
+		}
+	}
+	if err = sc.Mail(c.SMTP.From); err != nil {
+		return err
+	}
+	if err = sc.Rcpt(r.To); err != nil {
+		return err
+	}
+	w, err := sc.Data()
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=\"utf-8\"\r\n\r\n%s\r\n",
+		c.SMTP.From, r.To, headerValue(notificationTitle(n)), notificationMessage(n))
+	if _, err = w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return sc.Quit()
+}
+
+// doRequest runs the request, returning an error if it did not succeed
+func doRequest(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("request to %s failed with status %d: %s", req.URL.Host, res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// WebhookChannel POSTs the notification's json to a URL
+type WebhookChannel struct {
+	Client *http.Client
+}
+
+func (c *WebhookChannel) Deliver(r *DeliveryRule, n *Notification) error {
+	if r.URL == "" {
+		return errors.New("bad_request: webhook delivery requires a url")
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	return doRequest(c.Client, req)
+}
+
+// NtfyChannel publishes notifications to an ntfy topic
+type NtfyChannel struct {
+	Client *http.Client
+}
+
+var ntfyPriority = map[string]string{
+	"info":    "3",
+	"warning": "4",
+	"error":   "5",
+}
+
+func (c *NtfyChannel) Deliver(r *DeliveryRule, n *Notification) error {
+	if r.Topic == "" {
+		return errors.New("bad_request: ntfy delivery requires a topic")
+	}
+	server := r.URL
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(server, "/")+"/"+url.PathEscape(r.Topic), strings.NewReader(notificationMessage(n)))
+	if err != nil {
+		return err
+	}
+	t := notificationType(n)
+	req.Header.Set("Title", headerValue(notificationTitle(n)))
+	req.Header.Set("Tags", t)
+	if p, ok := ntfyPriority[t]; ok {
+		req.Header.Set("Priority", p)
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	return doRequest(c.Client, req)
+}
+
+// GotifyChannel sends notifications to a Gotify server
+type GotifyChannel struct {
+	Client *http.Client
+}
+
+var gotifyPriority = map[string]int{
+	"info":    2,
+	"warning": 5,
+	"error":   8,
+}
+
+func (c *GotifyChannel) Deliver(r *DeliveryRule, n *Notification) error {
+	if r.URL == "" || r.Token == "" {
+		return errors.New("bad_request: gotify delivery requires a url and an app token")
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"title":    notificationTitle(n),
+		"message":  notificationMessage(n),
+		"priority": gotifyPriority[notificationType(n)],
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(r.URL, "/")+"/message", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", r.Token)
+	return doRequest(c.Client, req)
+}
+
+// CommandChannel runs one of the commands defined in the server's config, giving it the notification's json on stdin.
+// Users can only choose among the commands set up by the server's admin.
+type CommandChannel struct {
+	Commands map[string][]string
+	Timeout  time.Duration
+}
+
+func (c *CommandChannel) Deliver(r *DeliveryRule, n *Notification) error {
+	cmdargs, ok := c.Commands[r.Command]
+	if !ok || len(cmdargs) == 0 {
+		return fmt.Errorf("bad_request: unrecognized notification command '%s'", r.Command)
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cmdargs[0], cmdargs[1:]...)
+	cmd.Stdin = bytes.NewReader(b)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %s failed: %w (%s)", r.Command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
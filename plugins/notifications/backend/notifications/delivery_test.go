@@ -0,0 +1,196 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/backend/events"
+)
+
+// smtpServer is a minimal SMTP server, which sends the messages it receives to the returned channel
+func smtpServer(t *testing.T) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	msgs := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+						reply("250 localhost")
+					case cmd == "DATA":
+						reply("354 go ahead")
+						msg := ""
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							msg += l
+						}
+						msgs <- msg
+						reply("250 ok")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln, msgs
+}
+
+type testRequest struct {
+	URL    string
+	Header http.Header
+	Body   string
+}
+
+func httpServer() (*httptest.Server, chan testRequest) {
+	reqs := make(chan testRequest, 10)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		reqs <- testRequest{URL: r.URL.String(), Header: r.Header, Body: string(b)}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})), reqs
+}
+
+func TestDelivery(t *testing.T) {
+	ln, msgs := smtpServer(t)
+	defer ln.Close()
+	srv, reqs := httpServer()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "heedy-delivery-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cmdout := path.Join(dir, "out.json")
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	portnum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	d, err := NewDelivery(nil, &DeliverySettings{
+		SMTP:     SMTPSettings{Host: host, Port: portnum, From: "heedy@localhost"},
+		Channels: []string{"email", "webhook", "ntfy", "gotify", "command"},
+		Commands: map[string][]string{"save": {"sh", "-c", "cat > " + cmdout}},
+
+		// The test server runs on localhost
+		AllowPrivateURLs: true,
+	})
+	require.NoError(t, err)
+
+	user := "test"
+	title := "Disk almost full"
+	description := "Only 1GB left"
+	warning := "warning"
+	n := &Notification{Key: "disk", User: &user, Title: &title, Description: &description, Type: &warning}
+
+	// Each rule only gets notifications of its type
+	require.NoError(t, d.Send([]DeliveryRule{
+		{Type: "error", Channel: "email", To: "me@localhost"},
+		{Type: "warning", Channel: "email", To: "me@localhost"},
+	}, n))
+	msg := <-msgs
+	require.Contains(t, msg, "To: me@localhost\r\n")
+	require.Contains(t, msg, "Subject: Disk almost full\r\n")
+	require.Contains(t, msg, "Only 1GB left")
+	require.Len(t, msgs, 0)
+
+	require.NoError(t, d.Send([]DeliveryRule{{Channel: "webhook", URL: srv.URL + "/hook", Token: "secret"}}, n))
+	r := <-reqs
+	require.Equal(t, "/hook", r.URL)
+	require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+	var hn Notification
+	require.NoError(t, json.Unmarshal([]byte(r.Body), &hn))
+	require.Equal(t, "disk", hn.Key)
+
+	require.NoError(t, d.Send([]DeliveryRule{{Channel: "ntfy", URL: srv.URL, Topic: "alerts/../x?y"}}, n))
+	r = <-reqs
+	require.Equal(t, "/alerts%2F..%2Fx%3Fy", r.URL)
+	require.Equal(t, "Disk almost full", r.Header.Get("Title"))
+	require.Equal(t, "4", r.Header.Get("Priority"))
+	require.Equal(t, "Only 1GB left", r.Body)
+
+	require.NoError(t, d.Send([]DeliveryRule{{Channel: "gotify", URL: srv.URL, Token: "apptoken"}}, n))
+	r = <-reqs
+	require.Equal(t, "/message", r.URL)
+	require.Equal(t, "apptoken", r.Header.Get("X-Gotify-Key"))
+	require.JSONEq(t, `{"title":"Disk almost full","message":"Only 1GB left","priority":5}`, r.Body)
+
+	require.NoError(t, d.Send([]DeliveryRule{{Channel: "command", Command: "save"}}, n))
+	b, err := ioutil.ReadFile(cmdout)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &hn))
+	require.Equal(t, "disk", hn.Key)
+
+	// Failures are returned, but don't stop the other rules from being delivered
+	require.Error(t, d.Send([]DeliveryRule{
+		{Channel: "webhook", URL: srv.URL + "/fail"},
+		{Channel: "command", Command: "notacommand"},
+		{Channel: "webhook", URL: srv.URL + "/hook"},
+	}, n))
+	require.Equal(t, "/fail", (<-reqs).URL)
+	require.Equal(t, "/hook", (<-reqs).URL)
+
+	// Only the enabled channels can be used
+	d, err = NewDelivery(nil, &DeliverySettings{Channels: []string{"email"}})
+	require.NoError(t, err)
+	require.Error(t, d.Send([]DeliveryRule{{Channel: "webhook", URL: srv.URL + "/hook"}}, n))
+	require.Error(t, d.Send([]DeliveryRule{{Channel: "email", To: "me@localhost"}}, n))
+	require.Len(t, reqs, 0)
+
+	// Requests to private addresses are refused unless they are allowed by the admin
+	d, err = NewDelivery(nil, &DeliverySettings{Channels: []string{"webhook", "ntfy", "gotify"}})
+	require.NoError(t, err)
+	for _, u := range []string{srv.URL, "http://localhost:" + port, "http://10.0.0.1", "http://[::1]", "http://169.254.169.254", "http://0.0.0.0"} {
+		require.Error(t, d.Send([]DeliveryRule{{Channel: "webhook", URL: u}}, n), u)
+		require.Error(t, d.Send([]DeliveryRule{{Channel: "ntfy", URL: u, Topic: "alerts"}}, n), u)
+		require.Error(t, d.Send([]DeliveryRule{{Channel: "gotify", URL: u, Token: "apptoken"}}, n), u)
+	}
+	require.Len(t, reqs, 0)
+
+	_, err = NewDelivery(nil, &DeliverySettings{Channels: []string{"pigeon"}})
+	require.Error(t, err)
+
+	// Notifications are queued for the delivery workers, and dropped if the queue is full
+	d.queue = make(chan *Notification, 1)
+	for i := 0; i < 3; i++ {
+		d.Fire(&events.Event{Event: "user_notification_create", Data: n})
+	}
+	require.Len(t, d.queue, 1)
+}
@@ -0,0 +1,130 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/heedy/heedy/backend/database"
+)
+
+// DigestKey is the key of the notification that holds a user's digest
+const DigestKey = "notifications_digest"
+
+// DigestType is the notification type of digests sent to delivery channels, so that delivery rules can choose digests
+const DigestType = "digest"
+
+// maxDigestItems is the number of notifications listed in a digest
+var maxDigestItems = 20
+
+// Digest returns a notification that summarizes the user's unseen notifications, or nil if there are none
+func Digest(db *database.AdminDB, user string) (*Notification, error) {
+	all := "*"
+	seen := false
+	includeSelf := true
+	nl, err := ReadNotifications(db, &NotificationsQuery{
+		User:        &user,
+		App:         &all,
+		Object:      &all,
+		Seen:        &seen,
+		IncludeSelf: &includeSelf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	items := 0
+	lines := []string{}
+	for i := range nl {
+		if nl[i].Key == DigestKey && nl[i].App == nil && nl[i].Object == nil {
+			continue
+		}
+		items++
+		count := nl[i].Count
+		if count < 1 {
+			count = 1
+		}
+		total += count
+		if len(lines) < maxDigestItems {
+			line := "- **" + notificationTitle(&nl[i]) + "**"
+			if count > 1 {
+				line += fmt.Sprintf(" (%d)", count)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if total == 0 {
+		return nil, nil
+	}
+	if more := items - len(lines); more > 0 {
+		lines = append(lines, fmt.Sprintf("- ...and %d more", more))
+	}
+
+	title := fmt.Sprintf("You have %d unseen notifications", total)
+	if total == 1 {
+		title = "You have 1 unseen notification"
+	}
+	description := strings.Join(lines, "\n")
+	ntype := "info"
+	f := false
+	t := true
+	return &Notification{
+		Key:         DigestKey,
+		User:        &user,
+		Title:       &title,
+		Description: &description,
+		Type:        &ntype,
+		Seen:        &f,
+		Global:      &t,
+		Dismissible: &t,
+	}, nil
+}
+
+// RunDigest sends the user's digest, as chosen in the user's digest setting
+func RunDigest(db *database.AdminDB, user string) error {
+	s, err := db.ReadUserPluginSettings(user, PluginName)
+	if err != nil {
+		return err
+	}
+	mode, _ := s["digest"].(string)
+	if mode == "" || mode == "none" {
+		return nil
+	}
+	n, err := Digest(db, user)
+	if err != nil || n == nil {
+		return err
+	}
+	switch mode {
+	case "notification":
+		return WriteNotification(db, n)
+	case "delivery":
+		d := currentDelivery()
+		if d == nil {
+			return errors.New("Notification delivery is not running")
+		}
+		rules, err := d.Rules(user)
+		if err != nil {
+			return err
+		}
+		dtype := DigestType
+		n.Type = &dtype
+		return d.Send(rules, n)
+	}
+	return fmt.Errorf("Unrecognized digest setting '%s'", mode)
+}
+
+// RunDigests sends the digests of all users. It is run by the notifications plugin's digest cron job.
+func RunDigests(db *database.AdminDB) error {
+	users, err := db.ListUsers(nil)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err = RunDigest(db, *u.UserName); err != nil {
+			logrus.WithField("plugin", PluginName).Warnf("Failed to send digest to %s: %s", *u.UserName, err)
+		}
+	}
+	return nil
+}
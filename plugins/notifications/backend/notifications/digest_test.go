@@ -0,0 +1,92 @@
+package notifications
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupsAndDigest(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	user := "test"
+	group := "sync"
+	title := "Sync failed"
+	for i := 0; i < 3; i++ {
+		require.NoError(t, WriteNotification(db, &Notification{Key: "sync" + strconv.Itoa(i), Title: &title, User: &user, Group: &group}))
+	}
+	other := "Other"
+	require.NoError(t, WriteNotification(db, &Notification{Key: "other", Title: &other, User: &user}))
+
+	// The writes in the group are merged into the first notification
+	n, err := ReadNotifications(db, &NotificationsQuery{User: &user, Group: &group})
+	require.NoError(t, err)
+	require.Len(t, n, 1)
+	require.Equal(t, "sync0", n[0].Key)
+	require.Equal(t, 3, n[0].Count)
+
+	require.Error(t, WriteNotification(db, &Notification{Key: "counted", Title: &title, User: &user, Count: 2}))
+
+	// Updating the group's notification directly isn't a merged write
+	require.NoError(t, WriteNotification(db, &Notification{Key: "sync0", Title: &title, User: &user, Group: &group}))
+	n, err = ReadNotifications(db, &NotificationsQuery{User: &user, Group: &group})
+	require.NoError(t, err)
+	require.Len(t, n, 1)
+	require.Equal(t, 3, n[0].Count)
+
+	// Concurrent writes to a group are all merged into a single notification
+	concurrent := "concurrent"
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, WriteNotification(db, &Notification{Key: "c" + strconv.Itoa(i), Title: &title, User: &user, Group: &concurrent}))
+		}(i)
+	}
+	wg.Wait()
+	n, err = ReadNotifications(db, &NotificationsQuery{User: &user, Group: &concurrent})
+	require.NoError(t, err)
+	require.Len(t, n, 1)
+	require.Equal(t, 10, n[0].Count)
+	key := n[0].Key
+	require.NoError(t, DeleteNotification(db, &NotificationsQuery{User: &user, Key: &key}))
+
+	// A merged write makes the notification unseen again
+	seen := true
+	key = "sync0"
+	require.NoError(t, UpdateNotification(db, &Notification{Seen: &seen}, &NotificationsQuery{User: &user, Key: &key}))
+	d, err := Digest(db, user)
+	require.NoError(t, err)
+	require.Equal(t, "You have 1 unseen notification", *d.Title)
+	require.NoError(t, WriteNotification(db, &Notification{Key: "sync3", Title: &title, User: &user, Group: &group}))
+
+	d, err = Digest(db, user)
+	require.NoError(t, err)
+	require.Equal(t, DigestKey, d.Key)
+	require.Equal(t, "You have 5 unseen notifications", *d.Title)
+	require.Contains(t, *d.Description, "- **Sync failed** (4)")
+	require.Contains(t, *d.Description, "- **Other**")
+
+	// The digest doesn't include itself
+	require.NoError(t, WriteNotification(db, d))
+	d, err = Digest(db, user)
+	require.NoError(t, err)
+	require.Equal(t, "You have 5 unseen notifications", *d.Title)
+
+	maxDigestItems = 1
+	defer func() { maxDigestItems = 20 }()
+	d, err = Digest(db, user)
+	require.NoError(t, err)
+	require.Contains(t, *d.Description, "...and 1 more")
+
+	// Nothing is summarized when all notifications are seen
+	all := "*"
+	require.NoError(t, UpdateNotification(db, &Notification{Seen: &seen}, &NotificationsQuery{User: &user, App: &all, Object: &all, IncludeSelf: &seen}))
+	d, err = Digest(db, user)
+	require.NoError(t, err)
+	require.Nil(t, d)
+}
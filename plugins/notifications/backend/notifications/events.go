@@ -12,7 +12,7 @@ import (
 )
 
 func getNotification(c *sqlite3.SQLiteConn, stmt string, rowid int64) (*Notification, error) {
-	colnum := 12
+	colnum := 17
 	rows, err := events.SQLiteSelectConn(c, stmt, rowid)
 	defer rows.Close()
 	if err != nil {
@@ -43,6 +43,18 @@ func getNotification(c *sqlite3.SQLiteConn, stmt string, rowid int64) (*Notifica
 		}
 	}
 
+	tfloat := func(v interface{}) *float64 {
+		switch vv := v.(type) {
+		case float64:
+			return &vv
+		case int64:
+			f := float64(vv)
+			return &f
+		default:
+			return nil
+		}
+	}
+
 	n := &Notification{
 		Key:       tsel(vals[0]),
 		Timestamp: vals[1].(float64),
@@ -76,6 +88,16 @@ func getNotification(c *sqlite3.SQLiteConn, stmt string, rowid int64) (*Notifica
 	}
 	dismissible := vals[11].(bool)
 	n.Dismissible = &dismissible
+	n.ShowAt = tfloat(vals[12])
+	n.SnoozedUntil = tfloat(vals[13])
+	n.ExpiresAt = tfloat(vals[14])
+	if vals[15] != nil {
+		group := tsel(vals[15])
+		n.Group = &group
+	}
+	if count, ok := vals[16].(int64); ok {
+		n.Count = int(count)
+	}
 
 	return n, nil
 }
@@ -100,11 +122,11 @@ func RegisterNotificationHooks(e events.Handler) {
 		getStmt := func(tblname string) string {
 			switch tblname {
 			case "notifications_user":
-				return "SELECT key,timestamp,title,description,type,seen,user,global,NULL,NULL,actions,dismissible FROM notifications_user WHERE rowid=?"
+				return "SELECT key,timestamp,title,description,type,seen,user,global,NULL,NULL,actions,dismissible,show_at,snoozed_until,expires_at,group_key,count FROM notifications_user WHERE rowid=?"
 			case "notifications_app":
-				return "SELECT key,timestamp,title,description,type,seen,user,global,app,NULL,actions,dismissible FROM notifications_app WHERE rowid=?"
+				return "SELECT key,timestamp,title,description,type,seen,user,global,app,NULL,actions,dismissible,show_at,snoozed_until,expires_at,group_key,count FROM notifications_app WHERE rowid=?"
 			case "notifications_object":
-				return "SELECT key,timestamp,title,description,type,seen,user,global,app,object,actions,dismissible FROM objects LEFT JOIN apps ON objects.app=apps.id WHERE objects.rowid=?"
+				return "SELECT key,timestamp,title,description,type,seen,user,global,app,object,actions,dismissible,show_at,snoozed_until,expires_at,group_key,count FROM notifications_object WHERE rowid=?"
 			default:
 				panic("Unrecognized table name in getStmt")
 
@@ -116,6 +138,10 @@ func RegisterNotificationHooks(e events.Handler) {
 			logrus.Errorf("Failed to process notification event: %s", err)
 			return nil
 		}
+		if s.Type == events.SQL_CREATE && n.isHidden(unixNow()) {
+			// The create event is fired by the scheduler once the notification is shown
+			return nil
+		}
 		var evt *events.Event
 		if n.Object != nil {
 			evt, err = events.FillObjectEvent(s, *n.Object)
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/database"
 )
 
 func readNotifications(w http.ResponseWriter, r *http.Request) {
@@ -16,6 +17,12 @@ func readNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	n, err := ReadNotifications(c.DB, &o)
+	if err == nil {
+		l := database.CallerLocale(c.DB)
+		for i := range n {
+			n[i].Time = l.FormatTime(n[i].Timestamp)
+		}
+	}
 	rest.WriteJSON(w, r, n, err)
 }
 
@@ -65,6 +72,7 @@ var Handler = func() *chi.Mux {
 	v1mux.Post("/notifications", writeNotification)
 	v1mux.Patch("/notifications", updateNotification)
 	v1mux.Delete("/notifications", deleteNotification)
+	v1mux.Post("/notifications/{key}/actions/{index}", runAction)
 
 	apiMux := chi.NewMux()
 	apiMux.NotFound(rest.NotFoundHandler)
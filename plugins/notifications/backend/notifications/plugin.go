@@ -15,13 +15,31 @@ func init() {
 		e := database.NewFilledHandler(db, events.GlobalHandler)
 		RegisterNotificationHooks(e)
 
-		return SQLUpdater(db, i, sqlVersion)
+		return SQLUpdater(db, i, h, sqlVersion)
 	})
 	run.Builtin.Add(&run.BuiltinRunner{
-		Key:     PluginName,
-		Start:   withversion,
+		Key: PluginName,
+		Start: func(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+			if err := withversion(db, i, h); err != nil {
+				return err
+			}
+			StartScheduler(db)
+			return StartDelivery(db)
+		},
+		Stop: func(db *database.AdminDB, apikey string) error {
+			StopDelivery()
+			StopScheduler()
+			return nil
+		},
 		Handler: Handler,
 	})
+	// The digest is run as a cron job
+	run.Builtin.Add(&run.BuiltinRunner{
+		Key: PluginName + "_digest",
+		Start: func(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+			return RunDigests(db)
+		},
+	})
 	// Runs schema creation on database create instead of on first start
 	database.AddCreateHook(run.WithNilInfo(withversion))
 }
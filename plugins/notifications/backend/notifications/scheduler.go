@@ -0,0 +1,193 @@
+package notifications
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+// schedulerMaxWait is the longest time that the scheduler sleeps between checking for scheduled notifications
+var schedulerMaxWait = time.Minute
+
+// Scheduler fires the create events of notifications once they are shown or their snooze ends,
+// and deletes notifications once they expire.
+type Scheduler struct {
+	DB *database.AdminDB
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	// The time up to which scheduled notifications were processed, which is stored in the database,
+	// so that notifications shown while heedy was not running are processed once it starts
+	last float64
+}
+
+var (
+	schedulerLock sync.Mutex
+	scheduler     *Scheduler
+)
+
+func unixNow() float64 {
+	return float64(time.Now().UnixNano()) * 1e-9
+}
+
+// StartScheduler starts the global notification scheduler
+func StartScheduler(db *database.AdminDB) {
+	schedulerLock.Lock()
+	defer schedulerLock.Unlock()
+	if scheduler != nil {
+		return
+	}
+	scheduler = NewScheduler(db)
+	go scheduler.run()
+}
+
+// NewScheduler creates a scheduler that continues from where the previous scheduler stopped
+func NewScheduler(db *database.AdminDB) *Scheduler {
+	s := &Scheduler{
+		DB:   db,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		last: unixNow(),
+	}
+	err := db.Get(&s.last, `SELECT last FROM notifications_scheduler WHERE id=0;`)
+	if err != nil && err != sql.ErrNoRows {
+		logrus.Errorf("Failed to read the notification scheduler's state: %s", err)
+	}
+	return s
+}
+
+// StopScheduler stops the global notification scheduler
+func StopScheduler() {
+	schedulerLock.Lock()
+	s := scheduler
+	scheduler = nil
+	schedulerLock.Unlock()
+	if s != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
+
+// wakeScheduler makes the scheduler recompute when it needs to run, since a notification's schedule changed
+func wakeScheduler() {
+	schedulerLock.Lock()
+	s := scheduler
+	schedulerLock.Unlock()
+	if s != nil {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	for {
+		now := unixNow()
+		if err := s.process(now); err != nil {
+			logrus.Errorf("Failed to process scheduled notifications: %s", err)
+		}
+		wait := schedulerMaxWait
+		next, err := s.next(now)
+		if err != nil {
+			logrus.Errorf("Failed to read scheduled notifications: %s", err)
+		} else if next != nil {
+			if d := time.Duration((*next - now) * float64(time.Second)); d < wait {
+				wait = d
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// next returns the time of the next scheduled change to a notification, or nil if there is none
+func (s *Scheduler) next(now float64) (*float64, error) {
+	var next *float64
+	for _, tbl := range notificationTables {
+		var t *float64
+		err := s.DB.Get(&t, fmt.Sprintf(`SELECT MIN(t) FROM (
+			SELECT MIN(show_at) AS t FROM %[1]s WHERE show_at>?
+			UNION ALL SELECT MIN(snoozed_until) AS t FROM %[1]s WHERE snoozed_until>?
+			UNION ALL SELECT MIN(expires_at) AS t FROM %[1]s WHERE expires_at IS NOT NULL
+		)`, tbl), now, now)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil && (next == nil || *t < *next) {
+			next = t
+		}
+	}
+	return next, nil
+}
+
+// process fires the create events of notifications that became visible since the last time it was run,
+// and deletes expired notifications, which fires their delete events.
+func (s *Scheduler) process(now float64) error {
+	eh := database.NewFilledHandler(s.DB, events.GlobalHandler)
+	for _, tbl := range notificationTables {
+		var shown []Notification
+		err := s.DB.Select(&shown, fmt.Sprintf(`SELECT * FROM %s WHERE (expires_at IS NULL OR expires_at>?) AND (
+			show_at>? AND show_at<=? AND (snoozed_until IS NULL OR snoozed_until<=?)
+			OR snoozed_until>? AND snoozed_until<=? AND (show_at IS NULL OR show_at<=?))`, tbl),
+			now, s.last, now, now, s.last, now, now)
+		if err != nil {
+			return err
+		}
+		for i := range shown {
+			eh.Fire(createEvent(tbl, &shown[i]))
+		}
+		if _, err = s.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE expires_at<=?", tbl), now); err != nil {
+			return err
+		}
+	}
+	s.last = now
+	_, err := s.DB.Exec(`INSERT INTO notifications_scheduler(id,last) VALUES (0,?) ON CONFLICT(id) DO UPDATE SET last=excluded.last;`, now)
+	return err
+}
+
+// createEvent returns the create event of the given notification
+func createEvent(tbl string, n *Notification) *events.Event {
+	return targetEvent(notificationEventType[events.SqliteHook{Table: tbl, Query: events.SQL_CREATE}], n, n)
+}
+
+// targetEvent returns an event with the given data, targeting the notification's user, app or object
+func targetEvent(event string, n *Notification, data interface{}) *events.Event {
+	evt := &events.Event{
+		Event: event,
+		Data:  data,
+	}
+	switch {
+	case n.Object != nil:
+		evt.Object = *n.Object
+	case n.App != nil:
+		evt.App = *n.App
+	default:
+		evt.User = *n.User
+	}
+	return evt
+}
+
+// isHidden returns whether the notification is not yet shown, or is snoozed
+func (n *Notification) isHidden(now float64) bool {
+	return n.ShowAt != nil && *n.ShowAt > now || n.SnoozedUntil != nil && *n.SnoozedUntil > now
+}
+
+// isScheduled returns whether the notification sets any of the times handled by the scheduler
+func (n *Notification) isScheduled() bool {
+	return n.ShowAt != nil || n.SnoozedUntil != nil || n.ExpiresAt != nil
+}
@@ -0,0 +1,124 @@
+package notifications
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/backend/assets"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
+)
+
+func newDB(t *testing.T) (*database.AdminDB, func()) {
+	dir, err := ioutil.TempDir("", "heedy-notifications-")
+	require.NoError(t, err)
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	addr := ":1324"
+	sqla := "sqlite3://heedy.db?_journal=WAL&_fk=1"
+	a, err := assets.Open("", &assets.Configuration{Addr: &addr, SQL: &sqla})
+	require.NoError(t, err)
+	a.FolderPath = dir
+	assets.SetGlobal(a)
+	if err = database.Create(a); err != nil {
+		cleanup()
+	}
+	require.NoError(t, err)
+	db, err := database.Open(a)
+	require.NoError(t, err)
+
+	name := "test"
+	passwd := "test"
+	require.NoError(t, db.CreateUser(&database.User{
+		UserName: &name,
+		Password: &passwd,
+	}))
+	return db, func() {
+		db.Close()
+		cleanup()
+	}
+}
+
+func TestScheduledNotifications(t *testing.T) {
+	db, cleanup := newDB(t)
+	defer cleanup()
+
+	user := "test"
+	title := "hi"
+	now := unixNow()
+	later := now + 1000
+	require.NoError(t, WriteNotification(db, &Notification{Key: "shown", Title: &title, User: &user}))
+	require.NoError(t, WriteNotification(db, &Notification{Key: "later", Title: &title, User: &user, ShowAt: &later}))
+	require.NoError(t, WriteNotification(db, &Notification{Key: "expiring", Title: &title, User: &user, ExpiresAt: &later}))
+
+	read := func(includeHidden bool) []string {
+		n, err := ReadNotifications(db, &NotificationsQuery{User: &user, IncludeHidden: &includeHidden})
+		require.NoError(t, err)
+		keys := []string{}
+		for _, v := range n {
+			keys = append(keys, v.Key)
+		}
+		return keys
+	}
+	require.ElementsMatch(t, []string{"shown", "expiring"}, read(false))
+	require.ElementsMatch(t, []string{"shown", "later", "expiring"}, read(true))
+
+	// Snoozing hides the notification, and setting the time to 0 clears it
+	key := "shown"
+	require.NoError(t, UpdateNotification(db, &Notification{SnoozedUntil: &later}, &NotificationsQuery{User: &user, Key: &key}))
+	require.ElementsMatch(t, []string{"expiring"}, read(false))
+	zero := float64(0)
+	require.NoError(t, UpdateNotification(db, &Notification{SnoozedUntil: &zero}, &NotificationsQuery{User: &user, Key: &key}))
+	require.ElementsMatch(t, []string{"shown", "expiring"}, read(false))
+
+	s := &Scheduler{DB: db, last: now}
+	next, err := s.next(now)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.Equal(t, later, *next)
+
+	// Once the scheduled time passes, the notification is shown, and the expired notification is deleted
+	require.NoError(t, s.process(later+1))
+	require.ElementsMatch(t, []string{"shown", "later"}, read(true))
+	next, err = s.next(later + 1)
+	require.NoError(t, err)
+	require.Nil(t, next)
+
+	// A new scheduler continues from where the previous one stopped, so that notifications shown
+	// while heedy was not running get their create events once it starts
+	s = NewScheduler(db)
+	require.Equal(t, later+1, s.last)
+	whileDown := later + 10
+	require.NoError(t, WriteNotification(db, &Notification{Key: "whiledown", Title: &title, User: &user, ShowAt: &whileDown}))
+	created := &createdKeys{}
+	events.AddHandler(created)
+	defer events.RemoveHandler(created)
+	require.NoError(t, s.process(whileDown+1))
+	require.Equal(t, []string{"whiledown"}, created.get())
+}
+
+// createdKeys holds the keys of notifications whose create events were fired
+type createdKeys struct {
+	sync.Mutex
+	keys []string
+}
+
+func (c *createdKeys) Fire(e *events.Event) {
+	if n, ok := e.Data.(*Notification); ok && e.Event == "user_notification_create" {
+		c.Lock()
+		c.keys = append(c.keys, n.Key)
+		c.Unlock()
+	}
+}
+
+func (c *createdKeys) get() []string {
+	c.Lock()
+	defer c.Unlock()
+	return c.keys
+}
@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
@@ -12,7 +13,7 @@ import (
 	"github.com/heedy/heedy/backend/plugins/run"
 )
 
-const SQLVersion = 1
+const SQLVersion = 4
 
 const sqlSchema = `
 -- We split up the schema into 3 tables due to issues with UNIQUE when certain values are NULL.
@@ -29,6 +30,16 @@ CREATE TABLE notifications_user (
 	timestamp REAL NOT NULL,
 	actions VARCHAR NOT NULL DEFAULT '[]',
 
+	-- Scheduling: a notification is hidden until show_at and snoozed_until,
+	-- and is deleted at expires_at
+	show_at REAL DEFAULT NULL,
+	expires_at REAL DEFAULT NULL,
+	snoozed_until REAL DEFAULT NULL,
+
+	-- Grouping: repeated writes in a group merge into a single notification, counting the writes
+	group_key VARCHAR DEFAULT NULL,
+	count INTEGER NOT NULL DEFAULT 1,
+
 	-- User notifications are global=true
 	global BOOLEAN NOT NULL DEFAULT true,
 	dismissible BOOLEAN NOT NULL DEFAULT true,
@@ -55,6 +66,16 @@ CREATE TABLE notifications_app (
 	timestamp REAL NOT NULL,
 	actions VARCHAR NOT NULL DEFAULT '[]',
 
+	-- Scheduling: a notification is hidden until show_at and snoozed_until,
+	-- and is deleted at expires_at
+	show_at REAL DEFAULT NULL,
+	expires_at REAL DEFAULT NULL,
+	snoozed_until REAL DEFAULT NULL,
+
+	-- Grouping: repeated writes in a group merge into a single notification, counting the writes
+	group_key VARCHAR DEFAULT NULL,
+	count INTEGER NOT NULL DEFAULT 1,
+
 	global BOOLEAN NOT NULL DEFAULT false,
 	seen BOOLEAN NOT NULL DEFAULT false,
 	dismissible BOOLEAN NOT NULL DEFAULT true,
@@ -87,6 +108,14 @@ CREATE TABLE notifications_object (
 	actions VARCHAR NOT NULL DEFAULT '[]',
 	timestamp REAL NOT NULL,
 
+	show_at REAL DEFAULT NULL,
+	expires_at REAL DEFAULT NULL,
+	snoozed_until REAL DEFAULT NULL,
+
+	-- Grouping: repeated writes in a group merge into a single notification, counting the writes
+	group_key VARCHAR DEFAULT NULL,
+	count INTEGER NOT NULL DEFAULT 1,
+
 	global BOOLEAN NOT NULL DEFAULT false,
 	seen BOOLEAN NOT NULL DEFAULT false,
 	dismissible BOOLEAN NOT NULL DEFAULT true,
@@ -112,20 +141,86 @@ CREATE TABLE notifications_object (
 		ON UPDATE CASCADE
 		ON DELETE CASCADE
 );
+
+-- The time up to which the scheduler fired the events of scheduled notifications, so that notifications
+-- shown while heedy was not running get their events once it starts
+CREATE TABLE notifications_scheduler (
+	id INTEGER PRIMARY KEY CHECK (id=0),
+	last REAL NOT NULL
+);
 `
 
 // SQLUpdater is in the format expected by Heedy to update the database
-func SQLUpdater(db *database.AdminDB, i *run.Info, curversion int) error {
+func SQLUpdater(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, curversion int) error {
 	if curversion == SQLVersion {
 		return nil
 	}
-	if curversion != 0 {
+	if curversion > SQLVersion {
 		return errors.New("Notifications database version too new")
 	}
-	_, err := db.ExecUncached(sqlSchema)
+	if curversion == 0 {
+		_, err := db.ExecUncached(sqlSchema)
+		return err
+	}
+	if curversion == 1 {
+		if err := addScheduling(db); err != nil {
+			return err
+		}
+	}
+	if curversion <= 2 {
+		if err := addGrouping(db); err != nil {
+			return err
+		}
+	}
+	return addSchedulerState(db)
+}
+
+// addScheduling migrates from version 1, adding the scheduling columns to the notification tables
+func addScheduling(db *database.AdminDB) error {
+	tx, err := db.BeginImmediatex()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, tbl := range notificationTables {
+		for _, col := range []string{"show_at", "expires_at", "snoozed_until"} {
+			if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s REAL DEFAULT NULL", tbl, col)); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// addGrouping migrates from version 2, adding the grouping columns to the notification tables
+func addGrouping(db *database.AdminDB) error {
+	tx, err := db.BeginImmediatex()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, tbl := range notificationTables {
+		if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN group_key VARCHAR DEFAULT NULL", tbl)); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN count INTEGER NOT NULL DEFAULT 1", tbl)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addSchedulerState migrates from version 3, adding the table that holds the scheduler's progress
+func addSchedulerState(db *database.AdminDB) error {
+	_, err := db.ExecUncached(`CREATE TABLE notifications_scheduler (
+		id INTEGER PRIMARY KEY CHECK (id=0),
+		last REAL NOT NULL
+	);`)
 	return err
 }
 
+var notificationTables = []string{"notifications_user", "notifications_app", "notifications_object"}
+
 var ErrAccessDenied = errors.New("access_denied: You don't have necessary permissions for the given query")
 
 type Action struct {
@@ -210,6 +305,9 @@ type Notification struct {
 	Key       string  `json:"key"`
 	Timestamp float64 `json:"timestamp"`
 
+	// Time is the timestamp formatted in the reader's timezone. It is set when reading notifications.
+	Time string `json:"time,omitempty" db:"-"`
+
 	User   *string `json:"user,omitempty"`
 	App    *string `json:"app,omitempty"`
 	Object *string `json:"object,omitempty"`
@@ -222,6 +320,17 @@ type Notification struct {
 	Dismissible *bool `json:"dismissible"`
 	Seen        *bool `json:"seen"`
 	Global      *bool `json:"global"`
+
+	// The notification is hidden until show_at and snoozed_until, and is deleted at expires_at.
+	// Setting one of them to 0 clears it.
+	ShowAt       *float64 `json:"show_at,omitempty" db:"show_at"`
+	ExpiresAt    *float64 `json:"expires_at,omitempty" db:"expires_at"`
+	SnoozedUntil *float64 `json:"snoozed_until,omitempty" db:"snoozed_until"`
+
+	// Writes with the same group as an existing notification update that notification instead,
+	// and Count is the number of writes that were merged into it.
+	Group *string `json:"group,omitempty" db:"group_key"`
+	Count int     `json:"count,omitempty" db:"count"`
 }
 
 type NotificationsQuery struct {
@@ -234,7 +343,11 @@ type NotificationsQuery struct {
 	Key         *string `json:"key,omitempty" schema:"key"`
 	Dismissible *bool   `json:"dismissible,omitempty" schema:"dismissible"`
 
-	Type *string `json:"type,omitempty"`
+	Type  *string `json:"type,omitempty"`
+	Group *string `json:"group,omitempty" schema:"group"`
+
+	// Notifications that are not yet shown, or are snoozed, are only returned if IncludeHidden is true
+	IncludeHidden *bool `json:"include_hidden,omitempty" schema:"include_hidden"`
 
 	// Whether  or not to include self when * present. For example {user="test",app="*"}
 	// is unclear whether the user's notifications should be included or not. False by default
@@ -242,6 +355,11 @@ type NotificationsQuery struct {
 }
 
 func (n *Notification) Validate() (err error) {
+	for _, t := range []*float64{n.ShowAt, n.ExpiresAt, n.SnoozedUntil} {
+		if t != nil && *t < 0 {
+			return errors.New("bad_request: Notification times must be unix timestamps")
+		}
+	}
 	if n.Actions != nil {
 		err = n.Actions.Validate()
 		if err != nil {
@@ -315,6 +433,10 @@ func extractQueryBasics(o *NotificationsQuery) ([]string, []interface{}) {
 		cNames = append(cNames, "dismissible")
 		cValues = append(cValues, *o.Dismissible)
 	}
+	if o.Group != nil {
+		cNames = append(cNames, "group_key")
+		cValues = append(cValues, *o.Group)
+	}
 	return cNames, cValues
 }
 
@@ -348,6 +470,23 @@ func includeTable(o *NotificationsQuery) (bool, bool, bool) {
 	return includeUser, includeApp, includeObject
 }
 
+// whereStmt returns the WHERE clause and its values for the given columns. Expired notifications are never returned,
+// and hidden notifications are only returned if includeHidden is true.
+func whereStmt(cNames []string, cValues []interface{}, includeHidden bool) (string, []interface{}) {
+	now := unixNow()
+	conds := make([]string, 0, len(cNames)+3)
+	for _, c := range cNames {
+		conds = append(conds, c+"=?")
+	}
+	conds = append(conds, "(expires_at IS NULL OR expires_at>?)")
+	vals := append(append([]interface{}{}, cValues...), now)
+	if !includeHidden {
+		conds = append(conds, "(show_at IS NULL OR show_at<=?)", "(snoozed_until IS NULL OR snoozed_until<=?)")
+		vals = append(vals, now, now)
+	}
+	return strings.Join(conds, " AND "), vals
+}
+
 // ReadNotifications reads the notifications associated with the given user/app/object
 func ReadNotifications(db database.DB, o *NotificationsQuery) ([]Notification, error) {
 	// Figure out which tables to query for the results
@@ -359,6 +498,7 @@ func ReadNotifications(db database.DB, o *NotificationsQuery) ([]Notification, e
 	}
 
 	res := []Notification{}
+	includeHidden := o.IncludeHidden != nil && *o.IncludeHidden
 
 	// Set up the query that will be used to filter results
 	cNames, cValues := extractQueryBasics(o)
@@ -369,9 +509,9 @@ func ReadNotifications(db database.DB, o *NotificationsQuery) ([]Notification, e
 	}
 
 	if includeUser {
-		queryWhere := strings.Join(cNames, "=? AND ") + "=?"
+		queryWhere, qValues := whereStmt(cNames, cValues, includeHidden)
 		var r []Notification
-		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_user WHERE %s;", queryWhere), cValues...)
+		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_user WHERE %s;", queryWhere), qValues...)
 		if err != nil {
 			return nil, err
 		}
@@ -384,9 +524,9 @@ func ReadNotifications(db database.DB, o *NotificationsQuery) ([]Notification, e
 	}
 
 	if includeApp {
-		queryWhere := strings.Join(cNames, "=? AND ") + "=?"
+		queryWhere, qValues := whereStmt(cNames, cValues, includeHidden)
 		var r []Notification
-		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_app WHERE %s;", queryWhere), cValues...)
+		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_app WHERE %s;", queryWhere), qValues...)
 		if err != nil {
 			return nil, err
 		}
@@ -399,9 +539,9 @@ func ReadNotifications(db database.DB, o *NotificationsQuery) ([]Notification, e
 	}
 
 	if includeObject {
-		queryWhere := strings.Join(cNames, "=? AND ") + "=?"
+		queryWhere, qValues := whereStmt(cNames, cValues, includeHidden)
 		var r []Notification
-		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_object WHERE %s;", queryWhere), cValues...)
+		err := db.AdminDB().Select(&r, fmt.Sprintf("SELECT * FROM notifications_object WHERE %s;", queryWhere), qValues...)
 		if err != nil {
 			return nil, err
 		}
@@ -448,6 +588,27 @@ func extractNotificationBasics(n *Notification) ([]string, []interface{}) {
 		cNames = append(cNames, "dismissible")
 		cValues = append(cValues, *n.Dismissible)
 	}
+	if n.Group != nil {
+		cNames = append(cNames, "group_key")
+		if *n.Group == "" {
+			cValues = append(cValues, nil)
+		} else {
+			cValues = append(cValues, *n.Group)
+		}
+	}
+	for _, t := range []struct {
+		name string
+		v    *float64
+	}{{"show_at", n.ShowAt}, {"expires_at", n.ExpiresAt}, {"snoozed_until", n.SnoozedUntil}} {
+		if t.v != nil {
+			cNames = append(cNames, t.name)
+			if *t.v == 0 {
+				cValues = append(cValues, nil)
+			} else {
+				cValues = append(cValues, *t.v)
+			}
+		}
+	}
 	return cNames, cValues
 }
 
@@ -461,7 +622,12 @@ func excludeStmt(cNames []string) string {
 
 // WriteNotification writes the given notification. If a notification with the given key and target exists, it updates the existing notification with the new
 // values. The notification will only update those values that are specifically set in the new notification
-func WriteNotification(db database.DB, n *Notification) error {
+func WriteNotification(db database.DB, n *Notification) (err error) {
+	defer func() {
+		if err == nil && n.isScheduled() {
+			wakeScheduler()
+		}
+	}()
 	dbid := db.ID()
 	if n.Key == "" || n.Title == nil || *n.Title == "" {
 		return errors.New("bad_request: Notifications must have a valid key and title")
@@ -469,6 +635,9 @@ func WriteNotification(db database.DB, n *Notification) error {
 	if n.Timestamp != 0 {
 		return errors.New("bad_request: timestamps are set automatically")
 	}
+	if n.Count != 0 {
+		return errors.New("bad_request: counts are set automatically")
+	}
 	if err := n.Validate(); err != nil {
 		return err
 	}
@@ -489,6 +658,41 @@ func WriteNotification(db database.DB, n *Notification) error {
 	cValues = append(cValues, float64(time.Now().UnixNano())*1e-9)
 	eS := excludeStmt(cNames)
 
+	// upsert writes the notification to the given table. If the notification's group already has a notification
+	// for the target, the write updates that notification instead, counting the merged writes. The key is always
+	// the first column. The group is looked up in the same transaction as the write, so that concurrent writes
+	// to a group are merged into a single notification.
+	upsert := func(tbl, col, target, conflict string) error {
+		tx, err := db.AdminDB().BeginImmediatex()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		values := cValues
+		set := eS
+		if n.Group != nil && *n.Group != "" {
+			var key string
+			err = tx.Get(&key, fmt.Sprintf("SELECT key FROM %s WHERE %s=? AND group_key=? AND (expires_at IS NULL OR expires_at>?) LIMIT 1;", tbl, col),
+				target, *n.Group, unixNow())
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == nil && key != n.Key {
+				values = append([]interface{}{key}, cValues[1:]...)
+				set += ", count=count+1"
+				if n.Seen == nil {
+					set += ", seen=false"
+				}
+			}
+		}
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s) ON CONFLICT(%s) DO UPDATE SET %s;", tbl, strings.Join(cNames, ","), database.QQ(len(cNames)), conflict, set),
+			values...)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
 	if n.Object != nil {
 		// The notification is for a object
 		s, err := db.ReadObject(*n.Object, nil)
@@ -501,9 +705,7 @@ func WriteNotification(db database.DB, n *Notification) error {
 			n.App = s.App
 			cNames = append(cNames, "user", "app", "object")
 			cValues = append(cValues, *s.Owner, *s.App, s.ID)
-			_, err := db.AdminDB().Exec(fmt.Sprintf("INSERT INTO notifications_object(%s) VALUES (%s) ON CONFLICT(user,key) DO UPDATE SET %s;", strings.Join(cNames, ","), database.QQ(len(cNames)), eS),
-				cValues...)
-			return err
+			return upsert("notifications_object", "object", s.ID, "user,app,object,key")
 		}
 		return database.ErrAccessDenied("Can't set notifications for this object")
 	}
@@ -519,9 +721,7 @@ func WriteNotification(db database.DB, n *Notification) error {
 			n.App = &c.ID
 			cNames = append(cNames, "user", "app")
 			cValues = append(cValues, *c.Owner, c.ID)
-			_, err := db.AdminDB().Exec(fmt.Sprintf("INSERT INTO notifications_app(%s) VALUES (%s) ON CONFLICT(user,app,key) DO UPDATE SET %s;", strings.Join(cNames, ","), database.QQ(len(cNames)), eS),
-				cValues...)
-			return err
+			return upsert("notifications_app", "app", c.ID, "user,app,key")
 		}
 		return database.ErrAccessDenied("Can't set notifications for this app")
 	}
@@ -535,15 +735,18 @@ func WriteNotification(db database.DB, n *Notification) error {
 	if dbid == "heedy" || *u.UserName == dbid {
 		cNames = append(cNames, "user")
 		cValues = append(cValues, *u.UserName)
-		_, err := db.AdminDB().Exec(fmt.Sprintf("INSERT INTO notifications_user(%s) VALUES (%s) ON CONFLICT(user,app,object,key) DO UPDATE SET %s;", strings.Join(cNames, ","), database.QQ(len(cNames)), eS),
-			cValues...)
-		return err
+		return upsert("notifications_user", "user", *u.UserName, "user,key")
 	}
 	return database.ErrAccessDenied("Can't set notifications for this user")
 }
 
 // UpdateNotification is a special version that modifies all notifications satisfying the constraints given in NotificationsQuery
-func UpdateNotification(db database.DB, n *Notification, o *NotificationsQuery) error {
+func UpdateNotification(db database.DB, n *Notification, o *NotificationsQuery) (err error) {
+	defer func() {
+		if err == nil && n.isScheduled() {
+			wakeScheduler()
+		}
+	}()
 	includeUser, includeApp, includeObject := includeTable(o)
 	if n.Timestamp != 0 {
 		return errors.New("bad_request: timestamps are set automatically")
@@ -552,7 +755,7 @@ func UpdateNotification(db database.DB, n *Notification, o *NotificationsQuery)
 		return err
 	}
 
-	o, err := queryAllowed(db, o)
+	o, err = queryAllowed(db, o)
 	if err != nil {
 		return err
 	}
@@ -665,3 +868,17 @@ func DeleteNotification(db database.DB, o *NotificationsQuery) error {
 	return nil
 
 }
+
+// dismissNotification deletes the given notification from its table
+func dismissNotification(db *database.AdminDB, n *Notification) error {
+	var err error
+	switch {
+	case n.Object != nil:
+		_, err = db.Exec("DELETE FROM notifications_object WHERE object=? AND key=?", *n.Object, n.Key)
+	case n.App != nil:
+		_, err = db.Exec("DELETE FROM notifications_app WHERE app=? AND key=?", *n.App, n.Key)
+	default:
+		_, err = db.Exec("DELETE FROM notifications_user WHERE user=? AND key=?", *n.User, n.Key)
+	}
+	return err
+}
@@ -1,22 +1,15 @@
 package python
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
-	"sync"
-	"syscall"
-	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/heedy/heedy/api/golang/rest"
-	"github.com/heedy/heedy/backend/assets"
 	"github.com/heedy/heedy/backend/database"
 	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/mitchellh/mapstructure"
@@ -24,8 +17,8 @@ import (
 )
 
 type PythonSettings struct {
-	sync.Mutex                     // Defends Cmd
-	Cmd        map[string]*run.Cmd `mapstructure:"-"`
+	// Exec runs the python processes of heedy's runners
+	Exec *run.ExecHandler `mapstructure:"-"`
 
 	IsEnabled bool              `mapstructure:"-"`
 	DB        *database.AdminDB `mapstructure:"-"`
@@ -41,13 +34,14 @@ type PythonSettings struct {
 var (
 	l        = logrus.WithField("plugin", "python:backend")
 	settings = PythonSettings{
-		Cmd: make(map[string]*run.Cmd),
+		Exec: run.NewExecHandler(nil),
 	}
 )
 
 // Start checks the currently set python path to make sure that it is valid
 func Start(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
 	settings.DB = db
+	settings.Exec.DB = db
 
 	pyplugin, ok := db.Assets().Config.Plugins["python"]
 	if !ok {
@@ -70,6 +64,9 @@ func Start(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
 	} else {
 		settings.IsEnabled = true
 	}
+	if err == nil && settings.PerPluginVenv {
+		err = CleanupVenvs(db.Assets().FolderPath, db.Assets().PluginDir())
+	}
 
 	return err
 }
@@ -97,95 +94,21 @@ func StartPythonProcess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pypath := settings.Path
-	if settings.PerPluginVenv {
-		// The python path is now the venv's python
-		pypath, err = EnsureVenv(pypath, path.Join(i.HeedyDir, "venv", i.Plugin))
-		if err != nil {
-			rest.WriteJSONError(w, r, http.StatusBadRequest, fmt.Errorf("Failed to create venv: %w", err))
-			return
-		}
-	}
-
 	fp := path.Join(i.PluginDir, rs.Path)
 	_, err = os.Stat(fp)
 	if err != nil {
 		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	requirementsFile := path.Join(filepath.Dir(fp), "requirements.txt")
-	_, err = os.Stat(requirementsFile)
-	if err == nil {
-		l.Debugf("Setting up requirements from %s", requirementsFile)
-		err = RunCommand(pypath, append([]string{"-m", "pip", "install", "-r", requirementsFile}, settings.PipArgs...))
-
-		if err != nil {
-			rest.WriteJSONError(w, r, http.StatusBadRequest, fmt.Errorf("Failed to install plugin requirements: %w", err))
-			return
-		}
-	}
-
-	// Run the actual code now
-	fullargs := append([]string{rs.Path}, rs.Args...)
-	if settings.DB.Verbose {
-		l.Debugf("%s %s", pypath, strings.Join(fullargs, " "))
-	}
-	cmd := exec.Command(pypath, fullargs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = i.PluginDir
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
-		return
-	}
-	// Prepare the input
-	infobytes, err := json.Marshal(i)
+	pypath, err := PreparePlugin(i.HeedyDir, i.Plugin, path.Join(filepath.Dir(fp), "requirements.txt"))
 	if err != nil {
 		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if err = cmd.Start(); err != nil {
-		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
-		return
-	}
-	_, err = stdin.Write(infobytes)
-	if err == nil {
-		_, err = stdin.Write([]byte{'\n'})
-	}
-	if err != nil {
-		// Kill the process if can't write to stdin
-		cmd.Process.Kill()
-		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
-		return
-	}
-
-	c := run.NewCmd(cmd)
-	go c.Wait()
-	settings.Lock()
-	settings.Cmd[i.APIKey] = c
-	settings.Unlock()
-
-	if rs.API != "" {
-		method, host, err := run.GetEndpoint(settings.DB.Assets().DataDir(), rs.API)
-		if err == nil {
-			err = run.WaitForEndpoint(method, host, c)
-		}
-		if err != nil {
-			settings.Lock()
-			delete(settings.Cmd, i.APIKey)
-			settings.Unlock()
-			cmd.Process.Kill()
-			rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
-			return
-		}
-	}
-
-	rest.WriteJSON(w, r, run.StartMessage{API: rs.API}, nil)
+	// Run the actual code now
+	err = settings.Exec.StartCommand(&i, append([]string{pypath, rs.Path}, rs.Args...), rs.API)
+	rest.WriteJSON(w, r, run.StartMessage{API: rs.API}, err)
 }
 
 func RunPython(w http.ResponseWriter, r *http.Request) {
@@ -199,47 +122,94 @@ func StopPython(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	apikey, err := rest.URLParam(r, "apikey", nil)
+	if err == nil {
+		err = settings.Exec.Stop(apikey)
+	}
 	if err != nil {
 		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
 		return
 	}
-	settings.Lock()
-	cmd, ok := settings.Cmd[apikey]
-	settings.Unlock()
-	if !ok {
-		rest.WriteJSONError(w, r, http.StatusInternalServerError, errors.New("Can't stop python process: No such process"))
+	rest.WriteResult(w, r, nil)
+}
+
+func KillPython(w http.ResponseWriter, r *http.Request) {
+	apikey, err := rest.URLParam(r, "apikey", nil)
+	if err == nil {
+		err = settings.Exec.Kill(apikey)
+	}
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
 		return
 	}
-	cmd.Cmd.Process.Signal(os.Interrupt)
+	rest.WriteResult(w, r, nil)
+}
 
-	d := assets.Get().Config.GetRunTimeout()
+// requireAdmin makes sure that the request comes from a heedy admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	c := rest.CTX(r)
+	if c.DB.Type() != database.AdminType && !c.DB.AdminDB().Assets().Config.UserIsAdmin(c.DB.ID()) {
+		rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: Managing python venvs is admin-only"))
+		return false
+	}
+	return true
+}
 
-	sleepDuration := 50 * time.Millisecond
-	for i := time.Duration(0); i < d; i += sleepDuration {
-		if cmd.Done() {
-			rest.WriteResult(w, r, nil)
+// RebuildVenv deletes a plugin's venv, and recreates it with the requirements of all of the plugin's python runners.
+// The plugin needs to be reloaded to use the new venv.
+func RebuildVenv(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if !settings.IsEnabled || !settings.PerPluginVenv {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("bad_request: Per-plugin python venvs are not enabled"))
+		return
+	}
+	pname := chi.URLParam(r, "pluginname")
+	a := settings.DB.Assets()
+	p, ok := a.Config.Plugins[pname]
+	if !ok {
+		rest.WriteJSONError(w, r, http.StatusNotFound, errors.New("not_found: The plugin does not exist"))
+		return
+	}
+	if err := DeleteVenv(a.FolderPath, pname); err != nil && !strings.HasPrefix(err.Error(), "not_found") {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	requirements := []string{""}
+	for _, rv := range p.Run {
+		if rv.Type == nil || *rv.Type != "python" {
+			continue
+		}
+		if fp, ok := rv.Config["path"].(string); ok {
+			requirements = append(requirements, path.Join(a.PluginDir(), pname, filepath.Dir(fp), "requirements.txt"))
+		}
+	}
+	for _, rf := range requirements {
+		if _, err := PreparePlugin(a.FolderPath, pname, rf); err != nil {
+			rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		time.Sleep(sleepDuration)
 	}
-	l.Warn("Process not responding - killing")
-	KillPython(w, r)
+	rest.WriteResult(w, r, nil)
 }
 
-func KillPython(w http.ResponseWriter, r *http.Request) {
-	apikey, err := rest.URLParam(r, "apikey", nil)
-	if err != nil {
-		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+// DeleteVenvHandler removes a plugin's venv
+func DeleteVenvHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
 		return
 	}
-	settings.Lock()
-	cmd, ok := settings.Cmd[apikey]
-	settings.Unlock()
-	if !ok {
-		rest.WriteJSONError(w, r, http.StatusInternalServerError, errors.New("Couldn't find the command"))
+	pname := chi.URLParam(r, "pluginname")
+	a := settings.DB.Assets()
+	if _, ok := a.Config.Plugins[pname]; !ok {
+		rest.WriteJSONError(w, r, http.StatusNotFound, errors.New("not_found: The plugin does not exist"))
+		return
+	}
+	err := DeleteVenv(a.FolderPath, pname)
+	if err != nil && strings.HasPrefix(err.Error(), "not_found") {
+		rest.WriteJSONError(w, r, http.StatusNotFound, err)
 		return
 	}
-	rest.WriteResult(w, r, cmd.Cmd.Process.Kill())
+	rest.WriteResult(w, r, err)
 }
 
 // Handler is the main API handler
@@ -249,5 +219,7 @@ var Handler = func() *chi.Mux {
 	mux.MethodNotAllowed(rest.NotFoundHandler)
 	mux.Post("/runtypes/python", StartPythonProcess)
 	mux.Delete("/runtypes/python/{apikey}", StopPython)
+	mux.Post("/api/python/venvs/{pluginname}/rebuild", RebuildVenv)
+	mux.Delete("/api/python/venvs/{pluginname}", DeleteVenvHandler)
 	return mux
 }()
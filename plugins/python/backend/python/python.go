@@ -9,8 +9,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 
+	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/sirupsen/logrus"
 )
 
@@ -75,32 +75,7 @@ func ValidatePython(exepath string) error {
 }
 
 func RunCommand(pypath string, args []string) error {
-	if settings.DB.Verbose {
-		l.Debugf("%s %s", pypath, strings.Join(args, " "))
-	}
-	cmd := exec.Command(pypath, args...)
-	lvl := logrus.GetLevel()
-	if lvl == logrus.DebugLevel || lvl == logrus.InfoLevel {
-		logdir := settings.DB.Assets().LogDir()
-		if logdir == "stdout" {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		} else {
-			logfile := path.Join(logdir, "python.log")
-			f, err := os.OpenFile(logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-			if err != nil {
-				return err
-			}
-			_, err = f.WriteString(fmt.Sprintf("\n\n%s >>> %s %s\n", time.Now().Format(time.RFC3339), pypath, strings.Join(args, " ")))
-			if err != nil {
-				return err
-			}
-			cmd.Stdout = f
-			cmd.Stderr = f
-			defer f.Close()
-		}
-	}
-	return cmd.Run()
+	return run.RunCommand(settings.DB, "python.log", "", pypath, args)
 }
 
 // EnsureVenv makes sure that a venv exists in the given folder. If not, it creates one there.
@@ -0,0 +1,168 @@
+package python
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// RequirementsFile is written in each plugin's venv, and holds the hashes of the requirements
+// files that were installed into the venv
+const RequirementsFile = "heedy-requirements.json"
+
+var (
+	venvLock  sync.Mutex
+	venvLocks = make(map[string]*sync.Mutex)
+)
+
+// lockVenv locks the given plugin's venv, so that it is not modified concurrently.
+// It returns the function that unlocks the venv.
+func lockVenv(plugin string) func() {
+	venvLock.Lock()
+	m, ok := venvLocks[plugin]
+	if !ok {
+		m = &sync.Mutex{}
+		venvLocks[plugin] = m
+	}
+	venvLock.Unlock()
+	m.Lock()
+	return m.Unlock
+}
+
+// VenvFolder returns the folder holding the given plugin's venv. The plugin name must not contain
+// path separators or dots, so that the folder is always inside heedy's venv folder.
+func VenvFolder(heedyDir, plugin string) (string, error) {
+	if plugin == "" || strings.ContainsAny(plugin, "./\\") {
+		return "", fmt.Errorf("bad_request: invalid plugin name '%s'", plugin)
+	}
+	return path.Join(heedyDir, "venv", plugin), nil
+}
+
+func hashFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+func readInstalledRequirements(venv string) map[string]string {
+	installed := make(map[string]string)
+	b, err := ioutil.ReadFile(path.Join(venv, RequirementsFile))
+	if err == nil {
+		json.Unmarshal(b, &installed)
+	}
+	return installed
+}
+
+// InstallRequirements installs the given requirements file with pip. If venv is not empty, the requirements
+// are only installed if they changed since they were last installed into the venv.
+func InstallRequirements(pypath, venv, requirementsFile string) error {
+	if venv == "" {
+		l.Debugf("Setting up requirements from %s", requirementsFile)
+		return RunCommand(pypath, append([]string{"-m", "pip", "install", "-r", requirementsFile}, settings.PipArgs...))
+	}
+	hash, err := hashFile(requirementsFile)
+	if err != nil {
+		return err
+	}
+	installed := readInstalledRequirements(venv)
+	if installed[requirementsFile] == hash {
+		return nil
+	}
+	l.Debugf("Setting up requirements from %s", requirementsFile)
+	if err = RunCommand(pypath, append([]string{"-m", "pip", "install", "-r", requirementsFile}, settings.PipArgs...)); err != nil {
+		return err
+	}
+	installed[requirementsFile] = hash
+	b, err := json.Marshal(installed)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(venv, RequirementsFile), b, 0644)
+}
+
+// PreparePlugin returns the python interpreter to use for the given plugin, after creating its venv
+// (if per_plugin_venv is set) and installing the given requirements file (if it exists).
+func PreparePlugin(heedyDir, plugin, requirementsFile string) (string, error) {
+	defer lockVenv(plugin)()
+	pypath := settings.Path
+	venv := ""
+	if settings.PerPluginVenv {
+		var err error
+		venv, err = VenvFolder(heedyDir, plugin)
+		if err != nil {
+			return "", err
+		}
+		// The python path is now the venv's python
+		pypath, err = EnsureVenv(pypath, venv)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create venv: %w", err)
+		}
+	}
+	if requirementsFile == "" {
+		return pypath, nil
+	}
+	if _, err := os.Stat(requirementsFile); err != nil {
+		if os.IsNotExist(err) {
+			return pypath, nil
+		}
+		return "", err
+	}
+	if err := InstallRequirements(pypath, venv, requirementsFile); err != nil {
+		return "", fmt.Errorf("Failed to install plugin requirements: %w", err)
+	}
+	return pypath, nil
+}
+
+// DeleteVenv removes the given plugin's venv
+func DeleteVenv(heedyDir, plugin string) error {
+	venv, err := VenvFolder(heedyDir, plugin)
+	if err != nil {
+		return err
+	}
+	defer lockVenv(plugin)()
+	if _, err := os.Stat(venv); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("not_found: plugin '%s' has no venv", plugin)
+		}
+		return err
+	}
+	l.Infof("Removing venv %s", venv)
+	return os.RemoveAll(venv)
+}
+
+// CleanupVenvs removes the venvs of plugins that are no longer installed
+func CleanupVenvs(heedyDir, pluginDir string) error {
+	venvs, err := ioutil.ReadDir(path.Join(heedyDir, "venv"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, v := range venvs {
+		venv, err := VenvFolder(heedyDir, v.Name())
+		if err != nil || !v.IsDir() {
+			continue
+		}
+		if _, err = os.Stat(path.Join(pluginDir, v.Name())); !os.IsNotExist(err) {
+			continue
+		}
+		unlock := lockVenv(v.Name())
+		l.Infof("Removing venv of uninstalled plugin %s", v.Name())
+		err = os.RemoveAll(venv)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
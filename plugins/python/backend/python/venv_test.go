@@ -0,0 +1,61 @@
+package python
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupVenvs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-python-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pluginDir := path.Join(dir, "plugins")
+	require.NoError(t, os.MkdirAll(path.Join(pluginDir, "installed"), 0775))
+	require.NoError(t, os.MkdirAll(path.Join(dir, "venv", "installed"), 0775))
+	require.NoError(t, os.MkdirAll(path.Join(dir, "venv", "uninstalled"), 0775))
+
+	require.NoError(t, CleanupVenvs(dir, pluginDir))
+	_, err = os.Stat(path.Join(dir, "venv", "installed"))
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(dir, "venv", "uninstalled"))
+	require.True(t, os.IsNotExist(err))
+
+	// Only plugin venvs can be deleted
+	for _, name := range []string{"", "..", "../plugins", "installed/..", "a\\b"} {
+		require.Error(t, DeleteVenv(dir, name))
+	}
+	_, err = os.Stat(path.Join(pluginDir, "installed"))
+	require.NoError(t, err)
+
+	require.NoError(t, DeleteVenv(dir, "installed"))
+	require.Error(t, DeleteVenv(dir, "installed"))
+}
+
+func TestInstallRequirements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heedy-python-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requirementsFile := path.Join(dir, "requirements.txt")
+	require.NoError(t, ioutil.WriteFile(requirementsFile, []byte("requests\n"), 0664))
+	hash, err := hashFile(requirementsFile)
+	require.NoError(t, err)
+
+	// The requirements were already installed, so pip is not run
+	b, err := json.Marshal(map[string]string{requirementsFile: hash})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, RequirementsFile), b, 0664))
+	require.NoError(t, InstallRequirements(path.Join(dir, "notpython"), dir, requirementsFile))
+
+	// Changing the requirements means that they need to be reinstalled
+	require.NoError(t, ioutil.WriteFile(requirementsFile, []byte("requests\nnumpy\n"), 0664))
+	newhash, err := hashFile(requirementsFile)
+	require.NoError(t, err)
+	require.NotEqual(t, newhash, readInstalledRequirements(dir)[requirementsFile])
+}
@@ -20,6 +20,17 @@ func main() {
 		logrus.Error(err)
 		os.Exit(1)
 	}
+
+	adb, err := p.AdminDB()
+	if err == nil {
+		err = registry.StartRegistry(adb, p.Meta, nil)
+	}
+	if err != nil {
+		p.Logger().Error(fmt.Errorf("Failed to open registry: %w", err))
+		p.Close()
+		os.Exit(1)
+	}
+	defer registry.StopRegistry(adb, p.Meta.APIKey)
 	pluginMiddleware := plugin.NewMiddleware(p, registry.Handler)
 
 	server := http.Server{
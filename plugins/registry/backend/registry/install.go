@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/heedy/heedy/backend/updater"
+	"github.com/heedy/heedy/plugins/registry/registry"
+)
+
+// MaxDownloadSize is the largest plugin zip file that will be downloaded from a release
+var MaxDownloadSize int64 = 500 << 20
+
+// DownloadTimeout is the longest a plugin's release zip file can take to download, so that a stalled
+// release host doesn't leave the install hanging
+var DownloadTimeout = 10 * time.Minute
+
+// Install downloads the plugin's release zip file, and passes it to the updater,
+// so that the plugin is installed the next time heedy restarts.
+func Install(configDir string, p *registry.Plugin) error {
+	if p.ReleaseURL == "" {
+		return errors.New("bad_request: The plugin does not have a release to install")
+	}
+	client := &http.Client{Timeout: DownloadTimeout}
+	resp, err := client.Get(p.ReleaseURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download %s: %s", p.ReleaseURL, resp.Status)
+	}
+
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "heedy-plugin-*.zip")
+	if err != nil {
+		return err
+	}
+	zipFile := tmpFile.Name()
+	defer func() {
+		tmpFile.Close()
+		os.Remove(zipFile)
+	}()
+
+	n, err := io.Copy(tmpFile, io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return err
+	}
+	if n > MaxDownloadSize {
+		return fmt.Errorf("The release of %s is larger than the maximum allowed size", p.Name)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return updater.UpdatePlugin(configDir, zipFile)
+}
@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/heedy/heedy/plugins/registry/registry"
+)
+
+func pluginZip(t *testing.T, name string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	f, err := zw.Create(name + "/heedy.conf")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`plugin "` + name + `" { version = "1.0.0" }`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return b.Bytes()
+}
+
+func TestInstall(t *testing.T) {
+	zipData := pluginZip(t, "myplugin")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/myplugin.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zipData)
+	})
+	stall := make(chan struct{})
+	mux.HandleFunc("/stalled.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zipData[:10])
+		w.(http.Flusher).Flush()
+		<-stall
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer close(stall)
+
+	configDir, err := ioutil.TempDir("", "heedy-registry-")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+	require.NoError(t, ioutil.WriteFile(path.Join(configDir, "heedy.conf"), []byte(""), 0644))
+
+	// Missing releases fail
+	p := &registry.Plugin{Name: "myplugin", ReleaseURL: srv.URL + "/notfound.zip"}
+	require.Error(t, Install(configDir, p))
+	p.ReleaseURL = ""
+	require.Error(t, Install(configDir, p))
+
+	// Downloads that stall time out
+	timeout := DownloadTimeout
+	DownloadTimeout = 100 * time.Millisecond
+	p.ReleaseURL = srv.URL + "/stalled.zip"
+	require.Error(t, Install(configDir, p))
+	DownloadTimeout = timeout
+
+	p.ReleaseURL = srv.URL + "/myplugin.zip"
+	require.NoError(t, Install(configDir, p))
+
+	_, err = os.Stat(path.Join(configDir, "updates", "plugins", "myplugin", "heedy.conf"))
+	require.NoError(t, err)
+	conf, err := ioutil.ReadFile(path.Join(configDir, "updates", "heedy.conf"))
+	require.NoError(t, err)
+	require.Contains(t, string(conf), "myplugin")
+}
@@ -1,14 +1,64 @@
 package registry
 
-import "github.com/heedy/heedy/backend/plugins/run"
+import (
+	"os"
+	"path"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/plugins/run"
+	"github.com/heedy/heedy/plugins/registry/registry"
+)
 
 const PluginName = "registry"
 
+var (
+	// Registry holds the plugins available for installation
+	Registry *registry.Registry
+	// Github is used to add plugins to the registry from their repositories
+	Github *registry.Github
+)
+
+// StartRegistry opens the registry database in heedy's data folder, creating it if it does not exist
+func StartRegistry(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
+	a := db.Assets()
+	regfile := path.Join(a.DataDir(), "registry.db")
+	var err error
+	if _, err = os.Stat(regfile); os.IsNotExist(err) {
+		Registry, err = registry.Create(regfile)
+	} else {
+		Registry, err = registry.Open(regfile)
+	}
+	if err != nil {
+		return err
+	}
+
+	var token, apiURL string
+	if p, ok := a.Config.Plugins[PluginName]; ok {
+		token, _ = p.Config["github_token"].(string)
+		apiURL, _ = p.Config["github_api"].(string)
+	}
+	Github = registry.NewGithubClient(token)
+	if apiURL != "" {
+		return Github.SetBaseURL(apiURL)
+	}
+	return nil
+}
+
+// StopRegistry closes the registry database
+func StopRegistry(db *database.AdminDB, apikey string) error {
+	if Registry == nil {
+		return nil
+	}
+	return Registry.Close()
+}
+
 // This is not needed for normal plugins. The init simply registers the plugin with heedy internals
 // for when it is compiled directly into the main heedy executable.
 func init() {
 	run.Builtin.Add(&run.BuiltinRunner{
 		Key:     PluginName,
+		Start:   StartRegistry,
+		Stop:    StopRegistry,
 		Handler: Handler,
 	})
 }
@@ -1,10 +1,148 @@
 package registry
 
 import (
+	"errors"
+	"net/http"
+
+	"github.com/blang/semver/v4"
 	"github.com/go-chi/chi"
+
+	"github.com/heedy/heedy/api/golang/rest"
+	"github.com/heedy/heedy/backend/buildinfo"
+	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/plugins/registry/registry"
 )
 
+func heedyVersion() semver.Version {
+	v, err := semver.Parse(buildinfo.Version)
+	if err != nil {
+		return semver.Version{}
+	}
+	return v
+}
+
+// requireUser makes sure that the request comes from a logged in user or heedy itself
+func requireUser(w http.ResponseWriter, r *http.Request) (*rest.Context, bool) {
+	c := rest.CTX(r)
+	if c.DB.Type() != database.UserType && c.DB.Type() != database.AdminType {
+		rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: You must be logged in to access the plugin registry"))
+		return nil, false
+	}
+	return c, true
+}
+
+// requireAdmin makes sure that the request comes from a heedy admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*rest.Context, bool) {
+	c := rest.CTX(r)
+	if c.DB.Type() != database.AdminType && !c.DB.AdminDB().Assets().Config.UserIsAdmin(c.DB.ID()) {
+		rest.WriteJSONError(w, r, http.StatusForbidden, errors.New("access_denied: Modifying plugins is admin-only"))
+		return nil, false
+	}
+	return c, true
+}
+
+func getPlugin(w http.ResponseWriter, r *http.Request) (*registry.Plugin, bool) {
+	p, err := Registry.Plugin(chi.URLParam(r, "pluginname"))
+	if err == registry.ErrNotFound {
+		rest.WriteJSONError(w, r, http.StatusNotFound, errors.New("not_found: The plugin is not in the registry"))
+		return nil, false
+	}
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return p, true
+}
+
+// SearchHandler lists the plugins in the registry
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+	var q registry.SearchQuery
+	if err := rest.QueryDecoder.Decode(&q, r.URL.Query()); err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	plugins, err := Registry.Search(&q, heedyVersion())
+	rest.WriteJSON(w, r, plugins, err)
+}
+
+// ReadHandler returns a single plugin's registry entry
+func ReadHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+	p, ok := getPlugin(w, r)
+	if !ok {
+		return
+	}
+	rest.WriteJSON(w, r, p, nil)
+}
+
+// AddHandler adds the plugin from the given github repository to the registry,
+// or updates its entry if it already exists
+func AddHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := rest.UnmarshalRequest(r, &body); err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	p, err := Github.Get(body.URL)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = Registry.Put(p); err != nil {
+		rest.WriteJSONError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	rest.WriteJSON(w, r, p, nil)
+}
+
+// DeleteHandler removes a plugin from the registry. It does not uninstall the plugin.
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	err := Registry.Delete(chi.URLParam(r, "pluginname"))
+	if err == registry.ErrNotFound {
+		err = errors.New("not_found: The plugin is not in the registry")
+	}
+	rest.WriteResult(w, r, err)
+}
+
+// InstallHandler downloads the plugin's release, and stages it for installation on heedy's next restart
+func InstallHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	p, ok := getPlugin(w, r)
+	if !ok {
+		return
+	}
+	if !p.Compatible(heedyVersion()) {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("bad_request: The plugin does not support this version of heedy"))
+		return
+	}
+	rest.WriteResult(w, r, Install(c.DB.AdminDB().Assets().FolderPath, p))
+}
+
+// Handler is the global router for the registry API
 var Handler = func() *chi.Mux {
 	m := chi.NewMux()
+
+	m.Get("/api/registry/plugins", SearchHandler)
+	m.Post("/api/registry/plugins", AddHandler)
+	m.Get("/api/registry/plugins/{pluginname}", ReadHandler)
+	m.Delete("/api/registry/plugins/{pluginname}", DeleteHandler)
+	m.Post("/api/registry/plugins/{pluginname}/install", InstallHandler)
+
 	return m
 }()
@@ -2,10 +2,11 @@ package registry
 
 import (
 	"database/sql"
-	// Make sure we include sqlite support
-
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/blang/semver/v4"
@@ -65,20 +66,50 @@ type Registry struct {
 
 // Plugin holds info about the plugin from the registry
 type Plugin struct {
-	Name         string
-	Icon         string
-	FullName     string
-	Description  string
-	Version      semver.Version
-	heedyVersion semver.Version
-	Webpage      string
-	ReleaseURL   string
-	Python       bool
-	License      string
-	Stars        int
-	Timestamp    time.Time
+	Name        string         `json:"name" db:"name"`
+	Icon        string         `json:"icon,omitempty" db:"icon"`
+	FullName    string         `json:"fullname" db:"fullname"`
+	Description string         `json:"description,omitempty" db:"description"`
+	Version     semver.Version `json:"version" db:"version"`
+	// HeedyVersion is the semver range of heedy versions that the plugin supports
+	HeedyVersion string    `json:"heedy_version,omitempty" db:"heedy_version"`
+	Webpage      string    `json:"webpage,omitempty" db:"webpage"`
+	ReleaseURL   string    `json:"release_url,omitempty" db:"release_url"`
+	Python       bool      `json:"python" db:"python"`
+	License      string    `json:"license,omitempty" db:"license"`
+	Stars        int       `json:"stars" db:"stars"`
+	Timestamp    time.Time `json:"timestamp" db:"-"`
+}
+
+// Compatible returns whether the plugin supports the given heedy version. Plugins
+// without a heedy_version are assumed to support all versions.
+func (p *Plugin) Compatible(heedyVersion semver.Version) bool {
+	if p.HeedyVersion == "" {
+		return true
+	}
+	vrange, err := semver.ParseRange(p.HeedyVersion)
+	if err != nil {
+		return false
+	}
+	return vrange(heedyVersion)
 }
 
+// SearchQuery gives the plugins to return from the registry
+type SearchQuery struct {
+	// Text is searched for in the plugin's name, full name and description
+	Text string `json:"q,omitempty" schema:"q"`
+	// Sort is one of stars (default), recent or name
+	Sort string `json:"sort,omitempty" schema:"sort"`
+	// If Compatible is true, only plugins that support the heedy version are returned
+	Compatible bool `json:"compatible,omitempty" schema:"compatible"`
+
+	Limit  int `json:"limit,omitempty" schema:"limit"`
+	Offset int `json:"offset,omitempty" schema:"offset"`
+}
+
+// ErrNotFound is returned when the plugin is not in the registry
+var ErrNotFound = errors.New("not_found: The plugin was not found in the registry")
+
 // Create generates a new regsitry database file
 func Create(filename string) (*Registry, error) {
 
@@ -155,7 +186,7 @@ func Open(filename string) (*Registry, error) {
 	r.RegistryVersion = ver
 
 	// And finally, check if there is a heedy version specified
-	if v, err = r.Get("registry"); err != nil {
+	if v, err = r.Get("heedy"); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -192,13 +223,118 @@ func (r *Registry) Get(key string) (string, error) {
 // Set sets the given key
 func (r *Registry) Set(key string, value string) error {
 	if value == "" {
-		_, err := r.db.Exec("DELETE FROM metadata WHERE key=?", key)
+		_, err := r.db.Exec("DELETE FROM metadata WHERE k=?", key)
 		return err
 	}
 	_, err := r.db.Exec("INSERT OR REPLACE INTO metadata (k,v) VALUES (?,?);", key, value)
 	return err
 }
 
+const pluginColumns = `name, COALESCE(icon,'') AS icon, fullname, COALESCE(description,'') AS description, version,
+	COALESCE(heedy_version,'') AS heedy_version, COALESCE(webpage,'') AS webpage, COALESCE(release_url,'') AS release_url,
+	COALESCE(python,false) AS python, COALESCE(license,'') AS license, COALESCE(stars,0) AS stars, COALESCE(timestamp,0) AS timestamp`
+
+type pluginRow struct {
+	Plugin
+	Unix int64 `db:"timestamp"`
+}
+
+func (pr *pluginRow) plugin() *Plugin {
+	p := pr.Plugin
+	p.Timestamp = time.Unix(pr.Unix, 0)
+	return &p
+}
+
+// Put adds the plugin to the registry, replacing any existing plugin with the same name
+func (r *Registry) Put(p *Plugin) error {
+	ts := p.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	_, err := r.db.Exec(`INSERT OR REPLACE INTO plugins (name,icon,fullname,description,version,heedy_version,webpage,release_url,python,license,stars,timestamp)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`, p.Name, nullString(p.Icon), p.FullName, nullString(p.Description), p.Version.String(),
+		nullString(p.HeedyVersion), nullString(p.Webpage), nullString(p.ReleaseURL), p.Python, nullString(p.License), p.Stars, ts.Unix())
+	return err
+}
+
+// nullString stores empty strings as NULL, so that they do not conflict in unique columns
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Plugin returns the plugin with the given name
+func (r *Registry) Plugin(name string) (*Plugin, error) {
+	var pr pluginRow
+	err := r.db.Get(&pr, fmt.Sprintf("SELECT %s FROM plugins WHERE name=?", pluginColumns), name)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pr.plugin(), nil
+}
+
+// Delete removes the plugin from the registry
+func (r *Registry) Delete(name string) error {
+	res, err := r.db.Exec("DELETE FROM plugins WHERE name=?", name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Search returns the plugins matching the query. The heedy version is used to filter
+// out incompatible plugins when q.Compatible is set.
+func (r *Registry) Search(q *SearchQuery, heedyVersion semver.Version) ([]*Plugin, error) {
+	order := "stars DESC, name ASC"
+	switch q.Sort {
+	case "", "stars":
+	case "recent":
+		order = "timestamp DESC, name ASC"
+	case "name":
+		order = "name ASC"
+	default:
+		return nil, fmt.Errorf("bad_query: Unrecognized sort order '%s'", q.Sort)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, errors.New("bad_query: limit and offset must be non-negative")
+	}
+	where := ""
+	args := []interface{}{}
+	if q.Text != "" {
+		like := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(q.Text) + "%"
+		where = "WHERE name LIKE ? ESCAPE '\\' OR fullname LIKE ? ESCAPE '\\' OR description LIKE ? ESCAPE '\\'"
+		args = append(args, like, like, like)
+	}
+	var rows []pluginRow
+	err := r.db.Select(&rows, fmt.Sprintf("SELECT %s FROM plugins %s ORDER BY %s", pluginColumns, where, order), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compatibility uses semver ranges, so it is filtered here rather than in the query
+	plugins := make([]*Plugin, 0, len(rows))
+	for i := range rows {
+		p := rows[i].plugin()
+		if q.Compatible && !p.Compatible(heedyVersion) {
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+	if q.Offset >= len(plugins) {
+		return []*Plugin{}, nil
+	}
+	plugins = plugins[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(plugins) {
+		plugins = plugins[:q.Limit]
+	}
+	return plugins, nil
+}
+
 // Close closes the underlying database
 func (r *Registry) Close() error {
 	return r.db.Close()
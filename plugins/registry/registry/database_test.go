@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/blang/semver/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,3 +39,86 @@ func TestDatabase(t *testing.T) {
 	db.Close()
 
 }
+
+func TestSearch(t *testing.T) {
+	os.RemoveAll("./test_search.db")
+	defer os.RemoveAll("./test_search.db")
+	db, err := Create("./test_search.db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	require.NoError(t, db.Put(&Plugin{
+		Name:         "notes",
+		FullName:     "heedy/notes",
+		Description:  "Take notes",
+		Version:      semver.MustParse("1.0.0"),
+		HeedyVersion: ">=0.4.0",
+		ReleaseURL:   "https://example.com/notes.zip",
+		Stars:        10,
+		Timestamp:    now.Add(-time.Hour),
+	}))
+	require.NoError(t, db.Put(&Plugin{
+		Name:         "fitbit",
+		FullName:     "heedy/fitbit",
+		Description:  "Sync 100% of your fitbit data",
+		Version:      semver.MustParse("0.2.0"),
+		HeedyVersion: "<0.4.0",
+		ReleaseURL:   "https://example.com/fitbit.zip",
+		Stars:        50,
+		Timestamp:    now,
+	}))
+
+	p, err := db.Plugin("notes")
+	require.NoError(t, err)
+	require.Equal(t, "1.0.0", p.Version.String())
+	require.Equal(t, now.Add(-time.Hour).Unix(), p.Timestamp.Unix())
+	_, err = db.Plugin("nope")
+	require.Equal(t, ErrNotFound, err)
+
+	hv := semver.MustParse("0.4.1")
+	res, err := db.Search(&SearchQuery{}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, "fitbit", res[0].Name)
+
+	res, err = db.Search(&SearchQuery{Sort: "name"}, hv)
+	require.NoError(t, err)
+	require.Equal(t, "fitbit", res[0].Name)
+
+	res, err = db.Search(&SearchQuery{Sort: "recent", Limit: 1, Offset: 1}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "notes", res[0].Name)
+
+	res, err = db.Search(&SearchQuery{Compatible: true}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "notes", res[0].Name)
+
+	// Text is matched literally
+	res, err = db.Search(&SearchQuery{Text: "100%"}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "fitbit", res[0].Name)
+
+	res, err = db.Search(&SearchQuery{Text: "0%"}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	res, err = db.Search(&SearchQuery{Text: "_"}, hv)
+	require.NoError(t, err)
+	require.Len(t, res, 0)
+
+	_, err = db.Search(&SearchQuery{Sort: "bad"}, hv)
+	require.Error(t, err)
+
+	// Replacing a plugin updates it
+	p.Stars = 100
+	require.NoError(t, db.Put(p))
+	res, err = db.Search(&SearchQuery{}, hv)
+	require.NoError(t, err)
+	require.Equal(t, "notes", res[0].Name)
+
+	require.NoError(t, db.Delete("notes"))
+	require.Equal(t, ErrNotFound, db.Delete("notes"))
+}
@@ -2,13 +2,13 @@ package registry
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/blang/semver/v4"
 	"github.com/google/go-github/v24/github"
@@ -27,7 +27,7 @@ func NewGithubClient(key string) *Github {
 		ctx: context.Background(),
 	}
 	if key != "" {
-		ts := oauth2.StaticTokenObject(
+		ts := oauth2.StaticTokenSource(
 			&oauth2.Token{AccessToken: key},
 		)
 		tc := oauth2.NewClient(g.ctx, ts)
@@ -39,6 +39,19 @@ func NewGithubClient(key string) *Github {
 	return g
 }
 
+// SetBaseURL sets the URL of the github API, allowing use of GitHub Enterprise servers
+func (g *Github) SetBaseURL(apiURL string) error {
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return err
+	}
+	g.client.BaseURL = u
+	return nil
+}
+
 // Get uses a link to a repo, and extracts all the info necessary to add a plugin to the registry.
 // It also validates several properties of the repository, such as a valid license, not archived,
 // and that it includes the "heedy" topic
@@ -57,7 +70,7 @@ func (g *Github) Get(link string) (*Plugin, error) {
 		return nil, errors.New("Link must be to github.com")
 	}
 	s := strings.Split(u.Path, "/")
-	if len(s) < 3 || len(s[0]) > 0 {
+	if len(s) < 3 || len(s[0]) > 0 || s[1] == "" || s[2] == "" {
 		return nil, errors.New("Github url must be in form github.com/{user}/{repo}")
 	}
 
@@ -65,8 +78,18 @@ func (g *Github) Get(link string) (*Plugin, error) {
 	if err != nil {
 		return nil, err
 	}
-	b, _ := json.MarshalIndent(r, "", "  ")
-	fmt.Printf("Response: %s", string(b))
+	if r.Archived != nil && *r.Archived {
+		return nil, errors.New("The repository is archived")
+	}
+	hasTopic := false
+	for _, t := range r.Topics {
+		if t == "heedy" {
+			hasTopic = true
+		}
+	}
+	if !hasTopic {
+		return nil, errors.New("The repository must have the 'heedy' topic")
+	}
 
 	rr, _, err := g.client.Repositories.GetLatestRelease(g.ctx, s[1], s[2])
 	if err != nil {
@@ -88,8 +111,17 @@ func (g *Github) Get(link string) (*Plugin, error) {
 		return nil, err
 	}
 
-	b, _ = json.MarshalIndent(rr, "", "  ")
-	fmt.Printf("\n\nResponse: %s", string(b))
+	// The release must include the plugin's zip file
+	releaseURL := ""
+	for _, a := range rr.Assets {
+		if a.Name != nil && a.BrowserDownloadURL != nil && strings.HasSuffix(*a.Name, ".zip") {
+			releaseURL = *a.BrowserDownloadURL
+			break
+		}
+	}
+	if releaseURL == "" {
+		return nil, fmt.Errorf("Release %s does not include a zip file of the plugin", *rr.TagName)
+	}
 
 	// And download the heedy.conf file
 	heedyloc := "heedy.conf"
@@ -114,22 +146,61 @@ func (g *Github) Get(link string) (*Plugin, error) {
 		return nil, err
 	}
 
-	fmt.Printf("\nConfig file:\n-------------\n%s\n---------------\n\n", string(cf))
-
 	cfg, err := assets.LoadConfigBytes(cf, heedyloc)
 	if err != nil {
 		return nil, err
 	}
 
-	b, _ = json.MarshalIndent(cfg, "", "  ")
-	fmt.Printf("\n\nResponse:\n%s", string(b))
-
-	if len(cfg.Plugins) < 0 || len(cfg.Plugins) > 1 {
+	if len(cfg.Plugins) != 1 {
 		return nil, errors.New("There must be exactly one plugin defined in heedy.conf")
 	}
 
 	p := &Plugin{
-		Version: pluginversion,
+		FullName:   s[1] + "/" + s[2],
+		Version:    pluginversion,
+		Webpage:    "https://github.com/" + s[1] + "/" + s[2],
+		ReleaseURL: releaseURL,
+		Timestamp:  time.Now(),
+	}
+	for pname, pv := range cfg.Plugins {
+		p.Name = pname
+		if pv.Description != nil {
+			p.Description = *pv.Description
+		}
+		if pv.Icon != nil {
+			p.Icon = *pv.Icon
+		}
+		if pv.License != nil {
+			p.License = *pv.License
+		}
+		if pv.HeedyVersion != nil {
+			p.HeedyVersion = *pv.HeedyVersion
+		}
+		for _, rv := range pv.Run {
+			if rv.Type != nil && *rv.Type == "python" {
+				p.Python = true
+			}
+		}
+	}
+	if p.License == "" && r.License != nil && r.License.SPDXID != nil {
+		p.License = *r.License.SPDXID
+	}
+	if p.License == "" {
+		return nil, errors.New("The plugin must have a license")
+	}
+	if p.HeedyVersion != "" {
+		if _, err = semver.ParseRange(p.HeedyVersion); err != nil {
+			return nil, fmt.Errorf("Invalid heedy_version: %w", err)
+		}
+	}
+	if r.FullName != nil {
+		p.FullName = *r.FullName
+	}
+	if r.HTMLURL != nil {
+		p.Webpage = *r.HTMLURL
+	}
+	if rr.PublishedAt != nil {
+		p.Timestamp = rr.PublishedAt.Time
 	}
 
 	if r.StargazersCount != nil {
@@ -1,13 +1,75 @@
 package registry
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
-func TestGithub(t *testing.T) {
+const fixtureConfig = `
+plugin "myplugin" {
+	version = "1.2.0"
+	description = "A test plugin"
+	license = "Apache-2.0"
+	heedy_version = ">=0.4.0"
+
+	run "server" {
+		type = "python"
+		path = "main.py"
+	}
+}
+`
+
+// newGithubFixture creates a server that responds to the github API requests made by Github.Get
+func newGithubFixture(t *testing.T) (*httptest.Server, *Github) {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+	mux.HandleFunc("/repos/heedy/myplugin", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"full_name":        "heedy/myplugin",
+			"html_url":         "https://github.com/heedy/myplugin",
+			"stargazers_count": 42,
+			"topics":           []string{"heedy"},
+		})
+	})
+	mux.HandleFunc("/repos/heedy/archived", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"full_name": "heedy/archived", "archived": true, "topics": []string{"heedy"}})
+	})
+	mux.HandleFunc("/repos/heedy/myplugin/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"tag_name":     "v1.2.0",
+			"published_at": "2021-06-01T00:00:00Z",
+			"assets": []map[string]interface{}{
+				{"name": "myplugin-1.2.0.zip", "browser_download_url": srv.URL + "/download/myplugin.zip"},
+			},
+		})
+	})
+	mux.HandleFunc("/repos/heedy/myplugin/contents/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "v1.2.0", r.URL.Query().Get("ref"))
+		writeJSON(w, []map[string]interface{}{
+			{"name": "heedy.conf", "type": "file", "download_url": srv.URL + "/raw/heedy.conf"},
+		})
+	})
+	mux.HandleFunc("/raw/heedy.conf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fixtureConfig))
+	})
+	srv = httptest.NewServer(mux)
+
 	g := NewGithubClient("")
+	require.NoError(t, g.SetBaseURL(srv.URL))
+	return srv, g
+}
+
+func TestGithub(t *testing.T) {
+	srv, g := newGithubFixture(t)
+	defer srv.Close()
 
 	_, err := g.Get("gerhub.com/dkumor/test")
 	require.Error(t, err)
@@ -18,10 +80,18 @@ func TestGithub(t *testing.T) {
 	_, err = g.Get("http://github.com/")
 	require.Error(t, err)
 
-	p, err := g.Get("http://github.com/heedy/heedy-analysis")
-	// require.Error(t, err, "heedy's default config is in asset folder, but does not define any plugins")
+	_, err = g.Get("github.com/heedy/archived")
+	require.Error(t, err)
 
+	p, err := g.Get("https://github.com/heedy/myplugin")
 	require.NoError(t, err)
-	require.Equal(t, p.Stars, 157)
-
+	require.Equal(t, "myplugin", p.Name)
+	require.Equal(t, "heedy/myplugin", p.FullName)
+	require.Equal(t, "1.2.0", p.Version.String())
+	require.Equal(t, ">=0.4.0", p.HeedyVersion)
+	require.Equal(t, "Apache-2.0", p.License)
+	require.Equal(t, srv.URL+"/download/myplugin.zip", p.ReleaseURL)
+	require.Equal(t, 42, p.Stars)
+	require.True(t, p.Python)
+	require.Equal(t, 2021, p.Timestamp.Year())
 }
@@ -0,0 +1,405 @@
+package timeseries
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/heedy/heedy/backend/events"
+	"github.com/jmoiron/sqlx"
+)
+
+// cacheEntry is a cached array of datapoints, along with the time range of the timeseries it depends on
+type cacheEntry struct {
+	key   string
+	table string
+	tsid  string
+	t1    float64
+	t2    float64
+	data  DatapointArray
+}
+
+// CacheStats gives the usage statistics of a cache, allowing tuning of its size
+type CacheStats struct {
+	Size          int     `json:"size"`
+	Capacity      int     `json:"capacity"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	Evictions     uint64  `json:"evictions"`
+	Invalidations uint64  `json:"invalidations"`
+	HitRate       float64 `json:"hit_rate"`
+}
+
+// lruCache is a least-recently-used cache of datapoint arrays. It is not thread-safe,
+// and is protected by the QueryCache's lock.
+type lruCache struct {
+	capacity int
+	ll       *list.List
+	entries  map[string]*list.Element
+	stats    CacheStats
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (DatapointArray, bool) {
+	if e, ok := c.entries[key]; ok {
+		c.stats.Hits++
+		c.ll.MoveToFront(e)
+		return e.Value.(*cacheEntry).data, true
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+func (c *lruCache) add(ce *cacheEntry) {
+	if c.capacity <= 0 {
+		return
+	}
+	if e, ok := c.entries[ce.key]; ok {
+		e.Value = ce
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[ce.key] = c.ll.PushFront(ce)
+	for c.ll.Len() > c.capacity {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes all entries of the timeseries that depend on data in the given time range
+func (c *lruCache) invalidate(table, tsid string, t1, t2 float64) {
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		ce := e.Value.(*cacheEntry)
+		if ce.tsid == tsid && ce.table == table && ce.t1 <= t2 && ce.t2 >= t1 {
+			c.ll.Remove(e)
+			delete(c.entries, ce.key)
+			c.stats.Invalidations++
+		}
+		e = next
+	}
+}
+
+func (c *lruCache) getStats() CacheStats {
+	s := c.stats
+	s.Size = c.ll.Len()
+	s.Capacity = c.capacity
+	if s.Hits+s.Misses > 0 {
+		s.HitRate = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	return s
+}
+
+// QueryCache holds recently decoded batches and recent query results in memory, so that repeated queries,
+// such as those run by dashboards, don't need to decompress the same data over and over. Entries are
+// invalidated by the timeseries_data_write and timeseries_data_delete events for their time ranges.
+// A nil QueryCache caches nothing.
+type QueryCache struct {
+	sync.Mutex
+
+	// MaxQueryLength is the maximum number of datapoints in a query result that is cached
+	MaxQueryLength int
+
+	batches *lruCache
+	queries *lruCache
+
+	// The generation of each timeseries is incremented on every invalidation, so that
+	// data read before a write finishes is never added to the cache after the write's invalidation.
+	generation map[string]uint64
+}
+
+// CacheStatistics gives the statistics of the batch and query caches
+type CacheStatistics struct {
+	Batches CacheStats `json:"batches"`
+	Queries CacheStats `json:"queries"`
+}
+
+// NewQueryCache creates a cache holding up to the given number of batches and query results
+func NewQueryCache(batches, queries, maxQueryLength int) *QueryCache {
+	return &QueryCache{
+		MaxQueryLength: maxQueryLength,
+		batches:        newLRUCache(batches),
+		queries:        newLRUCache(queries),
+		generation:     make(map[string]uint64),
+	}
+}
+
+// Stats returns the current cache statistics
+func (qc *QueryCache) Stats() CacheStatistics {
+	if qc == nil {
+		return CacheStatistics{}
+	}
+	qc.Lock()
+	defer qc.Unlock()
+	return CacheStatistics{
+		Batches: qc.batches.getStats(),
+		Queries: qc.queries.getStats(),
+	}
+}
+
+func (qc *QueryCache) getGeneration(table, tsid string) uint64 {
+	if qc == nil {
+		return 0
+	}
+	qc.Lock()
+	defer qc.Unlock()
+	return qc.generation[table+"/"+tsid]
+}
+
+// Invalidate removes all cached data of the timeseries that depends on the given time range
+func (qc *QueryCache) Invalidate(table, tsid string, t1, t2 float64) {
+	if qc == nil {
+		return
+	}
+	qc.Lock()
+	defer qc.Unlock()
+	qc.generation[table+"/"+tsid]++
+	qc.batches.invalidate(table, tsid, t1, t2)
+	qc.queries.invalidate(table, tsid, t1, t2)
+}
+
+// Fire implements events.Handler, invalidating the cache on timeseries writes and deletes
+func (qc *QueryCache) Fire(e *events.Event) {
+	if qc == nil || e.Object == "" {
+		return
+	}
+	switch e.Event {
+	case "timeseries_data_write", "timeseries_actions_write":
+		table := "timeseries"
+		if e.Event == "timeseries_actions_write" {
+			table = "timeseries_actions"
+		}
+		if we, ok := e.Data.(*TimeseriesWriteEvent); ok {
+			qc.Invalidate(table, e.Object, we.T1, we.T2)
+			return
+		}
+		qc.Invalidate(table, e.Object, math.Inf(-1), math.Inf(1))
+	case "timeseries_data_delete":
+		q, ok := e.Data.(Query)
+		if !ok {
+			qc.Invalidate("timeseries", e.Object, math.Inf(-1), math.Inf(1))
+			qc.Invalidate("timeseries_actions", e.Object, math.Inf(-1), math.Inf(1))
+			return
+		}
+		table := "timeseries"
+		if q.Actions != nil && *q.Actions {
+			table = "timeseries_actions"
+		}
+		t1, t2, ok := q.timeRange()
+		if !ok {
+			t1, t2 = math.Inf(-1), math.Inf(1)
+		}
+		qc.Invalidate(table, e.Object, t1, t2)
+	}
+}
+
+func (qc *QueryCache) getBatch(key string) (DatapointArray, bool) {
+	qc.Lock()
+	defer qc.Unlock()
+	dpa, ok := qc.batches.get(key)
+	if ok {
+		// The batch is copied, so that slicing and appending by the caller can't modify the cached version
+		dpa = append(DatapointArray(nil), dpa...)
+	}
+	return dpa, ok
+}
+
+// add adds the entry to the given cache, if the timeseries was not modified since gen was read
+func (qc *QueryCache) add(c *lruCache, gen uint64, ce *cacheEntry) {
+	qc.Lock()
+	defer qc.Unlock()
+	if qc.generation[ce.table+"/"+ce.tsid] == gen {
+		c.add(ce)
+	}
+}
+
+// timeRange returns the time range of the timeseries that the query's result depends on, and whether
+// the query is absolute, meaning that running it again gives the same result if the data in the range
+// is unchanged. Queries with relative timestamps like "now" are not absolute.
+func (q *Query) timeRange() (t1 float64, t2 float64, ok bool) {
+	t1, t2 = math.Inf(-1), math.Inf(1)
+	for _, v := range []interface{}{q.T, q.T1, q.T2} {
+		if s, isString := v.(string); isString {
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				return t1, t2, false
+			}
+		}
+	}
+	if q.I != nil || q.I1 != nil || q.I2 != nil {
+		// Indices depend on all of the data in the timeseries
+		return t1, t2, true
+	}
+	if q.T != nil {
+		t, err := ParseTimestamp(q.T)
+		return t, t, err == nil
+	}
+	var err error
+	if q.T1 != nil {
+		if t1, err = ParseTimestamp(q.T1); err != nil {
+			return t1, t2, false
+		}
+	}
+	if q.T2 != nil {
+		if t2, err = ParseTimestamp(q.T2); err != nil {
+			return t1, t2, false
+		}
+	}
+	return t1, t2, true
+}
+
+// CachedBatchIterator reads batches from the result of a query for tstart,tend,length and data, using
+// the cache for batches that were already decoded
+type CachedBatchIterator struct {
+	Rows   *sqlx.Rows
+	Closer func()
+
+	cache      *QueryCache
+	table      string
+	tsid       string
+	generation uint64
+}
+
+// NewCachedBatchIterator returns an iterator over the given rows, which come from the given table and timeseries.
+// The generation must be read from the cache before running the query.
+func NewCachedBatchIterator(cache *QueryCache, rows *sqlx.Rows, closer func(), table, tsid string, generation uint64) *CachedBatchIterator {
+	return &CachedBatchIterator{
+		Rows:       rows,
+		Closer:     closer,
+		cache:      cache,
+		table:      table,
+		tsid:       tsid,
+		generation: generation,
+	}
+}
+
+func (b *CachedBatchIterator) Close() error {
+	if b.Closer != nil {
+		defer b.Closer()
+		b.Closer = nil
+	}
+	return b.Rows.Close()
+}
+
+func (b *CachedBatchIterator) NextBatch() (DatapointArray, error) {
+	if !b.Rows.Next() {
+		b.Rows.Close()
+		return nil, nil
+	}
+	var tstart, tend float64
+	var length int64
+	var raw sql.RawBytes
+	err := b.Rows.Scan(&tstart, &tend, &length, &raw)
+	if err != nil {
+		b.Rows.Close()
+		return nil, err
+	}
+	if b.cache == nil {
+		return DatapointArrayFromBytes(raw)
+	}
+	// A batch's range and length change whenever it is rewritten by inserts outside its range,
+	// so they are part of the key
+	key := fmt.Sprintf("%s/%s/%v/%v/%d", b.table, b.tsid, tstart, tend, length)
+	if dpa, ok := b.cache.getBatch(key); ok {
+		return dpa, nil
+	}
+	dpa, err := DatapointArrayFromBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.add(b.cache.batches, b.generation, &cacheEntry{
+		key:   key,
+		table: b.table,
+		tsid:  b.tsid,
+		t1:    tstart,
+		t2:    tend,
+		data:  append(DatapointArray(nil), dpa...),
+	})
+	return dpa, nil
+}
+
+// CachingIterator records the datapoints returned by a query, and adds them to the query cache
+// once the query is read to the end
+type CachingIterator struct {
+	it         DatapointIterator
+	cache      *QueryCache
+	entry      *cacheEntry
+	generation uint64
+}
+
+func (c *CachingIterator) Next() (*Datapoint, error) {
+	dp, err := c.it.Next()
+	if c.entry == nil {
+		return dp, err
+	}
+	if err != nil {
+		c.entry = nil
+		return dp, err
+	}
+	if dp == nil {
+		c.cache.add(c.cache.queries, c.generation, c.entry)
+		c.entry = nil
+		return nil, nil
+	}
+	if len(c.entry.data) >= c.cache.MaxQueryLength {
+		// The result is too large to cache
+		c.entry = nil
+		return dp, nil
+	}
+	c.entry.data = append(c.entry.data, dp)
+	return dp, nil
+}
+
+func (c *CachingIterator) Close() error {
+	return c.it.Close()
+}
+
+// cachedQuery returns the cached result of the query if it exists. If it doesn't, but the query's
+// result can be cached, the returned function wraps the query's iterator so that its result is cached.
+func (qc *QueryCache) cachedQuery(q *Query) (DatapointIterator, func(DatapointIterator) DatapointIterator) {
+	noop := func(it DatapointIterator) DatapointIterator { return it }
+	if qc == nil || qc.MaxQueryLength <= 0 || q.Timeseries == "" {
+		return nil, noop
+	}
+	t1, t2, ok := q.timeRange()
+	if !ok {
+		return nil, noop
+	}
+	table := "timeseries"
+	if q.Actions != nil && *q.Actions {
+		table = "timeseries_actions"
+	}
+	key := table + "/" + q.String()
+
+	qc.Lock()
+	defer qc.Unlock()
+	if dpa, ok := qc.queries.get(key); ok {
+		return NewDatapointArrayIterator(dpa), noop
+	}
+	gen := qc.generation[table+"/"+q.Timeseries]
+	return nil, func(it DatapointIterator) DatapointIterator {
+		return &CachingIterator{
+			it:    it,
+			cache: qc,
+			entry: &cacheEntry{
+				key:   key,
+				table: table,
+				tsid:  q.Timeseries,
+				t1:    t1,
+				t2:    t2,
+			},
+			generation: gen,
+		}
+	}
+}
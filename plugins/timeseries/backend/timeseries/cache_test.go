@@ -0,0 +1,80 @@
+package timeseries
+
+import (
+	"testing"
+
+	"github.com/heedy/heedy/backend/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryCache(t *testing.T) {
+	adb, oid1, _, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	s := TimeseriesDB{
+		DB:                    adb,
+		BatchSize:             2,
+		MaxBatchSize:          3,
+		BatchCompressionLevel: 3,
+		Cache:                 NewQueryCache(10, 10, 100),
+	}
+
+	require.NoError(t, s.Insert(oid1, NewDatapointArrayIterator(dpa6), nil))
+
+	cmpQuery(t, s, &Query{Timeseries: oid1}, dpa6)
+	st := s.Cache.Stats()
+	require.Equal(t, uint64(0), st.Queries.Hits)
+	require.Equal(t, uint64(0), st.Batches.Hits)
+	require.Equal(t, 1, st.Queries.Size)
+
+	cmpQuery(t, s, &Query{Timeseries: oid1}, dpa6)
+	st = s.Cache.Stats()
+	require.Equal(t, uint64(1), st.Queries.Hits)
+	require.Equal(t, 0.5, st.Queries.HitRate)
+
+	// A different query reuses the decoded batches
+	cmpQuery(t, s, &Query{Timeseries: oid1, T1: 2.0}, dpa6[1:])
+	st = s.Cache.Stats()
+	require.NotEqual(t, uint64(0), st.Batches.Hits)
+
+	// Relative queries are not cached
+	cmpQuery(t, s, &Query{Timeseries: oid1, T2: "now"}, dpa6)
+	require.Equal(t, 2, s.Cache.Stats().Queries.Size)
+
+	// Writing after the end of the range of the T2 query invalidates only the queries that depend on it
+	cmpQuery(t, s, &Query{Timeseries: oid1, T2: 3.0}, dpa6[:2])
+	require.Equal(t, 3, s.Cache.Stats().Queries.Size)
+
+	dp := DatapointArray{&Datapoint{6.0, 0, 6.0, ""}}
+	require.NoError(t, s.Insert(oid1, NewDatapointArrayIterator(dp), nil))
+	s.Cache.Fire(&events.Event{
+		Event:  "timeseries_data_write",
+		Object: oid1,
+		Data:   &TimeseriesWriteEvent{T1: 6.0, T2: 6.0, Count: 1},
+	})
+	require.Equal(t, 1, s.Cache.Stats().Queries.Size)
+
+	cmpQuery(t, s, &Query{Timeseries: oid1}, append(dpa6, dp...))
+	cmpQuery(t, s, &Query{Timeseries: oid1, T2: 3.0}, dpa6[:2])
+	require.Equal(t, uint64(2), s.Cache.Stats().Queries.Hits)
+
+	// Deletes invalidate the cache
+	q := Query{Timeseries: oid1, T1: 5.0}
+	require.NoError(t, s.Delete(&q))
+	s.Cache.Fire(&events.Event{
+		Event:  "timeseries_data_delete",
+		Object: oid1,
+		Data:   q,
+	})
+	cmpQuery(t, s, &Query{Timeseries: oid1}, dpa6[:4])
+
+	// Data read before an invalidation is not added to the cache
+	it, err := s.Query(&Query{Timeseries: oid1, T1: 1.0})
+	require.NoError(t, err)
+	s.Cache.Invalidate("timeseries", oid1, 0, 1)
+	_, err = NewArrayFromIterator(it)
+	require.NoError(t, err)
+	size := s.Cache.Stats().Queries.Size
+	cmpQuery(t, s, &Query{Timeseries: oid1, T1: 1.0}, dpa6[:4])
+	require.Equal(t, size+1, s.Cache.Stats().Queries.Size)
+}
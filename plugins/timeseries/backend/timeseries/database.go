@@ -38,7 +38,7 @@ of the direct approach.
 
 */
 
-var SQLVersion = 1
+var SQLVersion = 2
 
 // sqlSchema is initialized in plugin.go (SQLUpdater)
 const sqlSchema = `
@@ -52,6 +52,12 @@ CREATE TABLE timeseries (
 	-- timeseries data comes as zstandard-compressed msgpack array batches
 	data BLOB,
 
+	-- summary of the numeric datapoints in the batch, allowing statistics without reading data
+	num_count INTEGER NOT NULL DEFAULT 0,
+	num_min REAL,
+	num_max REAL,
+	num_sum REAL,
+
 	PRIMARY KEY (tsid,tstart),
 	CONSTRAINT valid_range CHECK (tstart <= tend AND length > 0),
 
@@ -188,6 +194,13 @@ type TimeseriesDB struct {
 	MaxBatchSize          int               `mapstructure:"max_batch_size"`
 	BatchCompressionLevel int               `mapstructure:"batch_compression_level"`
 	CompressQueryResponse bool              `mapstructure:"compress_query_response"`
+
+	CacheBatches        int `mapstructure:"cache_batches"`
+	CacheQueries        int `mapstructure:"cache_queries"`
+	CacheQueryMaxLength int `mapstructure:"cache_query_max_length"`
+
+	// Cache holds decoded batches and query results. It is nil if caching is disabled.
+	Cache *QueryCache `mapstructure:"-"`
 }
 
 func (ts *TimeseriesDB) Length(tsid string, actions bool) (l int64, err error) {
@@ -211,6 +224,19 @@ type Query struct {
 	I          *int64      `json:"i,omitempty" schema:"i"`
 	Transform  *string     `json:"transform,omitempty" schema:"transform"`
 	Actions    *bool       `json:"actions,omitempty" schema:"actions"`
+
+	// Timezone is the IANA timezone in which relative timestamps such as "today" are resolved.
+	// The server's timezone is used if empty.
+	Timezone string `json:"timezone,omitempty" schema:"timezone"`
+}
+
+func (q *Query) location() *time.Location {
+	if q.Timezone != "" {
+		if loc, err := time.LoadLocation(q.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
 }
 
 // String returns a json representation of the datapoint
@@ -228,6 +254,9 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 	if q.Actions != nil && *q.Actions {
 		table = "timeseries_actions"
 	}
+	// The cache generation is read before querying, so that batches read before a concurrent write
+	// are not cached after the write invalidates them
+	gen := ts.Cache.getGeneration(table, q.Timeseries)
 	constraints := []string{"tsid=?"}
 	cValues := []interface{}{q.Timeseries}
 
@@ -236,7 +265,7 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 
 	// The timestamps are parsed here, because they are used in both time range and index queries
 	if q.T1 != nil {
-		t1, err = ParseTimestamp(q.T1)
+		t1, err = ParseTimestampIn(q.T1, q.location())
 		if err != nil {
 			return nil, err
 		}
@@ -244,7 +273,7 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 		cValues = append(cValues, t1)
 	}
 	if q.T2 != nil {
-		t2, err = ParseTimestamp(q.T2)
+		t2, err = ParseTimestampIn(q.T2, q.location())
 		if err != nil {
 			return nil, err
 		}
@@ -262,15 +291,15 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 			if q.T1 != nil || q.T2 != nil {
 				return nil, errors.New("bad_query: Cannot query by range and by single timestamp at the same time")
 			}
-			t, err := ParseTimestamp(q.T)
+			t, err := ParseTimestampIn(q.T, q.location())
 			if err != nil {
 				return nil, err
 			}
-			rows, err := ts.DB.Queryx(fmt.Sprintf("SELECT data FROM %s WHERE tsid=? AND tstart<=? AND tend >=? ORDER BY tstart ASC", table), q.Timeseries, t, t)
+			rows, err := ts.DB.Queryx(fmt.Sprintf("SELECT tstart,tend,length,data FROM %s WHERE tsid=? AND tstart<=? AND tend >=? ORDER BY tstart ASC", table), q.Timeseries, t, t)
 			if err != nil {
 				return nil, err
 			}
-			bi := SQLBatchIterator{rows, nil}
+			bi := NewCachedBatchIterator(ts.Cache, rows, nil, table, q.Timeseries, gen)
 			defer bi.Close()
 			da, err := bi.NextBatch()
 			if err != nil || da == nil {
@@ -286,11 +315,11 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 			return NewDatapointArrayIterator(da[:1]), nil
 		}
 
-		rows, err := ts.DB.Queryx(fmt.Sprintf("SELECT data FROM %s WHERE %s ORDER BY tstart ASC", table, strings.Join(constraints, " AND ")), cValues...)
+		rows, err := ts.DB.Queryx(fmt.Sprintf("SELECT tstart,tend,length,data FROM %s WHERE %s ORDER BY tstart ASC", table, strings.Join(constraints, " AND ")), cValues...)
 		if err != nil {
 			return nil, err
 		}
-		bi := BatchIterator(SQLBatchIterator{rows, nil})
+		bi := BatchIterator(NewCachedBatchIterator(ts.Cache, rows, nil, table, q.Timeseries, gen))
 		if q.T2 != nil {
 			bi = BatchEndTime{bi, t2}
 		}
@@ -423,12 +452,12 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 
 	// Now query the full thing
 
-	rows, err := tx.Queryx(fmt.Sprintf("SELECT data FROM %s WHERE %s ORDER BY tstart ASC", table, strings.Join(constraints, " AND ")), cValues...)
+	rows, err := tx.Queryx(fmt.Sprintf("SELECT tstart,tend,length,data FROM %s WHERE %s ORDER BY tstart ASC", table, strings.Join(constraints, " AND ")), cValues...)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
-	bi := BatchIterator(SQLBatchIterator{rows, func() { tx.Commit() }})
+	bi := BatchIterator(NewCachedBatchIterator(ts.Cache, rows, func() { tx.Commit() }, table, q.Timeseries, gen))
 	if i2 != nil {
 		bi = BatchEndOffset{bi, so2.Tstart, so2.Offset}
 	}
@@ -472,8 +501,13 @@ func (ts *TimeseriesDB) rawQuery(q *Query) (DatapointIterator, error) {
 	return NewBatchDatapointIterator(NewChanBatchIterator(bi), da), nil
 }
 
-// Query runs the given query, while adding on the transform and limit reading
+// Query runs the given query, while adding on the transform and limit reading.
+// Results of queries with absolute timestamps are cached.
 func (ts *TimeseriesDB) Query(q *Query) (DatapointIterator, error) {
+	cached, cacher := ts.Cache.cachedQuery(q)
+	if cached != nil {
+		return cached, nil
+	}
 	it, err := ts.rawQuery(q)
 	if err != nil {
 		return it, err
@@ -488,8 +522,11 @@ func (ts *TimeseriesDB) Query(q *Query) (DatapointIterator, error) {
 	if q.Limit != nil && *q.Limit > 0 {
 		it = NewNumIterator(it, *q.Limit)
 	}
+	if err != nil {
+		return it, err
+	}
 
-	return it, err
+	return cacher(it), nil
 }
 
 func (ts *TimeseriesDB) Delete(q *Query) error {
@@ -522,13 +559,13 @@ func (ts *TimeseriesDB) Delete(q *Query) error {
 	}
 
 	if q.T1 != nil {
-		t1, err = ParseTimestamp(q.T1)
+		t1, err = ParseTimestampIn(q.T1, q.location())
 		if err != nil {
 			return err
 		}
 	}
 	if q.T2 != nil {
-		t2, err = ParseTimestamp(q.T2)
+		t2, err = ParseTimestampIn(q.T2, q.location())
 		if err != nil {
 			return err
 		}
@@ -548,7 +585,7 @@ func (ts *TimeseriesDB) Delete(q *Query) error {
 			return errors.New("bad_query: cannot delete by single timestamp with additional range/index")
 		}
 		// If T is defined, let both t1 and t2 be T, we special-case the t1=t2 situation
-		t1, err = ParseTimestamp(q.T)
+		t1, err = ParseTimestampIn(q.T, q.location())
 		if err != nil {
 			return err
 		}
@@ -803,7 +840,8 @@ func (ts *TimeseriesDB) writeBatch(tx *database.TxWrapper, table, tsid string, c
 	if ts.DB.Assets().Config.Verbose {
 		logrus.WithField("timeseries", tsid).Debugln("Writing Batch: ", curBatch.String())
 	}
-	_, err = tx.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %s(tsid,tstart,tend,length,data) VALUES (?,?,?,?,?);", table), tsid, curBatch[0].Timestamp, curBatch[len(curBatch)-1].EndTime(), len(curBatch), b)
+	sm := curBatch.summary()
+	_, err = tx.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %s(tsid,tstart,tend,length,data,num_count,num_min,num_max,num_sum) VALUES (?,?,?,?,?,?,?,?,?);", table), tsid, curBatch[0].Timestamp, curBatch[len(curBatch)-1].EndTime(), len(curBatch), b, sm.Count, sm.Min, sm.Max, sm.Sum)
 	return err
 }
 
@@ -960,10 +998,11 @@ func (ts *TimeseriesDB) mergeBatch(tx *database.TxWrapper, table, tsid string, c
 }
 
 type batchinfo struct {
-	tstart float64
-	tend   float64
-	length int
-	data   []byte
+	tstart  float64
+	tend    float64
+	length  int
+	data    []byte
+	summary batchSummary
 }
 
 func (ts *TimeseriesDB) append(tx *database.TxWrapper, table, tsid string, curBatch DatapointArray, data DatapointIterator, dp *Datapoint) error {
@@ -988,10 +1027,11 @@ func (ts *TimeseriesDB) append(tx *database.TxWrapper, table, tsid string, curBa
 				}
 				// Write the remaining elements of this batch, and exit
 				batcher <- &batchinfo{
-					tstart: curBatch[0].Timestamp,
-					tend:   curBatch[len(curBatch)-1].Timestamp + curBatch[len(curBatch)-1].Duration,
-					length: len(curBatch),
-					data:   b,
+					tstart:  curBatch[0].Timestamp,
+					tend:    curBatch[len(curBatch)-1].Timestamp + curBatch[len(curBatch)-1].Duration,
+					length:  len(curBatch),
+					data:    b,
+					summary: curBatch.summary(),
 				}
 				batcher <- nil
 				return
@@ -1016,10 +1056,11 @@ func (ts *TimeseriesDB) append(tx *database.TxWrapper, table, tsid string, curBa
 					batcher <- nil
 					return
 				case batcher <- &batchinfo{
-					tstart: prevBatch[0].Timestamp,
-					tend:   prevBatch[len(prevBatch)-1].Timestamp + prevBatch[len(prevBatch)-1].Duration,
-					length: len(prevBatch),
-					data:   b,
+					tstart:  prevBatch[0].Timestamp,
+					tend:    prevBatch[len(prevBatch)-1].Timestamp + prevBatch[len(prevBatch)-1].Duration,
+					length:  len(prevBatch),
+					data:    b,
+					summary: prevBatch.summary(),
 				}:
 
 				}
@@ -1035,10 +1076,10 @@ func (ts *TimeseriesDB) append(tx *database.TxWrapper, table, tsid string, curBa
 
 	}()
 
-	statement := fmt.Sprintf("INSERT OR REPLACE INTO %s(tsid,tstart,tend,length,data) VALUES (?,?,?,?,?);", table)
+	statement := fmt.Sprintf("INSERT OR REPLACE INTO %s(tsid,tstart,tend,length,data,num_count,num_min,num_max,num_sum) VALUES (?,?,?,?,?,?,?,?,?);", table)
 
 	for b := <-batcher; b != nil; b = <-batcher {
-		_, err := tx.Exec(statement, tsid, b.tstart, b.tend, b.length, b.data)
+		_, err := tx.Exec(statement, tsid, b.tstart, b.tend, b.length, b.data, b.summary.Count, b.summary.Min, b.summary.Max, b.summary.Sum)
 		if err != nil {
 			closer <- true
 			for b = <-batcher; b != nil; b = <-batcher {
@@ -1057,13 +1098,10 @@ type InsertQuery struct {
 	Method *string `json:"method,omitempty"`
 }
 
-func (ts *TimeseriesDB) Insert(tsid string, data DatapointIterator, q *InsertQuery) (err error) {
-	table := "timeseries"
-	method := 0 // 0 is update
-
-	// Make sure data comes in sorted and without any funny business
-	data = NewSortChecker(data)
-
+// insertParams returns the table and insert method given by the insert query
+func (q *InsertQuery) insertParams() (table string, method int, err error) {
+	table = "timeseries"
+	method = 0 // 0 is update
 	if q != nil {
 		if q.Actions != nil && *q.Actions {
 			table = "timeseries_actions"
@@ -1076,12 +1114,21 @@ func (ts *TimeseriesDB) Insert(tsid string, data DatapointIterator, q *InsertQue
 				method = 2
 			} else if *q.Method == "update" {
 			} else {
-				return errors.New("bad_query: Unrecognized insert method")
+				return table, method, errors.New("bad_query: Unrecognized insert method")
 			}
 		}
 	}
+	return table, method, nil
+}
 
-	delStatement := fmt.Sprintf("DELETE FROM %s WHERE tsid=? AND tstart=?", table)
+func (ts *TimeseriesDB) Insert(tsid string, data DatapointIterator, q *InsertQuery) (err error) {
+	table, method, err := q.insertParams()
+	if err != nil {
+		return err
+	}
+
+	// Make sure data comes in sorted and without any funny business
+	data = NewSortChecker(data)
 
 	var dp *Datapoint
 	dp, err = data.Next()
@@ -1102,6 +1149,58 @@ func (ts *TimeseriesDB) Insert(tsid string, data DatapointIterator, q *InsertQue
 		}
 	}()
 
+	return ts.insertTx(tx, table, tsid, method, data, dp)
+}
+
+// InsertMany inserts data into multiple timeseries in a single transaction. Each timeseries is inserted
+// independently, so that failing to insert into one of them doesn't stop insertion into the others.
+// The returned map holds the error of each timeseries whose data was not inserted.
+func (ts *TimeseriesDB) InsertMany(data map[string]DatapointIterator, q *InsertQuery) (map[string]error, error) {
+	table, method, err := q.insertParams()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := ts.DB.BeginImmediatex()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	failed := make(map[string]error)
+	for tsid, it := range data {
+		it = NewSortChecker(it)
+		dp, err := it.Next()
+		if err != nil {
+			failed[tsid] = err
+			continue
+		}
+		if dp == nil {
+			continue
+		}
+		// Each timeseries is inserted in a savepoint, so that a failed insert can be undone without
+		// affecting the others
+		if _, err = tx.Exec("SAVEPOINT timeseries_insert"); err != nil {
+			return nil, err
+		}
+		err = ts.insertTx(tx, table, tsid, method, it, dp)
+		if err != nil {
+			failed[tsid] = err
+			if _, err = tx.Exec("ROLLBACK TO SAVEPOINT timeseries_insert"); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = tx.Exec("RELEASE SAVEPOINT timeseries_insert"); err != nil {
+			return nil, err
+		}
+	}
+	return failed, tx.Commit()
+}
+
+// insertTx inserts the data into the timeseries within the given transaction. The first datapoint
+// has already been read from the data iterator, and is given as dp.
+func (ts *TimeseriesDB) insertTx(tx *database.TxWrapper, table, tsid string, method int, data DatapointIterator, dp *Datapoint) (err error) {
+	delStatement := fmt.Sprintf("DELETE FROM %s WHERE tsid=? AND tstart=?", table)
+
 	// Get the batch immediately preceding the datapoint
 	var rows *sqlx.Rows
 	rows, err = tx.Queryx(fmt.Sprintf("SELECT data FROM %s WHERE tsid=? AND tstart <= ? ORDER BY tstart DESC LIMIT 1", table), tsid, dp.Timestamp)
@@ -738,3 +738,36 @@ func TestDurationUpdate(t *testing.T) {
 
 	require.True(t, output.IsEqual(dpa), "%s different from %s", dpa.String(), output.String())
 }
+
+func TestInsertMany(t *testing.T) {
+	adb, oid1, oid2, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	s := TimeseriesDB{
+		DB:                    adb,
+		BatchSize:             2,
+		MaxBatchSize:          3,
+		BatchCompressionLevel: 3,
+	}
+
+	failed, err := s.InsertMany(map[string]DatapointIterator{
+		oid1: NewDatapointArrayIterator(dpa6),
+		oid2: NewDatapointArrayIterator(dpa1),
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, failed, 0)
+	cmpQuery(t, s, &Query{Timeseries: oid1}, dpa6)
+	cmpQuery(t, s, &Query{Timeseries: oid2}, dpa1)
+
+	// A failed insert into one timeseries doesn't stop the others
+	method := "insert"
+	failed, err = s.InsertMany(map[string]DatapointIterator{
+		oid1: NewDatapointArrayIterator(DatapointArray{&Datapoint{6.0, 0, 6.0, ""}}),
+		oid2: NewDatapointArrayIterator(DatapointArray{&Datapoint{2.0, 0, "conflict", ""}, &Datapoint{3.0, 0, "hi", ""}}),
+	}, &InsertQuery{Method: &method})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Error(t, failed[oid2])
+	cmpQuery(t, s, &Query{Timeseries: oid1}, append(dpa6, &Datapoint{6.0, 0, 6.0, ""}))
+	cmpQuery(t, s, &Query{Timeseries: oid2}, dpa1)
+}
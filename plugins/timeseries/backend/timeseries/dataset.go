@@ -131,6 +131,9 @@ func (d *DatasetElement) Validate() error {
 		if q.T == nil && q.T != nil {
 			q.T = d.T
 		}
+		if q.Timezone == "" {
+			q.Timezone = d.Timezone
+		}
 	}
 	if d.Interpolator == "" {
 		d.Interpolator = "closest"
@@ -234,8 +237,14 @@ func (d *Dataset) Validate() error {
 		if q.T == nil && q.T != nil {
 			q.T = d.T
 		}
+		if q.Timezone == "" {
+			q.Timezone = d.Timezone
+		}
 	}
 	for _, v := range d.Dataset {
+		if v.Timezone == "" {
+			v.Timezone = d.Timezone
+		}
 		if err := v.Validate(); err != nil {
 			return err
 		}
@@ -276,15 +285,16 @@ func (d *Dataset) Get(db database.DB) (*DatasetIterator, error) {
 	}
 	if d.Dt != nil {
 		// It is a t-dataset
-		dt, err := ParseTimestamp(d.Dt)
+		loc := d.location()
+		dt, err := ParseTimestampIn(d.Dt, loc)
 		if err != nil {
 			return nil, err
 		}
-		t1, err := ParseTimestamp(d.T1)
+		t1, err := ParseTimestampIn(d.T1, loc)
 		if err != nil {
 			return nil, err
 		}
-		t2, err := ParseTimestamp(d.T2)
+		t2, err := ParseTimestampIn(d.T2, loc)
 		if err != nil {
 			return nil, err
 		}
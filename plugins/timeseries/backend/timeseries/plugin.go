@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/heedy/heedy/backend/database"
+	"github.com/heedy/heedy/backend/events"
 	"github.com/heedy/heedy/backend/plugins/run"
 	"github.com/heedy/pipescript/datasets/interpolators"
 	"github.com/heedy/pipescript/transforms"
@@ -22,26 +23,80 @@ func SQLUpdater(db *database.AdminDB, i *run.Info, h run.BuiltinHelper, curversi
 	if curversion == SQLVersion {
 		return nil
 	}
-	if curversion != 0 {
-		return errors.New("Timeseries database version incompatible")
+	if curversion > SQLVersion {
+		return errors.New("Timeseries database version too new")
 	}
-	_, err := db.ExecUncached(sqlSchema)
-	return err
+	if curversion == 0 {
+		_, err := db.ExecUncached(sqlSchema)
+		return err
+	}
+	if curversion == 1 {
+		return addBatchSummaries(db)
+	}
+	return errors.New("Timeseries database version incompatible")
 }
 
-// StartTimeseries prepares the plugin by initializing the database
-func StartTimeseries(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
-	err := run.WithVersion(PluginName, SQLVersion, SQLUpdater)(db, i, h)
+// summaryMigrationPageSize is the number of batches that are loaded at once when computing batch summaries
+const summaryMigrationPageSize = 100
+
+// addBatchSummaries migrates from version 1, adding the numeric summary columns to the timeseries table,
+// and computing them for the existing batches
+func addBatchSummaries(db *database.AdminDB) error {
+	tx, err := db.BeginImmediatex()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	for _, col := range []string{"num_count INTEGER NOT NULL DEFAULT 0", "num_min REAL", "num_max REAL", "num_sum REAL"} {
+		if _, err = tx.Exec("ALTER TABLE timeseries ADD COLUMN " + col); err != nil {
+			return err
+		}
+	}
+	type batch struct {
+		Tsid   string
+		Tstart float64
+		Data   []byte
+	}
+	// The batches are summarized a page at a time, so that large databases don't need to fit in memory
+	var last *batch
+	for {
+		var batches []batch
+		if last == nil {
+			err = tx.Select(&batches, "SELECT tsid,tstart,data FROM timeseries ORDER BY tsid,tstart LIMIT ?", summaryMigrationPageSize)
+		} else {
+			err = tx.Select(&batches, "SELECT tsid,tstart,data FROM timeseries WHERE tsid>? OR (tsid=? AND tstart>?) ORDER BY tsid,tstart LIMIT ?",
+				last.Tsid, last.Tsid, last.Tstart, summaryMigrationPageSize)
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range batches {
+			dpa, err := DatapointArrayFromBytes(b.Data)
+			if err != nil {
+				return err
+			}
+			sm := dpa.summary()
+			_, err = tx.Exec("UPDATE timeseries SET num_count=?,num_min=?,num_max=?,num_sum=? WHERE tsid=? AND tstart=?", sm.Count, sm.Min, sm.Max, sm.Sum, b.Tsid, b.Tstart)
+			if err != nil {
+				return err
+			}
+		}
+		if len(batches) < summaryMigrationPageSize {
+			break
+		}
+		last = &batches[len(batches)-1]
+	}
+	return tx.Commit()
+}
 
+// StartTimeseries prepares the plugin by initializing the database
+func StartTimeseries(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) error {
 	tsc, ok := db.Assets().Config.Plugins["timeseries"]
 	if !ok {
 		return errors.New("Could not find timeseries plugin configuration")
 	}
 
-	err = mapstructure.Decode(tsc.Config, &TSDB)
+	err := mapstructure.Decode(tsc.Config, &TSDB)
 	if err != nil {
 		return err
 	}
@@ -58,9 +113,24 @@ func StartTimeseries(db *database.AdminDB, i *run.Info, h run.BuiltinHelper) err
 		return errors.New("Timeseries currently doesn't support compression rates > 3")
 	} else {
 		zencoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevel(TSDB.BatchCompressionLevel)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The database is updated after setting up compression, since migrations decode existing batches
+	err = run.WithVersion(PluginName, SQLVersion, SQLUpdater)(db, i, h)
+	if err != nil {
+		return err
+	}
+
+	if TSDB.CacheBatches > 0 || TSDB.CacheQueries > 0 {
+		TSDB.Cache = NewQueryCache(TSDB.CacheBatches, TSDB.CacheQueries, TSDB.CacheQueryMaxLength)
+		// The cache is invalidated by timeseries write and delete events
+		events.AddHandler(TSDB.Cache)
 	}
 
-	return err
+	return nil
 }
 
 // This is not needed for normal plugins. The init simply registers the plugin with heedy internals
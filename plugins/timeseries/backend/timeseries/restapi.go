@@ -99,6 +99,10 @@ func decodeQuery(r *http.Request) (Query, error) {
 	if q.T2v != nil {
 		q.Query.T2 = *q.T2v
 	}
+	if q.Query.Timezone == "" {
+		// Relative timestamps are resolved in the caller's timezone
+		q.Query.Timezone = database.CallerLocale(rest.CTX(r).DB).Timezone
+	}
 	return q.Query, nil
 }
 
@@ -320,6 +324,138 @@ func WriteData(w http.ResponseWriter, r *http.Request, action bool) {
 	rest.WriteResult(w, r, err)
 }
 
+// BulkWriteResult is the result of writing to a single timeseries in a bulk write
+type BulkWriteResult struct {
+	Result string `json:"result,omitempty"`
+	Count  int64  `json:"count"`
+	*rest.ErrorResponse
+}
+
+func bulkWriteError(err error) *BulkWriteResult {
+	er := rest.NewErrorResponse(err)
+	return &BulkWriteResult{ErrorResponse: &er}
+}
+
+// BulkWrite writes data to multiple timeseries at once, given a map of object IDs to the datapoints to insert into each.
+// The data of each timeseries is validated against its own schema, and all valid data is inserted in a single transaction.
+func BulkWrite(w http.ResponseWriter, r *http.Request) {
+	c := rest.CTX(r)
+	var iq InsertQuery
+	err := queryDecoder.Decode(&iq, r.URL.Query())
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if iq.Actions != nil && *iq.Actions {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("bad_query: actions can't be written in bulk"))
+		return
+	}
+	var data map[string]DatapointArray
+	err = rest.UnmarshalRequest(r, &data)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	results := make(map[string]*BulkWriteResult)
+	objects := make(map[string]*database.Object)
+	infos := make(map[string]*InfoIterator)
+	toInsert := make(map[string]DatapointIterator)
+	for oid, datapoints := range data {
+		o, err := c.DB.ReadObject(oid, nil)
+		if err != nil {
+			results[oid] = bulkWriteError(err)
+			continue
+		}
+		if o.Type == nil || *o.Type != "timeseries" {
+			results[oid] = bulkWriteError(errors.New("bad_request: the object is not a timeseries"))
+			continue
+		}
+		if !o.Access.HasScope("write") {
+			results[oid] = bulkWriteError(database.ErrAccessDenied("Insufficient permissions"))
+			continue
+		}
+		err = validateBulkData(o, datapoints, &iq)
+		if err != nil {
+			results[oid] = bulkWriteError(err)
+			continue
+		}
+		objects[oid] = o
+		infos[oid] = NewInfoIterator(NewDatapointArrayIterator(datapoints))
+		toInsert[oid] = infos[oid]
+	}
+
+	failed, err := TSDB.InsertMany(toInsert, &iq)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	for oid, ii := range infos {
+		if err, ok := failed[oid]; ok {
+			results[oid] = bulkWriteError(err)
+			continue
+		}
+		results[oid] = &BulkWriteResult{Result: "ok", Count: ii.Count}
+		if ii.Count == 0 {
+			continue
+		}
+		var md *string
+		if o := objects[oid]; o.ModifiedDate != nil {
+			d := time.Time(*o.ModifiedDate).Format("2006-01-02")
+			md = &d
+		}
+		if shouldUpdateModifed(md) {
+			ne := dbutil.Date(time.Now().UTC())
+			err = c.DB.AdminDB().UpdateObject(&database.Object{
+				Details: database.Details{
+					ID: oid,
+				},
+				ModifiedDate: &ne,
+			})
+			if err != nil {
+				c.Log.Warnf("Failed to update modified date of %s: %s", oid, err.Error())
+			}
+		}
+		c.Events.Fire(&events.Event{
+			Event:  "timeseries_data_write",
+			Object: oid,
+			Data: &TimeseriesWriteEvent{
+				T1:    ii.Tstart,
+				T2:    ii.Tend,
+				Count: ii.Count,
+				DP:    ii.LastPoint,
+			},
+		})
+	}
+	rest.WriteJSON(w, r, results, nil)
+}
+
+// validateBulkData checks the datapoints to be written to the object against its schema
+func validateBulkData(o *database.Object, datapoints DatapointArray, iq *InsertQuery) error {
+	for i := range datapoints {
+		if datapoints[i] == nil {
+			return errors.New("bad_request: null datapoint")
+		}
+		datapoints[i].Actor = ""
+	}
+	if iq.Validate != nil && !*iq.Validate || o.Meta == nil {
+		return nil
+	}
+	schema, ok := (*o.Meta)["schema"].(map[string]interface{})
+	if !ok || len(schema) == 0 {
+		return nil
+	}
+	dv, err := NewDataValidator(NewDatapointArrayIterator(datapoints), schema, "")
+	if err != nil {
+		return err
+	}
+	var dp *Datapoint
+	for dp, err = dv.Next(); err == nil && dp != nil; dp, err = dv.Next() {
+	}
+	return err
+}
+
 func DataLength(w http.ResponseWriter, r *http.Request, action bool) {
 	si, ok := validateRequest(w, r, "read")
 	if !ok {
@@ -333,6 +469,82 @@ func DataLength(w http.ResponseWriter, r *http.Request, action bool) {
 	rest.WriteJSON(w, r, l, err)
 }
 
+// ChangeSchema checks the timeseries' existing data against a new schema, migrating it if a transform is given,
+// and sets the new schema once the data conforms to it.
+func ChangeSchema(w http.ResponseWriter, r *http.Request) {
+	c := rest.CTX(r)
+	si, ok := validateRequest(w, r, "write")
+	if !ok {
+		return
+	}
+	if !si.ObjectInfo.Access.HasScope("update") {
+		rest.WriteJSONError(w, r, http.StatusForbidden, database.ErrAccessDenied("Insufficient permissions"))
+		return
+	}
+	var sc SchemaChange
+	err := rest.UnmarshalRequest(r, &sc)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	res, err := TSDB.ChangeSchema(si.ObjectInfo.ID, &sc)
+	if err == ErrSchemaChangeInvalid {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, fmt.Errorf("%w: %d of %d datapoints are invalid (use dry_run to inspect them, or force to apply anyway)", err, res.Invalid, res.Count))
+		return
+	}
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if res.Applied && res.T2 > res.T1 {
+		c.Events.Fire(&events.Event{
+			Event:  "timeseries_data_write",
+			Object: si.ObjectInfo.ID,
+			Data: &TimeseriesWriteEvent{
+				T1:    res.T1,
+				T2:    res.T2,
+				Count: res.Count,
+			},
+		})
+	}
+	rest.WriteJSON(w, r, res, err)
+}
+
+// ReadCacheStats returns the hit rates of the query cache, allowing admins to tune its size
+func ReadCacheStats(w http.ResponseWriter, r *http.Request) {
+	db := rest.CTX(r).DB
+	if db.Type() != database.AdminType && (db.Type() != database.UserType || !db.AdminDB().Assets().Config.UserIsAdmin(db.ID())) {
+		rest.WriteJSONError(w, r, http.StatusForbidden, database.ErrAccessDenied("Only admins can view cache statistics"))
+		return
+	}
+	rest.WriteJSON(w, r, TSDB.Cache.Stats(), nil)
+}
+
+// DataStats returns summary statistics of the timeseries, optionally limited to a time range
+func DataStats(w http.ResponseWriter, r *http.Request, action bool) {
+	si, ok := validateRequest(w, r, "read")
+	if !ok {
+		return
+	}
+	if action && !si.Actor {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, ErrNotActor)
+		return
+	}
+	q, err := decodeQuery(r)
+	if err != nil {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if q.Timeseries != "" {
+		rest.WriteJSONError(w, r, http.StatusBadRequest, errors.New("timeseries arg is set automatically when querying objects"))
+		return
+	}
+	q.Timeseries = si.ObjectInfo.ID
+	q.Actions = &action
+	st, err := TSDB.Stats(&q, isNumericSchema(si.Schema))
+	rest.WriteJSON(w, r, st, err)
+}
+
 // Act is given just the data portion of a datapoint, and it is inserted at the current timestamp
 func Act(w http.ResponseWriter, r *http.Request) {
 	c := rest.CTX(r)
@@ -416,6 +628,9 @@ func GenerateDataset(rw http.ResponseWriter, r *http.Request) {
 			rest.WriteJSONError(rw, r, http.StatusBadRequest, fmt.Errorf("Invalid query at '%s'", k))
 			return
 		}
+		if v.Timezone == "" {
+			v.Timezone = database.CallerLocale(c.DB).Timezone
+		}
 		di, err := v.Get(c.DB)
 		if err != nil {
 			rest.WriteJSONError(rw, r, http.StatusBadRequest, fmt.Errorf("Invalid query at '%s': %w", k, err))
@@ -663,6 +878,10 @@ var Handler = func() *chi.Mux {
 	m.Get("/object/timeseries/length", func(w http.ResponseWriter, r *http.Request) {
 		DataLength(w, r, false)
 	})
+	m.Get("/object/timeseries/stats", func(w http.ResponseWriter, r *http.Request) {
+		DataStats(w, r, false)
+	})
+	m.Post("/object/timeseries/schema", ChangeSchema)
 	/*
 		m.Get("/object/actions", func(w http.ResponseWriter, r *http.Request) {
 			ReadData(w, r, true)
@@ -681,6 +900,8 @@ var Handler = func() *chi.Mux {
 	*/
 
 	m.Post("/api/timeseries/dataset", GenerateDataset)
+	m.Get("/api/timeseries/cache", ReadCacheStats)
+	m.Post("/api/timeseries/write", BulkWrite)
 
 	//m.Post("/dashboard/", GenerateDashboardDataset)
 
@@ -0,0 +1,197 @@
+package timeseries
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/heedy/heedy/backend/database"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaChange describes a change to a timeseries' schema. The existing data is checked against
+// the new schema, and if a transform is given, each batch of existing data is first replaced
+// with the output of the transform.
+type SchemaChange struct {
+	Schema    map[string]interface{} `json:"schema"`
+	Transform *string                `json:"transform,omitempty"`
+
+	// DryRun only reports the result of the change, without modifying anything
+	DryRun bool `json:"dry_run,omitempty"`
+	// Force applies the change even if existing data would fail the new schema
+	Force bool `json:"force,omitempty"`
+}
+
+// SchemaFailure gives a datapoint that failed validation
+type SchemaFailure struct {
+	Timestamp float64 `json:"t"`
+	Error     string  `json:"error"`
+}
+
+// SchemaChangeResult reports how the existing data fares under the new schema
+type SchemaChangeResult struct {
+	Count    int64           `json:"count"`
+	Invalid  int64           `json:"invalid"`
+	Failures []SchemaFailure `json:"failures,omitempty"`
+
+	// The time range of the data modified by the transform
+	T1 float64 `json:"t1,omitempty"`
+	T2 float64 `json:"t2,omitempty"`
+
+	Applied bool `json:"applied"`
+}
+
+// The maximum number of failures to include in the result
+const maxSchemaFailures = 10
+
+// ErrSchemaChangeInvalid is returned when a schema change would leave existing data invalid
+var ErrSchemaChangeInvalid = errors.New("bad_query: existing data fails the new schema")
+
+// ChangeSchema checks the existing data of the timeseries against the new schema, migrating it in place
+// with the schema change's transform. The data is only modified if it all conforms to the new schema,
+// or if the change is forced, in which case the new schema is written to the timeseries metadata in the
+// same transaction, so that the data and its schema can't get out of sync.
+func (ts *TimeseriesDB) ChangeSchema(tsid string, sc *SchemaChange) (*SchemaChangeResult, error) {
+	table := "timeseries"
+	if sc.Schema == nil {
+		return nil, errors.New("bad_query: no schema given")
+	}
+	s, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(sc.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("bad_query: invalid schema: %w", err)
+	}
+	if err = ts.DB.Assets().Config.ValidateObjectMetaUpdate("timeseries", map[string]interface{}{"schema": sc.Schema}); err != nil {
+		return nil, err
+	}
+	schemaJSON, err := json.Marshal(sc.Schema)
+	if err != nil {
+		return nil, err
+	}
+	hasTransform := sc.Transform != nil && *sc.Transform != ""
+
+	// An immediate transaction makes sure that no data is inserted while the schema change is in progress
+	tx, err := ts.DB.BeginImmediatex()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tstarts []float64
+	err = tx.Select(&tstarts, fmt.Sprintf("SELECT tstart FROM %s WHERE tsid=? ORDER BY tstart ASC", table), tsid)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SchemaChangeResult{
+		T1: math.Inf(1),
+		T2: math.Inf(-1),
+	}
+	for i, tstart := range tstarts {
+		var data []byte
+		err = tx.Get(&data, fmt.Sprintf("SELECT data FROM %s WHERE tsid=? AND tstart=?", table), tsid, tstart)
+		if err != nil {
+			return nil, err
+		}
+		dpa, err := DatapointArrayFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if hasTransform {
+			tend := math.Inf(1)
+			if i < len(tstarts)-1 {
+				tend = tstarts[i+1]
+			}
+			dpa, err = migrateBatch(*sc.Transform, dpa, tend)
+			if err != nil {
+				return nil, err
+			}
+
+			// Replace the batch with the transformed data
+			_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE tsid=? AND tstart=?", table), tsid, tstart)
+			if err != nil {
+				return nil, err
+			}
+			if len(dpa) > 0 {
+				rest, err := ts.writeBatchStart(tx, table, tsid, dpa)
+				if err == nil {
+					err = ts.writeBatch(tx, table, tsid, rest)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if dpa[len(dpa)-1].EndTime() > res.T2 {
+					res.T2 = dpa[len(dpa)-1].EndTime()
+				}
+			}
+			if tstart < res.T1 {
+				res.T1 = tstart
+			}
+		}
+
+		for _, dp := range dpa {
+			res.Count++
+			result, err := s.Validate(gojsonschema.NewGoLoader(dp.Data))
+			if err != nil {
+				return nil, err
+			}
+			if !result.Valid() {
+				res.Invalid++
+				if len(res.Failures) < maxSchemaFailures {
+					res.Failures = append(res.Failures, SchemaFailure{
+						Timestamp: dp.Timestamp,
+						Error:     result.Errors()[0].String(),
+					})
+				}
+			}
+		}
+	}
+	if math.IsInf(res.T1, 1) {
+		res.T1 = 0
+		res.T2 = 0
+	}
+
+	if sc.DryRun {
+		return res, nil
+	}
+	if res.Invalid > 0 && !sc.Force {
+		return res, ErrSchemaChangeInvalid
+	}
+
+	// The metadata is updated the same way as heedy's object updates, so the object_update event still fires
+	result, err := tx.Exec("UPDATE objects SET meta=json_set(json(meta),'$.schema',json(?)) WHERE id=? AND type='timeseries';", string(schemaJSON), tsid)
+	if err = database.GetExecError(result, err); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	res.Applied = true
+
+	// The transform might have changed any of the data, and the query cache mustn't keep serving the old values
+	ts.Cache.Invalidate(table, tsid, math.Inf(-1), math.Inf(1))
+	return res, nil
+}
+
+// migrateBatch runs the transform on a batch of data. The output must remain ordered,
+// and can't extend into the following batch, which starts at tend.
+func migrateBatch(transform string, dpa DatapointArray, tend float64) (DatapointArray, error) {
+	it, err := NewTransformIterator(transform, NewDatapointArrayIterator(dpa))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	out, err := NewArrayFromIterator(it)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if out[i].Timestamp < dpa[0].Timestamp || out[i].EndTime() > tend || out[i].EndTime() == tend && out[i].Duration == 0 {
+			return nil, errors.New("bad_query: the migration transform can't move datapoints outside their original batch")
+		}
+		if i > 0 && out[i].Timestamp < out[i-1].EndTime() {
+			return nil, errors.New("bad_query: the migration transform must output non-overlapping datapoints in order")
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,88 @@
+package timeseries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeSchema(t *testing.T) {
+	adb, oid1, _, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	s := TimeseriesDB{
+		DB:                    adb,
+		BatchSize:             2,
+		MaxBatchSize:          3,
+		BatchCompressionLevel: 3,
+		Cache:                 NewQueryCache(10, 10, 100),
+	}
+	readSchema := func() interface{} {
+		o, err := adb.ReadObject(oid1, nil)
+		require.NoError(t, err)
+		return (*o.Meta)["schema"]
+	}
+
+	require.NoError(t, s.Insert(oid1, NewDatapointArrayIterator(dpa6), nil))
+
+	numSchema := map[string]interface{}{"type": "number"}
+	strSchema := map[string]interface{}{"type": "string"}
+
+	_, err := s.ChangeSchema(oid1, &SchemaChange{})
+	require.Error(t, err)
+
+	// The data already conforms to the schema
+	res, err := s.ChangeSchema(oid1, &SchemaChange{Schema: numSchema})
+	require.NoError(t, err)
+	require.True(t, res.Applied)
+	require.Equal(t, int64(5), res.Count)
+	require.Equal(t, int64(0), res.Invalid)
+	require.Equal(t, numSchema, readSchema())
+
+	// A dry run reports the invalid data without changing anything
+	res, err = s.ChangeSchema(oid1, &SchemaChange{Schema: strSchema, DryRun: true})
+	require.NoError(t, err)
+	require.False(t, res.Applied)
+	require.Equal(t, int64(5), res.Invalid)
+	require.Len(t, res.Failures, 5)
+
+	res, err = s.ChangeSchema(oid1, &SchemaChange{Schema: strSchema})
+	require.Equal(t, ErrSchemaChangeInvalid, err)
+	require.False(t, res.Applied)
+	require.Equal(t, numSchema, readSchema())
+
+	// Migrating the data makes it valid
+	transform := "d > 2"
+	res, err = s.ChangeSchema(oid1, &SchemaChange{
+		Schema:    map[string]interface{}{"type": "boolean"},
+		Transform: &transform,
+		DryRun:    true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), res.Invalid)
+	cmpQuery(t, s, &Query{Timeseries: oid1}, dpa6)
+
+	res, err = s.ChangeSchema(oid1, &SchemaChange{
+		Schema:    map[string]interface{}{"type": "boolean"},
+		Transform: &transform,
+	})
+	require.NoError(t, err)
+	require.True(t, res.Applied)
+	require.Equal(t, 1.0, res.T1)
+	require.Equal(t, 5.0, res.T2)
+	require.Equal(t, map[string]interface{}{"type": "boolean"}, readSchema())
+	// The cached results of the earlier query are not served after the migration
+	cmpQuery(t, s, &Query{Timeseries: oid1}, DatapointArray{
+		&Datapoint{1.0, 0, false, ""},
+		&Datapoint{2.0, 0, false, ""},
+		&Datapoint{3.0, 0, true, ""},
+		&Datapoint{4.0, 0, true, ""},
+		&Datapoint{5.0, 0, true, ""},
+	})
+
+	// Forcing applies the change despite invalid data
+	res, err = s.ChangeSchema(oid1, &SchemaChange{Schema: strSchema, Force: true})
+	require.NoError(t, err)
+	require.True(t, res.Applied)
+	require.Equal(t, int64(5), res.Invalid)
+}
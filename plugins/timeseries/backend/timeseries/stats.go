@@ -0,0 +1,208 @@
+package timeseries
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// batchSummary holds the statistics of the numeric datapoints in a batch. It is stored alongside each batch,
+// so that statistics of a timeseries can be computed without decompressing its data.
+type batchSummary struct {
+	Count int64
+	Min   sql.NullFloat64
+	Max   sql.NullFloat64
+	Sum   sql.NullFloat64
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// add includes the datapoint in the summary if its data is numeric
+func (s *batchSummary) add(dp *Datapoint) {
+	f, ok := toFloat(dp.Data)
+	if !ok || math.IsNaN(f) {
+		return
+	}
+	if s.Count == 0 {
+		s.Min = sql.NullFloat64{Float64: f, Valid: true}
+		s.Max = sql.NullFloat64{Float64: f, Valid: true}
+		s.Sum = sql.NullFloat64{Float64: f, Valid: true}
+	} else {
+		s.Min.Float64 = math.Min(s.Min.Float64, f)
+		s.Max.Float64 = math.Max(s.Max.Float64, f)
+		s.Sum.Float64 += f
+	}
+	s.Count++
+}
+
+// summary returns the statistics of the numeric datapoints in the array
+func (dpa DatapointArray) summary() batchSummary {
+	var s batchSummary
+	for _, dp := range dpa {
+		s.add(dp)
+	}
+	return s
+}
+
+// Stats gives summary statistics of a timeseries. The numeric statistics are only included
+// for timeseries with numeric data.
+type Stats struct {
+	Count int64 `json:"count"`
+
+	// The timestamp of the first datapoint, and the end time of the last datapoint
+	T1 *float64 `json:"t1"`
+	T2 *float64 `json:"t2"`
+
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+	Sum  *float64 `json:"sum,omitempty"`
+	Mean *float64 `json:"mean,omitempty"`
+}
+
+// statsAccumulator merges batch summaries and individual datapoints into Stats
+type statsAccumulator struct {
+	count   int64
+	t1, t2  float64
+	numeric batchSummary
+}
+
+func (a *statsAccumulator) addRange(count int64, t1, t2 float64) {
+	a.count += count
+	a.t1 = math.Min(a.t1, t1)
+	a.t2 = math.Max(a.t2, t2)
+}
+
+func (a *statsAccumulator) addSummary(s batchSummary) {
+	if s.Count == 0 || !s.Min.Valid {
+		return
+	}
+	if a.numeric.Count == 0 {
+		a.numeric = s
+		return
+	}
+	a.numeric.Count += s.Count
+	a.numeric.Min.Float64 = math.Min(a.numeric.Min.Float64, s.Min.Float64)
+	a.numeric.Max.Float64 = math.Max(a.numeric.Max.Float64, s.Max.Float64)
+	a.numeric.Sum.Float64 += s.Sum.Float64
+}
+
+func (a *statsAccumulator) stats(numeric bool) *Stats {
+	s := &Stats{Count: a.count}
+	if a.count == 0 {
+		return s
+	}
+	s.T1, s.T2 = &a.t1, &a.t2
+	if numeric && a.numeric.Count > 0 {
+		mean := a.numeric.Sum.Float64 / float64(a.numeric.Count)
+		s.Min, s.Max, s.Sum, s.Mean = &a.numeric.Min.Float64, &a.numeric.Max.Float64, &a.numeric.Sum.Float64, &mean
+	}
+	return s
+}
+
+// Stats returns the statistics of the datapoints with t1 <= timestamp < t2 (both limits are optional),
+// computed from the summaries stored with each batch. Only batches that are partially within the range
+// are decompressed. Numeric statistics are only computed if numeric is true.
+func (ts *TimeseriesDB) Stats(q *Query, numeric bool) (*Stats, error) {
+	table := "timeseries"
+	if q.Timeseries == "" {
+		return nil, errors.New("bad_query: no timeseries specified")
+	}
+	if q.T != nil || q.I != nil || q.I1 != nil || q.I2 != nil || q.Transform != nil || q.Limit != nil {
+		return nil, errors.New("bad_query: statistics can only be limited by t1 and t2")
+	}
+	if q.Actions != nil && *q.Actions {
+		table = "timeseries_actions"
+	}
+
+	t1, t2 := math.Inf(-1), math.Inf(1)
+	var err error
+	if q.T1 != nil {
+		if t1, err = ParseTimestampIn(q.T1, q.location()); err != nil {
+			return nil, err
+		}
+	}
+	if q.T2 != nil {
+		if t2, err = ParseTimestampIn(q.T2, q.location()); err != nil {
+			return nil, err
+		}
+	}
+
+	// The batches are read in a transaction, so that the edge batches are consistent with the summaries
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	acc := statsAccumulator{t1: math.Inf(1), t2: math.Inf(-1)}
+
+	// Batches fully within the range only need their summaries
+	var inner struct {
+		Length int64
+		Tstart sql.NullFloat64
+		Tend   sql.NullFloat64
+		batchSummary
+	}
+	err = tx.Get(&inner, fmt.Sprintf(`SELECT COALESCE(SUM(length),0) AS length, MIN(tstart) AS tstart, MAX(tend) AS tend,
+		COALESCE(SUM(num_count),0) AS count, MIN(num_min) AS min, MAX(num_max) AS max, SUM(num_sum) AS sum
+		FROM %s WHERE tsid=? AND tstart>=? AND tend<?`, table), q.Timeseries, t1, t2)
+	if err != nil {
+		return nil, err
+	}
+	if inner.Length > 0 {
+		acc.addRange(inner.Length, inner.Tstart.Float64, inner.Tend.Float64)
+		acc.addSummary(inner.batchSummary)
+	}
+
+	// Batches that cross the edges of the range need to be read
+	rows, err := tx.Queryx(fmt.Sprintf("SELECT data FROM %s WHERE tsid=? AND tend>=? AND tstart<? AND NOT (tstart>=? AND tend<?)", table), q.Timeseries, t1, t2, t1, t2)
+	if err != nil {
+		return nil, err
+	}
+	bi := SQLBatchIterator{rows, nil}
+	defer bi.Close()
+	for {
+		dpa, err := bi.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if dpa == nil {
+			break
+		}
+		var s batchSummary
+		for _, dp := range dpa {
+			if dp.Timestamp >= t1 && dp.Timestamp < t2 {
+				acc.addRange(1, dp.Timestamp, dp.EndTime())
+				s.add(dp)
+			}
+		}
+		acc.addSummary(s)
+	}
+
+	return acc.stats(numeric), nil
+}
+
+// isNumericSchema returns true if the schema only allows numbers
+func isNumericSchema(schema map[string]interface{}) bool {
+	t, ok := schema["type"].(string)
+	return ok && (t == "number" || t == "integer")
+}
@@ -0,0 +1,114 @@
+package timeseries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	adb, oid1, oid2, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	s := TimeseriesDB{
+		DB:                    adb,
+		BatchSize:             2,
+		MaxBatchSize:          3,
+		BatchCompressionLevel: 3,
+	}
+
+	st, err := s.Stats(&Query{Timeseries: oid1}, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), st.Count)
+	require.Nil(t, st.T1)
+	require.Nil(t, st.Min)
+
+	require.NoError(t, s.Insert(oid1, NewDatapointArrayIterator(dpa6), nil))
+	require.NoError(t, s.Insert(oid2, NewDatapointArrayIterator(dpa1), nil))
+
+	st, err = s.Stats(&Query{Timeseries: oid1}, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), st.Count)
+	require.Equal(t, 1.0, *st.T1)
+	require.Equal(t, 5.0, *st.T2)
+	require.Equal(t, 1.0, *st.Min)
+	require.Equal(t, 5.0, *st.Max)
+	require.Equal(t, 15.0, *st.Sum)
+	require.Equal(t, 3.0, *st.Mean)
+
+	// Ranges that cut through batches
+	st, err = s.Stats(&Query{Timeseries: oid1, T1: 2.0, T2: 4.0}, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Count)
+	require.Equal(t, 2.0, *st.Min)
+	require.Equal(t, 3.0, *st.Max)
+	require.Equal(t, 5.0, *st.Sum)
+
+	st, err = s.Stats(&Query{Timeseries: oid1, T1: 3.0}, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), st.Count)
+	require.Equal(t, 3.0, *st.T1)
+	require.Nil(t, st.Mean)
+
+	// The summaries are updated when data is removed
+	require.NoError(t, s.Delete(&Query{Timeseries: oid1, T1: 5.0}))
+	st, err = s.Stats(&Query{Timeseries: oid1}, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), st.Count)
+	require.Equal(t, 4.0, *st.Max)
+
+	// Non-numeric data has no numeric statistics
+	st, err = s.Stats(&Query{Timeseries: oid2}, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), st.Count)
+	require.Equal(t, 1.0, *st.T1)
+	require.Equal(t, 2.0, *st.T2)
+	require.Nil(t, st.Min)
+
+	_, err = s.Stats(&Query{Timeseries: oid1, I1: new(int64)}, true)
+	require.Error(t, err)
+}
+
+func TestAddBatchSummaries(t *testing.T) {
+	adb, oid1, oid2, cleanup := newDBWithObjects(t)
+	defer cleanup()
+
+	s := TimeseriesDB{
+		DB:                    adb,
+		BatchSize:             2,
+		MaxBatchSize:          3,
+		BatchCompressionLevel: 3,
+	}
+
+	// Enough batches to need several pages
+	for _, oid := range []string{oid1, oid2} {
+		dpa := make(DatapointArray, 0, 2*summaryMigrationPageSize)
+		for i := 0; i < 2*summaryMigrationPageSize; i++ {
+			dpa = append(dpa, &Datapoint{float64(i), 0, float64(i % 7), ""})
+		}
+		require.NoError(t, s.Insert(oid, NewDatapointArrayIterator(dpa), nil))
+	}
+
+	type summary struct {
+		Tsid     string
+		Tstart   float64
+		NumCount int64    `db:"num_count"`
+		NumMin   *float64 `db:"num_min"`
+		NumMax   *float64 `db:"num_max"`
+		NumSum   *float64 `db:"num_sum"`
+	}
+	var expected []summary
+	require.NoError(t, adb.Select(&expected, "SELECT tsid,tstart,num_count,num_min,num_max,num_sum FROM timeseries ORDER BY tsid,tstart"))
+	require.Greater(t, len(expected), summaryMigrationPageSize)
+
+	// Go back to version 1 of the database, which had no summaries
+	for _, col := range []string{"num_count", "num_min", "num_max", "num_sum"} {
+		_, err := adb.Exec("ALTER TABLE timeseries DROP COLUMN " + col)
+		require.NoError(t, err)
+	}
+	require.NoError(t, addBatchSummaries(adb))
+
+	var summaries []summary
+	require.NoError(t, adb.Select(&summaries, "SELECT tsid,tstart,num_count,num_min,num_max,num_sum FROM timeseries ORDER BY tsid,tstart"))
+	require.Equal(t, expected, summaries)
+}
@@ -14,6 +14,13 @@ func Unix(t time.Time) float64 {
 }
 
 func ParseTimestamp(ts interface{}) (float64, error) {
+	return ParseTimestampIn(ts, time.Local)
+}
+
+// ParseTimestampIn parses the timestamp, resolving relative times and dates without
+// an explicit offset in the given timezone. Besides "now", the day-aligned "today",
+// "yesterday" and "tomorrow" are recognized, such as "today-2d" or "yesterday+8h".
+func ParseTimestampIn(ts interface{}, loc *time.Location) (float64, error) {
 	tss, ok := ts.(string)
 	if ok {
 		// First try to parse as a float64, and only then try tparse
@@ -23,8 +30,23 @@ func ParseTimestamp(ts interface{}) (float64, error) {
 		if err == nil {
 			return f, nil
 		}
+		now := time.Now().In(loc)
+		y, m, d := now.Date()
+		today := time.Date(y, m, d, 0, 0, 0, 0, loc)
 		// It is not a float, try parsing as string
-		t, err := tparse.ParseNow(time.RFC3339, tss)
+		t, err := tparse.ParseWithMap(time.RFC3339, tss, map[string]time.Time{
+			"now":       now,
+			"today":     today,
+			"yesterday": today.AddDate(0, 0, -1),
+			"tomorrow":  today.AddDate(0, 0, 1),
+		})
+		if err != nil {
+			// Dates without a timezone are in the given location
+			t2, err2 := time.ParseInLocation("2006-01-02", tss, loc)
+			if err2 == nil {
+				return Unix(t2), nil
+			}
+		}
 		return Unix(t), err
 	}
 	f, ok := ts.(float64)
@@ -0,0 +1,35 @@
+package timeseries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestampIn(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	ts, err := ParseTimestampIn("12.5", loc)
+	require.NoError(t, err)
+	require.Equal(t, 12.5, ts)
+
+	ts, err = ParseTimestampIn("2021-06-01", loc)
+	require.NoError(t, err)
+	require.Equal(t, Unix(time.Date(2021, 6, 1, 0, 0, 0, 0, loc)), ts)
+
+	y, m, d := time.Now().In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	ts, err = ParseTimestampIn("today", loc)
+	require.NoError(t, err)
+	require.Equal(t, Unix(today), ts)
+
+	ts, err = ParseTimestampIn("yesterday+6h", loc)
+	require.NoError(t, err)
+	require.Equal(t, Unix(today.AddDate(0, 0, -1).Add(6*time.Hour)), ts)
+
+	_, err = ParseTimestampIn("notatime", loc)
+	require.Error(t, err)
+}